@@ -0,0 +1,188 @@
+package audio
+
+import (
+	"io"
+	"math"
+	"math/cmplx"
+	"sort"
+	"time"
+)
+
+const (
+	windowSize = 1024
+	hopSize    = 512
+
+	// NumBands matches the seven motors, one band each
+	NumBands = 7
+
+	fluxHistory     = 86 // About one second of hops at 44.1kHz
+	onsetThreshold  = 1.5
+	minBeatInterval = 250 * time.Millisecond
+	peakDecay       = 0.999 // Per frame decay of the band normalisation peaks
+)
+
+// bandEdges are the band limits in Hz, from sub-bass to air.
+var bandEdges = [NumBands + 1]float64{20, 60, 250, 500, 2000, 4000, 6000, 16000}
+
+// Frame is the analysis of one hop of audio.
+type Frame struct {
+	Time  time.Duration     `json:"time"`
+	Level float64           `json:"level"` // RMS level, 0..1
+	Bands [NumBands]float64 `json:"bands"` // Band energy normalised to its recent peak, 0..1
+	Flux  float64           `json:"flux"`  // Spectral flux
+	Onset bool              `json:"onset"`
+	Beat  bool              `json:"beat"`
+	Tempo float64           `json:"tempo"` // Estimated BPM, 0 until enough beats
+}
+
+// Analyzer turns a stream of samples into frames of band energies, onsets
+// and beats.
+type Analyzer struct {
+	sampleRate int
+	window     []float64
+	buffer     []float64
+	processed  int // Samples consumed before the start of buffer
+
+	spectrum    []float64
+	previous    []float64
+	flux        []float64
+	peaks       [NumBands]float64
+	bassAverage float64
+	lastBeat    time.Duration
+	intervals   []time.Duration
+}
+
+func NewAnalyzer(sampleRate int) *Analyzer {
+	return &Analyzer{
+		sampleRate: sampleRate,
+		window:     hannWindow(windowSize),
+		spectrum:   make([]float64, windowSize/2),
+		previous:   make([]float64, windowSize/2),
+		lastBeat:   -minBeatInterval,
+	}
+}
+
+// Process adds samples and returns a frame for every complete hop.
+func (a *Analyzer) Process(samples []float64) []Frame {
+	a.buffer = append(a.buffer, samples...)
+
+	var frames []Frame
+	for len(a.buffer) >= windowSize {
+		frames = append(frames, a.analyze(a.buffer[:windowSize]))
+		a.buffer = a.buffer[hopSize:]
+		a.processed += hopSize
+	}
+	return frames
+}
+
+func (a *Analyzer) analyze(samples []float64) Frame {
+	// Frames are stamped with the centre of their window
+	frame := Frame{Time: time.Duration(float64(a.processed+windowSize/2) / float64(a.sampleRate) * float64(time.Second))}
+
+	x := make([]complex128, windowSize)
+	var sumSquares float64
+	for i, v := range samples {
+		x[i] = complex(v*a.window[i], 0)
+		sumSquares += v * v
+	}
+	frame.Level = math.Min(1, math.Sqrt(sumSquares/windowSize)*math.Sqrt2)
+	fft(x)
+
+	for i := range a.spectrum {
+		a.spectrum[i] = cmplx.Abs(x[i]) / windowSize
+	}
+
+	// Spectral flux only counts rising energy so decays don't look like onsets
+	for i, mag := range a.spectrum {
+		frame.Flux += math.Max(0, mag-a.previous[i])
+	}
+	copy(a.previous, a.spectrum)
+
+	binHz := float64(a.sampleRate) / windowSize
+	var raw [NumBands]float64
+	for band := 0; band < NumBands; band++ {
+		lo := int(bandEdges[band] / binHz)
+		hi := min(len(a.spectrum), int(bandEdges[band+1]/binHz)+1)
+		for bin := lo; bin < hi; bin++ {
+			raw[band] += a.spectrum[bin] * a.spectrum[bin]
+		}
+		raw[band] = math.Sqrt(raw[band])
+
+		a.peaks[band] = math.Max(raw[band], a.peaks[band]*peakDecay)
+		if a.peaks[band] > 1e-9 {
+			frame.Bands[band] = raw[band] / a.peaks[band]
+		}
+	}
+
+	frame.Onset = a.detectOnset(frame.Flux)
+
+	// A beat is an onset carried by the low end, not too soon after the last
+	bass := raw[0] + raw[1]
+	if frame.Onset && bass > a.bassAverage && frame.Time-a.lastBeat >= minBeatInterval {
+		frame.Beat = true
+		if a.lastBeat >= 0 {
+			a.intervals = append(a.intervals, frame.Time-a.lastBeat)
+			if len(a.intervals) > 8 {
+				a.intervals = a.intervals[1:]
+			}
+		}
+		a.lastBeat = frame.Time
+	}
+	a.bassAverage = a.bassAverage*0.95 + bass*0.05
+	frame.Tempo = a.tempo()
+
+	return frame
+}
+
+// detectOnset compares the flux with its mean and spread over the last
+// second.
+func (a *Analyzer) detectOnset(flux float64) bool {
+	onset := false
+	if len(a.flux) >= fluxHistory/2 {
+		var mean, variance float64
+		for _, f := range a.flux {
+			mean += f
+		}
+		mean /= float64(len(a.flux))
+		for _, f := range a.flux {
+			variance += (f - mean) * (f - mean)
+		}
+		std := math.Sqrt(variance / float64(len(a.flux)))
+		onset = flux > mean+onsetThreshold*std && flux > 1e-4
+	}
+
+	a.flux = append(a.flux, flux)
+	if len(a.flux) > fluxHistory {
+		a.flux = a.flux[1:]
+	}
+	return onset
+}
+
+// tempo is the median of the recent beat intervals in BPM.
+func (a *Analyzer) tempo() float64 {
+	if len(a.intervals) < 4 {
+		return 0
+	}
+	sorted := make([]time.Duration, len(a.intervals))
+	copy(sorted, a.intervals)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return 60 / sorted[len(sorted)/2].Seconds()
+}
+
+// Analyze reads a whole stream and returns its frames.
+func Analyze(s *Stream) ([]Frame, error) {
+	analyzer := NewAnalyzer(s.SampleRate)
+	buf := make([]float64, hopSize)
+
+	var frames []Frame
+	for {
+		n, err := s.Read(buf)
+		frames = append(frames, analyzer.Process(buf[:n])...)
+		if err == io.EOF {
+			return frames, nil
+		}
+		if err != nil {
+			return frames, err
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package audio
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+)
+
+const (
+	sampleRate = 44100
+	clavesFile = "../../midi_files/LS_4-4_NmlStr_Claves_151.mid"
+
+	// The file has no tempo event, so it plays at the MIDI default of 120
+	// BPM whatever its name says
+	clavesBPM = 120
+)
+
+// clavesHits are the note starts of the claves file, in milliseconds.
+var clavesHits = []time.Duration{753, 2498, 3246, 3751, 4749, 6750}
+
+// renderWAV renders a MIDI file and reads it back as a WAV stream, the way
+// a recording of it would come in.
+func renderWAV(t *testing.T, path string) (*Stream, []float64) {
+	t.Helper()
+	samples, err := RenderMIDI(path, sampleRate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := WriteWAV(&buf, samples, sampleRate); err != nil {
+		t.Fatal(err)
+	}
+	s, err := ReadWAV(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, samples
+}
+
+func TestWAVRoundTrip(t *testing.T) {
+	s, samples := renderWAV(t, clavesFile)
+	if s.SampleRate != sampleRate {
+		t.Errorf("sample rate %d, want %d", s.SampleRate, sampleRate)
+	}
+
+	read := make([]float64, 0, len(samples))
+	buf := make([]float64, 4096)
+	for {
+		n, err := s.Read(buf)
+		read = append(read, buf[:n]...)
+		if err != nil {
+			break
+		}
+	}
+	if len(read) != len(samples) {
+		t.Fatalf("read %d samples, wrote %d", len(read), len(samples))
+	}
+	for i := range samples {
+		// 16-bit samples are within a couple of steps of what was written
+		if math.Abs(read[i]-samples[i]) > 2.0/32767 {
+			t.Fatalf("sample %d is %v, wrote %v", i, read[i], samples[i])
+		}
+	}
+}
+
+func TestAnalyzeClaves(t *testing.T) {
+	s, _ := renderWAV(t, clavesFile)
+	frames, err := Analyze(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every hit is a beat, found within a hop or two of the note
+	var beats []time.Duration
+	for _, frame := range frames {
+		if frame.Beat {
+			beats = append(beats, frame.Time)
+		}
+	}
+	if len(beats) != len(clavesHits) {
+		t.Fatalf("found beats at %v, want one at each hit %v", beats, clavesHits)
+	}
+	for i, hit := range clavesHits {
+		hit *= time.Millisecond
+		if d := beats[i] - hit; d < -25*time.Millisecond || d > 25*time.Millisecond {
+			t.Errorf("beat %d at %s, hit at %s", i, beats[i], hit)
+		}
+	}
+
+	// The claves only play on some beats, so the estimate can be a half or
+	// a double of the tempo
+	tempo := frames[len(frames)-1].Tempo
+	if tempo <= 0 {
+		t.Fatal("no tempo estimate")
+	}
+	folded := tempo
+	for folded < clavesBPM*0.75 {
+		folded *= 2
+	}
+	for folded > clavesBPM*1.5 {
+		folded /= 2
+	}
+	if math.Abs(folded-clavesBPM) > clavesBPM*0.03 {
+		t.Errorf("tempo %.1f BPM, want about %d or a half or double of it", tempo, clavesBPM)
+	}
+}
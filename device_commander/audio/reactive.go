@@ -0,0 +1,217 @@
+package audio
+
+import (
+	"device_commander/lights"
+	"device_commander/motors"
+	"device_commander/show"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+// Mapping controls how the analysis drives the motors and lights.
+type Mapping struct {
+	MaxSpeed      float64       // Motor speed at full band energy
+	Threshold     float64       // Band energy below which a motor stands still
+	MotorInterval time.Duration // How often motor speeds are updated
+	HueStep       float64       // Degrees the light hue moves on every beat
+	FlashDecay    time.Duration
+}
+
+var DefaultMapping = Mapping{
+	MaxSpeed:      8,
+	Threshold:     0.2,
+	MotorInterval: 200 * time.Millisecond,
+	HueStep:       37,
+	FlashDecay:    150 * time.Millisecond,
+}
+
+// speed maps a band energy to a motor speed, rounded so small changes don't
+// turn into serial traffic.
+func (m Mapping) speed(energy float64) float64 {
+	if energy < m.Threshold {
+		return 0
+	}
+	return math.Round(m.MaxSpeed*energy*4) / 4
+}
+
+// Reactor drives a light animation and the motors from analysis frames as
+// they arrive.
+type Reactor struct {
+	mapping Mapping
+	move    show.MotorFunc
+
+	mu         sync.Mutex
+	latest     Frame
+	hue        float64
+	beatAt     time.Time
+	speeds     [NumBands]float64
+	lastMotors time.Duration
+}
+
+func NewReactor(mapping Mapping, move show.MotorFunc) *Reactor {
+	r := &Reactor{mapping: mapping, move: move, lastMotors: -mapping.MotorInterval}
+	for i := range r.speeds {
+		r.speeds[i] = math.NaN() // Forces the first update out
+	}
+	return r
+}
+
+// Animation renders the latest frame: bass in the middle of the panel,
+// treble at the edge, brightness following the level and a white flash on
+// every beat.
+func (r *Reactor) Animation() lights.Animation {
+	positions := lights.LEDPositions()
+	rings := make([]int, len(positions))
+	for i, p := range positions {
+		d := math.Hypot(p.X-lights.LUT_W/2.0, p.Y-lights.LUT_H/2.0) / (lights.LUT_H / 2.0)
+		rings[i] = min(NumBands-1, int(d*NumBands))
+	}
+
+	return lights.Animation{
+		Name: "audio",
+		Render: func(t time.Duration, leds []lights.RGBW) {
+			r.mu.Lock()
+			frame, hue, beatAt := r.latest, r.hue, r.beatAt
+			r.mu.Unlock()
+
+			flash := 0.0
+			if since := time.Since(beatAt); since < r.mapping.FlashDecay {
+				flash = 1 - float64(since)/float64(r.mapping.FlashDecay)
+			}
+			for i := range leds {
+				band := rings[i]
+				led := lights.HSV(hue+float64(band)*30, 1, frame.Bands[band]*math.Min(1, frame.Level*4))
+				led.W = uint8(255 * flash)
+				leds[i] = led
+			}
+		},
+	}
+}
+
+// Update takes a new frame and moves the motors if their speeds changed.
+func (r *Reactor) Update(frame Frame) {
+	r.mu.Lock()
+	r.latest = frame
+	if frame.Beat {
+		r.hue += r.mapping.HueStep
+		r.beatAt = time.Now()
+	}
+
+	var changed []int
+	if frame.Time-r.lastMotors >= r.mapping.MotorInterval {
+		r.lastMotors = frame.Time
+		for motorId, energy := range frame.Bands {
+			if speed := r.mapping.speed(energy); speed != r.speeds[motorId] {
+				r.speeds[motorId] = speed
+				changed = append(changed, motorId)
+			}
+		}
+	}
+	speeds := r.speeds
+	r.mu.Unlock()
+
+	for _, motorId := range changed {
+		if err := r.move(motorId, speeds[motorId]); err != nil {
+			log.Printf("Error moving motor %d: %v", motorId, err)
+		}
+	}
+}
+
+// Run analyses a stream and feeds every frame to Update. With realtime set
+// frames are released at the pace of the audio, for files that can be read
+// faster than they play; streams that arrive live (stdin) need no pacing.
+func (r *Reactor) Run(s *Stream, realtime bool) error {
+	analyzer := NewAnalyzer(s.SampleRate)
+	buf := make([]float64, hopSize)
+	start := time.Now()
+
+	defer func() {
+		for motorId := 0; motorId < NumBands; motorId++ {
+			r.move(motorId, 0)
+		}
+	}()
+
+	for {
+		n, err := s.Read(buf)
+		for _, frame := range analyzer.Process(buf[:n]) {
+			if realtime {
+				time.Sleep(time.Until(start.Add(frame.Time)))
+			}
+			r.Update(frame)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading audio: %w", err)
+		}
+	}
+}
+
+// ToShow turns offline analysis into a show: each motor follows its band in
+// segments of the motor interval, and the panel flashes on every beat.
+func ToShow(frames []Frame, name string, m Mapping) *show.Show {
+	s := &show.Show{Name: name}
+
+	for motorId := 0; motorId < NumBands; motorId++ {
+		mp := motors.MotorPattern{MotorId: motorId}
+		var windowStart time.Duration
+		var sum float64
+		var count int
+
+		flush := func(end time.Duration) {
+			if count == 0 {
+				return
+			}
+			speed := m.speed(sum / float64(count))
+			duration := int((end - windowStart) / time.Millisecond)
+			// Merge with the previous segment when the speed didn't change
+			if last := len(mp.Segments) - 1; last >= 0 && mp.Segments[last].Speed == speed {
+				mp.Segments[last].Duration += duration
+			} else {
+				mp.Segments = append(mp.Segments, motors.Segment{Duration: duration, Speed: speed})
+			}
+			windowStart, sum, count = end, 0, 0
+		}
+
+		for _, frame := range frames {
+			if frame.Time-windowStart >= m.MotorInterval {
+				flush(windowStart + m.MotorInterval)
+			}
+			sum += frame.Bands[motorId]
+			count++
+		}
+		if len(frames) > 0 {
+			flush(frames[len(frames)-1].Time)
+		}
+		mp.Segments = append(mp.Segments, motors.Segment{Duration: 0, Speed: 0})
+		s.Motors.Patterns = append(s.Motors.Patterns, mp)
+	}
+
+	hue := 0.0
+	for _, frame := range frames {
+		if !frame.Beat {
+			continue
+		}
+		hue += m.HueStep
+		color := lights.HSV(hue, 1, 1)
+		s.Lights = append(s.Lights, show.LightCue{
+			At: int(frame.Time / time.Millisecond),
+			PlaylistEntry: lights.PlaylistEntry{
+				Animation: "flash",
+				Params: map[string]float64{
+					"r":     float64(color.R),
+					"g":     float64(color.G),
+					"b":     float64(color.B),
+					"w":     0,
+					"decay": float64(m.FlashDecay / time.Millisecond),
+				},
+			},
+		})
+	}
+	return s
+}
@@ -0,0 +1,101 @@
+package audio
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+const (
+	drumChannel   = 9
+	attackTime    = 0.005
+	releaseTime   = 0.1
+	drumDecayTime = 0.08
+	voiceGain     = 0.2
+)
+
+type note struct {
+	start, end float64 // Seconds
+	key        uint8
+	velocity   uint8
+	drum       bool
+}
+
+// RenderMIDI renders a MIDI file to mono samples with a simple sine and
+// noise synth. It is only meant to turn the MIDI material in the repo into
+// audio for testing the analysis without sound hardware.
+func RenderMIDI(path string, sampleRate int) ([]float64, error) {
+	type held struct {
+		start    float64
+		velocity uint8
+	}
+	active := make(map[[3]int]held) // Track, channel, key
+	var notes []note
+	var end float64
+
+	reader := smf.ReadTracks(path)
+	reader.Do(func(te smf.TrackEvent) {
+		t := float64(te.AbsMicroSeconds) / 1e6
+		end = math.Max(end, t)
+
+		var ch, key, vel uint8
+		switch {
+		case te.Message.GetNoteStart(&ch, &key, &vel):
+			active[[3]int{te.TrackNo, int(ch), int(key)}] = held{start: t, velocity: vel}
+		case te.Message.GetNoteEnd(&ch, &key):
+			id := [3]int{te.TrackNo, int(ch), int(key)}
+			if h, ok := active[id]; ok {
+				notes = append(notes, note{start: h.start, end: t, key: key, velocity: h.velocity, drum: ch == drumChannel})
+				delete(active, id)
+			}
+		}
+	})
+	if err := reader.Error(); err != nil {
+		return nil, fmt.Errorf("error reading MIDI file: %w", err)
+	}
+	for id, h := range active {
+		notes = append(notes, note{start: h.start, end: end, key: uint8(id[2]), velocity: h.velocity, drum: id[1] == drumChannel})
+	}
+
+	samples := make([]float64, int((end+releaseTime+0.5)*float64(sampleRate)))
+	noise := rand.New(rand.NewSource(1)) // Fixed seed so renders are repeatable
+	for _, n := range notes {
+		gain := voiceGain * float64(n.velocity) / 127
+		first := int(n.start * float64(sampleRate))
+
+		if n.drum {
+			length := int(drumDecayTime * float64(sampleRate))
+			for i := 0; i < length && first+i < len(samples); i++ {
+				envelope := 1 - float64(i)/float64(length)
+				samples[first+i] += gain * envelope * (noise.Float64()*2 - 1)
+			}
+			continue
+		}
+
+		freq := 440 * math.Pow(2, (float64(n.key)-69)/12)
+		duration := n.end - n.start
+		last := min(len(samples), int((n.end+releaseTime)*float64(sampleRate)))
+		for i := first; i < last; i++ {
+			t := float64(i-first) / float64(sampleRate)
+			envelope := math.Min(1, t/attackTime)
+			if t > duration {
+				envelope *= math.Max(0, 1-(t-duration)/releaseTime)
+			}
+			samples[i] += gain * envelope * math.Sin(2*math.Pi*freq*t)
+		}
+	}
+
+	// Normalise so dense passages don't clip
+	var peak float64
+	for _, v := range samples {
+		peak = math.Max(peak, math.Abs(v))
+	}
+	if peak > 0.9 {
+		for i := range samples {
+			samples[i] *= 0.9 / peak
+		}
+	}
+	return samples, nil
+}
@@ -0,0 +1,187 @@
+package audio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Format describes interleaved PCM samples.
+type Format struct {
+	SampleRate    int
+	Channels      int
+	BitsPerSample int  // 8, 16, 24 or 32
+	Float         bool // 32-bit IEEE float instead of integer samples
+}
+
+func (f Format) Validate() error {
+	if f.SampleRate <= 0 || f.Channels <= 0 {
+		return fmt.Errorf("invalid PCM format: %d Hz, %d channels", f.SampleRate, f.Channels)
+	}
+	switch {
+	case f.Float && f.BitsPerSample == 32:
+	case !f.Float && (f.BitsPerSample == 8 || f.BitsPerSample == 16 || f.BitsPerSample == 24 || f.BitsPerSample == 32):
+	default:
+		return fmt.Errorf("unsupported sample format: %d bits, float %v", f.BitsPerSample, f.Float)
+	}
+	return nil
+}
+
+// Stream reads PCM audio as mono samples between -1 and 1.
+type Stream struct {
+	Format
+	r     *bufio.Reader
+	frame []byte
+}
+
+// NewPCMStream reads raw interleaved little-endian PCM, e.g. from stdin.
+func NewPCMStream(r io.Reader, format Format) (*Stream, error) {
+	if err := format.Validate(); err != nil {
+		return nil, err
+	}
+	return &Stream{
+		Format: format,
+		r:      bufio.NewReader(r),
+		frame:  make([]byte, format.Channels*format.BitsPerSample/8),
+	}, nil
+}
+
+// ReadWAV parses a RIFF WAVE header and returns a stream positioned at the
+// start of the sample data.
+func ReadWAV(r io.Reader) (*Stream, error) {
+	br := bufio.NewReader(r)
+
+	var riff [12]byte
+	if _, err := io.ReadFull(br, riff[:]); err != nil {
+		return nil, fmt.Errorf("error reading WAV header: %w", err)
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return nil, fmt.Errorf("not a WAV file")
+	}
+
+	var format *Format
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(br, header[:]); err != nil {
+			return nil, fmt.Errorf("error reading WAV chunk: %w", err)
+		}
+		id := string(header[0:4])
+		size := int64(binary.LittleEndian.Uint32(header[4:8]))
+
+		switch id {
+		case "fmt ":
+			chunk := make([]byte, size)
+			if _, err := io.ReadFull(br, chunk); err != nil {
+				return nil, fmt.Errorf("error reading WAV format: %w", err)
+			}
+			if len(chunk) < 16 {
+				return nil, fmt.Errorf("WAV format chunk too short")
+			}
+			if size%2 == 1 {
+				br.Discard(1)
+			}
+			tag := binary.LittleEndian.Uint16(chunk[0:2])
+			// WAVE_FORMAT_EXTENSIBLE keeps the real tag in the sub-format GUID
+			if tag == 0xfffe && len(chunk) >= 26 {
+				tag = binary.LittleEndian.Uint16(chunk[24:26])
+			}
+			if tag != 1 && tag != 3 {
+				return nil, fmt.Errorf("unsupported WAV encoding: %d", tag)
+			}
+			format = &Format{
+				SampleRate:    int(binary.LittleEndian.Uint32(chunk[4:8])),
+				Channels:      int(binary.LittleEndian.Uint16(chunk[2:4])),
+				BitsPerSample: int(binary.LittleEndian.Uint16(chunk[14:16])),
+				Float:         tag == 3,
+			}
+		case "data":
+			if format == nil {
+				return nil, fmt.Errorf("WAV data before format")
+			}
+			return NewPCMStream(io.LimitReader(br, size), *format)
+		default:
+			// Chunks are padded to an even size
+			if _, err := br.Discard(int(size + size%2)); err != nil {
+				return nil, fmt.Errorf("error skipping WAV chunk %q: %w", id, err)
+			}
+		}
+	}
+}
+
+// Read fills buf with mono samples, mixing down all channels. It returns
+// io.EOF once the stream is exhausted.
+func (s *Stream) Read(buf []float64) (int, error) {
+	bytesPerSample := s.BitsPerSample / 8
+	for n := range buf {
+		if _, err := io.ReadFull(s.r, s.frame); err != nil {
+			if n > 0 && (err == io.EOF || err == io.ErrUnexpectedEOF) {
+				return n, nil
+			}
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+			return n, err
+		}
+
+		var sum float64
+		for ch := 0; ch < s.Channels; ch++ {
+			sum += s.decode(s.frame[ch*bytesPerSample : (ch+1)*bytesPerSample])
+		}
+		buf[n] = sum / float64(s.Channels)
+	}
+	return len(buf), nil
+}
+
+func (s *Stream) decode(b []byte) float64 {
+	switch {
+	case s.Float:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+	case s.BitsPerSample == 8:
+		return (float64(b[0]) - 128) / 128 // 8-bit WAV is unsigned
+	case s.BitsPerSample == 16:
+		return float64(int16(binary.LittleEndian.Uint16(b))) / 32768
+	case s.BitsPerSample == 24:
+		v := int32(b[0]) | int32(b[1])<<8 | int32(b[2])<<16
+		if v&0x800000 != 0 {
+			v -= 1 << 24
+		}
+		return float64(v) / (1 << 23)
+	default:
+		return float64(int32(binary.LittleEndian.Uint32(b))) / (1 << 31)
+	}
+}
+
+// WriteWAV writes mono samples as a 16-bit PCM WAV file.
+func WriteWAV(w io.Writer, samples []float64, sampleRate int) error {
+	dataSize := uint32(len(samples) * 2)
+	header := make([]byte, 44)
+	copy(header[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:8], 36+dataSize)
+	copy(header[8:12], "WAVE")
+	copy(header[12:16], "fmt ")
+	binary.LittleEndian.PutUint32(header[16:20], 16)
+	binary.LittleEndian.PutUint16(header[20:22], 1) // PCM
+	binary.LittleEndian.PutUint16(header[22:24], 1) // Mono
+	binary.LittleEndian.PutUint32(header[24:28], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(header[28:32], uint32(sampleRate*2))
+	binary.LittleEndian.PutUint16(header[32:34], 2)
+	binary.LittleEndian.PutUint16(header[34:36], 16)
+	copy(header[36:40], "data")
+	binary.LittleEndian.PutUint32(header[40:44], dataSize)
+
+	bw := bufio.NewWriter(w)
+	if _, err := bw.Write(header); err != nil {
+		return err
+	}
+	sample := make([]byte, 2)
+	for _, v := range samples {
+		v = math.Max(-1, math.Min(1, v))
+		binary.LittleEndian.PutUint16(sample, uint16(int16(v*32767)))
+		if _, err := bw.Write(sample); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,863 @@
+package main
+
+import (
+	"device_commander/audio"
+	"device_commander/comms"
+	"device_commander/emulator"
+	"device_commander/lights"
+	"device_commander/livemidi"
+	"device_commander/motors"
+	"device_commander/recording"
+	"device_commander/telemetry"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// runCommand runs a headless subcommand and returns the exit code.
+func runCommand(args []string) int {
+	commands := map[string]func([]string) error{
+		"export-lights":   exportLightsCommand,
+		"render-midi-wav": renderMIDIWAVCommand,
+		"audio-show":      audioShowCommand,
+		"audio-live":      audioLiveCommand,
+		"midi-live":       midiLiveCommand,
+		"export-midi":     exportMIDICommand,
+		"import-midi":     importMIDICommand,
+		"generate-motion": generateMotionCommand,
+		"pattern":         patternCommand,
+		"simulate":        simulateCommand,
+		"tune":            tuneCommand,
+		"telemetry":       telemetryCommand,
+		"replay":          replayCommand,
+	}
+
+	command, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", args[0])
+		fmt.Fprintln(os.Stderr, "Commands:")
+		for name := range commands {
+			fmt.Fprintf(os.Stderr, "  %s\n", name)
+		}
+		return 2
+	}
+	if err := command(args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+func exportLightsCommand(args []string) error {
+	fs := flag.NewFlagSet("export-lights", flag.ContinueOnError)
+	name := fs.String("animation", "rainbowHueShift", "animation to render, or \"media\" with -file")
+	file := fs.String("file", "", "image, GIF or raw frames for the media animation")
+	params := fs.String("params", "", "animation parameters, e.g. cycle=10,hold=500")
+	output := fs.String("o", "animation.gif", "output file, .gif for an animation or .png for a frame sheet")
+	opts := lights.DefaultExportOptions
+	fs.DurationVar(&opts.Duration, "duration", opts.Duration, "length to render")
+	fs.Float64Var(&opts.FPS, "fps", opts.FPS, "frames per second")
+	fs.Float64Var(&opts.CellSize, "cell", opts.CellSize, "pixels per LUT cell")
+	fs.IntVar(&opts.Columns, "columns", opts.Columns, "frames per row of a PNG sheet")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	parsed, err := parseParams(*params)
+	if err != nil {
+		return err
+	}
+	entry := lights.PlaylistEntry{Animation: *name, File: *file, Params: parsed}
+	animation, err := entry.Build()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if strings.ToLower(filepath.Ext(*output)) == ".png" {
+		err = lights.ExportPNGSheet(f, animation, opts)
+	} else {
+		err = lights.ExportGIF(f, animation, opts)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Rendered %s to %s\n", animation.Name, *output)
+	return nil
+}
+
+// parseParams parses comma separated key=value animation parameters.
+func parseParams(s string) (map[string]float64, error) {
+	params := make(map[string]float64)
+	if s == "" {
+		return params, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid parameter %q, expected key=value", pair)
+		}
+		var v float64
+		if _, err := fmt.Sscanf(value, "%g", &v); err != nil {
+			return nil, fmt.Errorf("invalid value for parameter %s: %v", key, err)
+		}
+		params[strings.TrimSpace(key)] = v
+	}
+	return params, nil
+}
+
+func renderMIDIWAVCommand(args []string) error {
+	fs := flag.NewFlagSet("render-midi-wav", flag.ContinueOnError)
+	input := fs.String("i", "", "MIDI file to render")
+	output := fs.String("o", "", "WAV file to write, defaults to the MIDI name with .wav")
+	rate := fs.Int("rate", 22050, "sample rate")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *input == "" {
+		return fmt.Errorf("no MIDI file given, use -i")
+	}
+	if *output == "" {
+		*output = strings.TrimSuffix(filepath.Base(*input), filepath.Ext(*input)) + ".wav"
+	}
+
+	samples, err := audio.RenderMIDI(*input, *rate)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := audio.WriteWAV(f, samples, *rate); err != nil {
+		return err
+	}
+	fmt.Printf("Rendered %s to %s (%.1fs)\n", *input, *output, float64(len(samples))/float64(*rate))
+	return nil
+}
+
+// audioFlags are the input flags shared by the audio commands.
+type audioFlags struct {
+	input    *string
+	rate     *int
+	channels *int
+	bits     *int
+}
+
+func addAudioFlags(fs *flag.FlagSet) audioFlags {
+	return audioFlags{
+		input:    fs.String("i", "-", "WAV file, raw PCM file, or - for raw PCM on stdin"),
+		rate:     fs.Int("rate", 44100, "sample rate of raw PCM"),
+		channels: fs.Int("channels", 2, "channels of raw PCM"),
+		bits:     fs.Int("bits", 16, "bits per sample of raw PCM"),
+	}
+}
+
+// open returns the audio stream and whether it comes from a file, which can
+// be read faster than real time.
+func (a audioFlags) open() (*audio.Stream, io.Closer, bool, error) {
+	format := audio.Format{SampleRate: *a.rate, Channels: *a.channels, BitsPerSample: *a.bits}
+	if *a.input == "-" {
+		stream, err := audio.NewPCMStream(os.Stdin, format)
+		return stream, io.NopCloser(nil), false, err
+	}
+
+	f, err := os.Open(*a.input)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	var stream *audio.Stream
+	if strings.ToLower(filepath.Ext(*a.input)) == ".wav" {
+		stream, err = audio.ReadWAV(f)
+	} else {
+		stream, err = audio.NewPCMStream(f, format)
+	}
+	if err != nil {
+		f.Close()
+		return nil, nil, false, err
+	}
+	return stream, f, true, nil
+}
+
+func audioShowCommand(args []string) error {
+	fs := flag.NewFlagSet("audio-show", flag.ContinueOnError)
+	in := addAudioFlags(fs)
+	output := fs.String("o", "audio_show.json", "show file to write")
+	mapping := audio.DefaultMapping
+	fs.Float64Var(&mapping.MaxSpeed, "max-speed", mapping.MaxSpeed, "motor speed at full band energy")
+	fs.DurationVar(&mapping.MotorInterval, "segment", mapping.MotorInterval, "length of motor segments")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	stream, closer, _, err := in.open()
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	frames, err := audio.Analyze(stream)
+	if err != nil {
+		return err
+	}
+	name := strings.TrimSuffix(filepath.Base(*in.input), filepath.Ext(*in.input))
+	s := audio.ToShow(frames, name, mapping)
+
+	data, err := json.MarshalIndent(s, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*output, data, 0644); err != nil {
+		return err
+	}
+
+	beats := len(s.Lights)
+	tempo := 0.0
+	if len(frames) > 0 {
+		tempo = frames[len(frames)-1].Tempo
+	}
+	fmt.Printf("Analysed %d frames: %d beats, ~%.0f BPM, show written to %s\n", len(frames), beats, tempo, *output)
+	return nil
+}
+
+// audioLiveCommand drives the panel and, unless disabled, the motors from
+// audio in real time.
+func audioLiveCommand(args []string) error {
+	fs := flag.NewFlagSet("audio-live", flag.ContinueOnError)
+	in := addAudioFlags(fs)
+	withMotors := fs.Bool("motors", true, "connect to the devices and drive the motors")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	stream, closer, isFile, err := in.open()
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	setupLogging()
+	defer logFile.Close()
+	initLights()
+	go lightEngine.Run()
+
+	move := func(motorId int, speed float64) error { return nil }
+	if *withMotors {
+		connectDevices()
+		move = moveMotor
+	}
+
+	reactor := audio.NewReactor(audio.DefaultMapping, move)
+	lightEngine.Play(reactor.Animation(), lights.Transition{})
+	log.Printf("Playing audio from %s", *in.input)
+
+	start := time.Now()
+	if err := reactor.Run(stream, isFile); err != nil {
+		return err
+	}
+	fmt.Printf("Played %s in %s\n", *in.input, time.Since(start).Round(time.Second))
+	return nil
+}
+
+// midiLiveCommand lets incoming MIDI play the motors and lights.
+func midiLiveCommand(args []string) error {
+	fs := flag.NewFlagSet("midi-live", flag.ContinueOnError)
+	source := fs.String("source", "tcp::5004", "tcp:<addr>, udp:<addr>, port:<name> or a raw MIDI device")
+	profilePath := fs.String("profile", "", "mapping profile, defaults to the built in profile")
+	withMotors := fs.Bool("motors", true, "connect to the devices and drive the motors")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	profile := livemidi.DefaultProfile
+	if *profilePath != "" {
+		var err error
+		if profile, err = livemidi.LoadProfile(*profilePath); err != nil {
+			return err
+		}
+	}
+
+	setupLogging()
+	defer logFile.Close()
+	initLights()
+	go lightEngine.Run()
+
+	move := func(motorId int, speed float64) error { return nil }
+	if *withMotors {
+		connectDevices()
+		move = moveMotor
+	}
+
+	instrument := livemidi.NewInstrument(profile, move)
+	lightEngine.Play(instrument.Animation(), lights.Transition{Kind: lights.TransitionCrossfade, Duration: 500})
+	fmt.Printf("Playing the lamp from %s with the %s profile\n", *source, profile.Name)
+	return instrument.Listen(*source)
+}
+
+func addMIDIFlags(fs *flag.FlagSet) *motors.MIDIOptions {
+	opts := motors.DefaultMIDIOptions
+	fs.StringVar(&opts.Encoding, "encoding", opts.Encoding, "speed encoding, velocity or cc")
+	fs.Float64Var(&opts.MaxSpeed, "max-speed", opts.MaxSpeed, "speed at full velocity or controller value")
+	fs.Func("cc", fmt.Sprintf("controller carrying the speed MSB for cc encoding, 0-31 (default %d)", opts.Controller), func(s string) error {
+		_, err := fmt.Sscanf(s, "%d", &opts.Controller)
+		return err
+	})
+	return &opts
+}
+
+func exportMIDICommand(args []string) error {
+	fs := flag.NewFlagSet("export-midi", flag.ContinueOnError)
+	input := fs.String("i", "", "pattern JSON to export")
+	output := fs.String("o", "", "MIDI file to write, defaults to the pattern name with .mid")
+	opts := addMIDIFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *input == "" {
+		return fmt.Errorf("no pattern given, use -i")
+	}
+	if *output == "" {
+		*output = strings.TrimSuffix(filepath.Base(*input), filepath.Ext(*input)) + ".mid"
+	}
+
+	data, err := os.ReadFile(*input)
+	if err != nil {
+		return err
+	}
+	var pattern motors.Pattern
+	if err := json.Unmarshal(data, &pattern); err != nil {
+		return fmt.Errorf("error parsing pattern: %w", err)
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := motors.ExportMIDI(f, &pattern, *opts); err != nil {
+		return err
+	}
+	fmt.Printf("Exported %d motors (%s) to %s\n", len(pattern.Patterns), pattern.Length(), *output)
+	return nil
+}
+
+func importMIDICommand(args []string) error {
+	fs := flag.NewFlagSet("import-midi", flag.ContinueOnError)
+	input := fs.String("i", "", "MIDI file exported with export-midi")
+	output := fs.String("o", "", "pattern JSON to write, defaults to the MIDI name with .json")
+	opts := addMIDIFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *input == "" {
+		return fmt.Errorf("no MIDI file given, use -i")
+	}
+	if *output == "" {
+		*output = strings.TrimSuffix(filepath.Base(*input), filepath.Ext(*input)) + ".json"
+	}
+
+	pattern, err := motors.ImportMIDIFile(*input, *opts)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(pattern, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*output, data, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d motors (%s) to %s\n", len(pattern.Patterns), pattern.Length(), *output)
+	return nil
+}
+
+func generateMotionCommand(args []string) error {
+	fs := flag.NewFlagSet("generate-motion", flag.ContinueOnError)
+	name := fs.String("generator", "travelingWave", "generator, one of "+strings.Join(motors.GeneratorNames(), ", "))
+	params := fs.String("params", "", "generator parameters, e.g. period=8000,amplitude=4")
+	output := fs.String("o", "", "pattern JSON to write, defaults to the generator name with .json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *output == "" {
+		*output = *name + ".json"
+	}
+
+	parsed, err := parseParams(*params)
+	if err != nil {
+		return err
+	}
+	pattern, err := motors.Generate(*name, parsed)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(pattern, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*output, data, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Generated %d motors (%s) to %s\n", len(pattern.Patterns), pattern.Length(), *output)
+	return nil
+}
+
+// patternCommand applies one of the pattern operations to pattern files,
+// e.g. "pattern stretch -factor 1.5 -o slow.json wave.json".
+func patternCommand(args []string) error {
+	ops := "concat, overlay, stretch, tempo, invert, reverse, rotate, mirror or crop"
+	if len(args) == 0 {
+		return fmt.Errorf("no operation given, use %s", ops)
+	}
+	op := args[0]
+
+	fs := flag.NewFlagSet("pattern "+op, flag.ContinueOnError)
+	output := fs.String("o", "", "pattern JSON to write, defaults to stdout")
+	factor := fs.Float64("factor", 1, "factor for stretch and tempo")
+	steps := fs.Int("steps", 1, "motors to rotate by")
+	motorCount := fs.Int("motors", 7, "motors on the ring for rotate and mirror")
+	from := fs.Duration("from", 0, "start of the crop")
+	to := fs.Duration("to", 0, "end of the crop, 0 for the end of the pattern")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	var inputs []*motors.Pattern
+	for _, path := range fs.Args() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var p motors.Pattern
+		if err := json.Unmarshal(data, &p); err != nil {
+			return fmt.Errorf("error parsing pattern %s: %w", path, err)
+		}
+		inputs = append(inputs, &p)
+	}
+	if len(inputs) == 0 {
+		return fmt.Errorf("no input patterns given")
+	}
+	if op != "concat" && op != "overlay" && len(inputs) > 1 {
+		return fmt.Errorf("%s takes a single pattern", op)
+	}
+
+	var result *motors.Pattern
+	var err error
+	switch op {
+	case "concat":
+		result = motors.Concat(inputs...)
+	case "overlay":
+		result = motors.Overlay(inputs...)
+	case "stretch":
+		result, err = motors.Stretch(inputs[0], *factor)
+	case "tempo":
+		result, err = motors.ScaleTempo(inputs[0], *factor)
+	case "invert":
+		result = motors.Invert(inputs[0])
+	case "reverse":
+		result = motors.Reverse(inputs[0])
+	case "rotate":
+		result, err = motors.Rotate(inputs[0], *steps, *motorCount)
+	case "mirror":
+		result, err = motors.Mirror(inputs[0], *motorCount)
+	case "crop":
+		result, err = motors.Crop(inputs[0], *from, *to)
+	default:
+		return fmt.Errorf("unknown operation %q, use %s", op, ops)
+	}
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(result, "", "    ")
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		fmt.Println(string(data))
+		return nil
+	}
+	if err := os.WriteFile(*output, data, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %d motors (%s) to %s\n", len(result.Patterns), result.Length(), *output)
+	return nil
+}
+
+// simulateCommand dry-runs a pattern against the kinematic model and
+// reports anything that breaks the limits.
+func simulateCommand(args []string) error {
+	fs := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	input := fs.String("i", "", "pattern JSON to simulate")
+	output := fs.String("o", "", "trace to write, .csv or .json")
+	preview := fs.String("preview", "", "animated GIF preview to write")
+	step := fs.Duration("step", 10*time.Millisecond, "simulation step")
+	strict := fs.Bool("strict", false, "fail when any limit is broken")
+	limits := motors.DefaultLimits
+	fs.Float64Var(&limits.MaxSpeed, "max-speed", limits.MaxSpeed, "speed limit in rad/s")
+	fs.Float64Var(&limits.MaxAcceleration, "max-accel", limits.MaxAcceleration, "acceleration limit in rad/s²")
+	fs.Float64Var(&limits.MaxAngle, "max-angle", limits.MaxAngle, "angle limit either way in rad, 0 for none")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *input == "" {
+		return fmt.Errorf("no pattern given, use -i")
+	}
+
+	data, err := os.ReadFile(*input)
+	if err != nil {
+		return err
+	}
+	var pattern motors.Pattern
+	if err := json.Unmarshal(data, &pattern); err != nil {
+		return fmt.Errorf("error parsing pattern: %w", err)
+	}
+	if err := pattern.Validate(); err != nil {
+		return err
+	}
+
+	sim, err := motors.Simulate(&pattern, limits, *step)
+	if err != nil {
+		return err
+	}
+
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if strings.ToLower(filepath.Ext(*output)) == ".csv" {
+			err = sim.WriteCSV(f)
+		} else {
+			enc := json.NewEncoder(f)
+			enc.SetIndent("", "    ")
+			err = enc.Encode(sim)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if *preview != "" {
+		f, err := os.Create(*preview)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := motors.ExportPreviewGIF(f, sim, motors.DefaultPreviewOptions); err != nil {
+			return err
+		}
+	}
+
+	for _, trace := range sim.Motors {
+		fmt.Printf("Motor %d: travel %.1f rad, furthest %.1f rad from the start\n", trace.MotorId, trace.Travel, trace.MaxAngle)
+	}
+	for _, v := range sim.Violations {
+		fmt.Printf("%8s motor %d %-8s %s\n", time.Duration(v.At)*time.Millisecond, v.MotorId, v.Kind, v.Message)
+	}
+	if len(sim.Violations) > 0 && *strict {
+		return fmt.Errorf("%d limit violations", len(sim.Violations))
+	}
+	fmt.Printf("Simulated %s with %d violations\n", pattern.Length(), len(sim.Violations))
+	return nil
+}
+
+// openBoard connects to one device, or to an emulated board standing in for
+// it, and makes sure its motor is initialized. The traffic is recorded if
+// given a recorder.
+func openBoard(device comms.DeviceInfo, emulate bool, recorder *recording.Recorder, connectionsMutex *sync.Mutex) ([]*comms.SerialConnection, error) {
+	if recorder != nil {
+		comms.OpenPort = recorder.Opener(comms.OpenPort)
+	}
+	var conn *comms.SerialConnection
+	if emulate {
+		conn = &comms.SerialConnection{Port: emulator.NewBoard(device.DeviceSerialNo), PortName: device.SerialPort}
+		if recorder != nil {
+			conn.Port = recorder.Wrap(device.SerialPort, conn.Port)
+		}
+	} else {
+		var err error
+		if conn, err = comms.OpenSerialPort(device.SerialPort, nil, connectionsMutex); err != nil {
+			return nil, err
+		}
+	}
+	conn.DeviceID = device.DeviceID
+	connections := []*comms.SerialConnection{conn}
+
+	// The reader keeps conn.Output, nothing draws the updates
+	updates := make(chan comms.ScreenUpdate, 100)
+	go func() {
+		for range updates {
+		}
+	}()
+	go comms.ReadSerialOutput(conn, updates, connections, connectionsMutex)
+
+	comms.WriteCommand("I", connectionsMutex, connections, 0)
+	for attempt := 0; attempt < 50; attempt++ {
+		connectionsMutex.Lock()
+		output := conn.Output
+		connectionsMutex.Unlock()
+		if strings.Contains(output, "K_RUNNING") || strings.Contains(output, "ALREADY_INITED") {
+			return connections, nil
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	conn.Port.Close()
+	return nil, fmt.Errorf("device %s did not initialize", device.DeviceID)
+}
+
+func tuneCommand(args []string) error {
+	fs := flag.NewFlagSet("tune", flag.ContinueOnError)
+	deviceID := fs.String("device", "0", "device to tune")
+	emulate := fs.Bool("emulate", false, "tune an emulated board instead of the device")
+	save := fs.Bool("save", false, "save the best gains to the device's profile")
+	output := fs.String("o", "", "tuning trials to write as JSON")
+	verbose := fs.Bool("v", false, "log serial commands")
+	opts := motors.DefaultTuneOptions
+	fs.Float64Var(&opts.From, "from", opts.From, "speed before the step in rad/s")
+	fs.Float64Var(&opts.To, "to", opts.To, "speed after the step in rad/s")
+	fs.DurationVar(&opts.Duration, "duration", opts.Duration, "how long to capture after each step")
+	fs.IntVar(&opts.Iterations, "iterations", opts.Iterations, "number of steps to try")
+	fs.Float64Var(&opts.MaxOvershoot, "max-overshoot", opts.MaxOvershoot, "overshoot allowed, as a fraction of the step")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	var err error
+	if devices, err = comms.LoadDevices(devicesPath); err != nil {
+		return err
+	}
+	device := comms.FindDevice(devices, *deviceID)
+	if device == nil {
+		return fmt.Errorf("unknown device: %s", *deviceID)
+	}
+
+	var connectionsMutex sync.Mutex
+	connections, err := openBoard(*device, *emulate, nil, &connectionsMutex)
+	if err != nil {
+		return err
+	}
+	defer connections[0].Port.Close()
+
+	fmt.Printf("Tuning device %s, steps from %v to %v rad/s\n", *deviceID, opts.From, opts.To)
+	result, err := motors.TuneVelocity(0, opts, &connectionsMutex, connections)
+	if result != nil {
+		for _, t := range result.Trials {
+			fmt.Printf("P %.3f I %.3f: rise %4d ms, overshoot %5.1f%%, settling %4d ms, error %.2f rad/s\n",
+				t.P, t.I, t.Metrics.RiseTime, 100*t.Metrics.Overshoot, t.Metrics.SettlingTime, t.Metrics.SteadyError)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Best: P %.3f I %.3f\n", result.Best.P, result.Best.I)
+
+	if *output != "" {
+		data, err := json.MarshalIndent(result, "", "    ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(*output, data, 0644); err != nil {
+			return err
+		}
+	}
+	if *save {
+		if err := saveDeviceParams(*deviceID, result.Gains()); err != nil {
+			return err
+		}
+		fmt.Printf("Saved to %s\n", devicesPath)
+	}
+	return nil
+}
+
+func telemetryCommand(args []string) error {
+	fs := flag.NewFlagSet("telemetry", flag.ContinueOnError)
+	deviceID := fs.String("device", "0", "device to monitor")
+	emulate := fs.Bool("emulate", false, "monitor an emulated board instead of the device")
+	duration := fs.Duration("duration", 3*time.Second, "how long to monitor")
+	speed := fs.Float64("speed", 0, "speed to run the motor at while monitoring, rad/s")
+	output := fs.String("o", "", "CSV to write, standard output if not given")
+	record := fs.String("record", "", "file to record the serial traffic to")
+	verbose := fs.Bool("v", false, "log serial commands")
+	opts := telemetry.DefaultOptions
+	fs.IntVar(&opts.Downsample, "downsample", opts.Downsample, "board loops per sample")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	var err error
+	if devices, err = comms.LoadDevices(devicesPath); err != nil {
+		return err
+	}
+	device := comms.FindDevice(devices, *deviceID)
+	if device == nil {
+		return fmt.Errorf("unknown device: %s", *deviceID)
+	}
+
+	var recorder *recording.Recorder
+	if *record != "" {
+		if recorder, err = recording.Create(*record); err != nil {
+			return err
+		}
+		defer recorder.Close()
+	}
+
+	var connectionsMutex sync.Mutex
+	connections, err := openBoard(*device, *emulate, recorder, &connectionsMutex)
+	if err != nil {
+		return err
+	}
+	defer connections[0].Port.Close()
+
+	start := time.Now()
+	monitor := telemetry.NewMonitor(opts)
+	monitor.Start(&connectionsMutex, connections)
+	if *speed != 0 {
+		motors.SendTarget(0, motors.Target{Mode: motors.ModeVelocity, Value: *speed}, &connectionsMutex, connections)
+	}
+	time.Sleep(*duration)
+	if *speed != 0 {
+		motors.SendTarget(0, motors.Stop(0), &connectionsMutex, connections)
+	}
+	monitor.Stop(&connectionsMutex, connections)
+
+	w := io.Writer(os.Stdout)
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	return telemetry.WriteCSV(w, monitor.Samples(*deviceID, start))
+}
+
+func replayCommand(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	input := fs.String("i", "", "recording to replay")
+	dump := fs.Bool("dump", false, "print the recorded traffic instead of replaying it")
+	port := fs.String("port", "", "port to replay, all of them if not given")
+	speed := fs.Float64("speed", 0, "replay speed, 1 for the recorded timing, 0 as fast as possible")
+	telemetryCSV := fs.String("telemetry", "", "CSV to write the telemetry parsed from the replay to")
+	vars := fs.Uint("vars", uint(telemetry.DefaultOptions.Variables), "monitored variables as a bitmask, angle is bit 0")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *input == "" {
+		return fmt.Errorf("no recording given, use -i")
+	}
+	rec, err := recording.Load(*input)
+	if err != nil {
+		return err
+	}
+	if *dump {
+		return rec.Dump(os.Stdout)
+	}
+	log.SetOutput(io.Discard)
+
+	ports := rec.Ports
+	if *port != "" {
+		ports = []string{*port}
+	}
+	var connectionsMutex sync.Mutex
+	var samplesMutex sync.Mutex
+	var samples []telemetry.Sample
+
+	// Each port plays back once. The reader comes back to reopen it when it
+	// reads the end, which is when everything it replayed has been handled.
+	open := rec.Opener(recording.ReplayOptions{Speed: *speed})
+	var openMutex sync.Mutex
+	opened := make(map[string]bool)
+	ended := make(map[string]chan struct{})
+	for _, name := range ports {
+		ended[name] = make(chan struct{})
+	}
+	comms.OpenPort = func(name string, mode *serial.Mode) (serial.Port, error) {
+		openMutex.Lock()
+		defer openMutex.Unlock()
+		if opened[name] {
+			if ch, ok := ended[name]; ok {
+				close(ch)
+				delete(ended, name)
+			}
+			return nil, fmt.Errorf("replay of %s ended", name)
+		}
+		opened[name] = true
+		return open(name, mode)
+	}
+
+	// What the TUI would have shown
+	updates := make(chan comms.ScreenUpdate, 100)
+	printed := make(chan struct{})
+	go func() {
+		defer close(printed)
+		for u := range updates {
+			for _, line := range strings.Split(strings.TrimRight(u.Output, "\n"), "\n") {
+				fmt.Printf("%s: %s\n", u.DeviceID, strings.TrimSpace(line))
+			}
+		}
+	}()
+
+	var waits []chan struct{}
+	for _, name := range ports {
+		p, err := comms.OpenPort(name, nil)
+		if err != nil {
+			return err
+		}
+		waits = append(waits, ended[name])
+		conn := &comms.SerialConnection{Port: p, DeviceID: name, PortName: name}
+		comms.Listen(conn, func(line string, at time.Time) {
+			if s, ok := telemetry.ParseLine(line, telemetry.Variables(*vars), at); ok {
+				s.DeviceID = name
+				samplesMutex.Lock()
+				samples = append(samples, s)
+				samplesMutex.Unlock()
+			}
+		})
+		go comms.ReadSerialOutput(conn, updates, []*comms.SerialConnection{conn}, &connectionsMutex)
+	}
+
+	for _, ch := range waits {
+		<-ch
+	}
+	// The readers only send again after reconnecting, which they can't
+	close(updates)
+	<-printed
+
+	if *telemetryCSV != "" {
+		f, err := os.Create(*telemetryCSV)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		samplesMutex.Lock()
+		defer samplesMutex.Unlock()
+		if err := telemetry.WriteCSV(f, samples); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Replayed %s of %d ports\n", rec.Length(), len(ports))
+	return nil
+}
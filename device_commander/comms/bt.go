@@ -2,8 +2,8 @@ package comms
 
 import (
 	"context"
+	"device_commander/logging"
 	"fmt"
-	"log"
 	"os"
 	"strconv"
 	"sync"
@@ -21,6 +21,8 @@ func SetScreenUpdateChan(ch chan ScreenUpdate) {
 	screenUpdateChan = ch
 }
 
+var btLogger = logging.Logger(logging.BT)
+
 var (
 	connectedDevices map[string]ble.Client
 	deviceMutex      sync.Mutex
@@ -38,16 +40,16 @@ func handleDisconnect(c ble.Client) {
 	for addr, client := range connectedDevices {
 		if client == c {
 			delete(connectedDevices, addr)
-			log.Printf("bt: Device %s disconnected", addr)
+			btLogger.Info("Device disconnected", "addr", addr)
 
 			// Attempt to reconnect
 			go func(address string) {
 				for {
-					log.Printf("bt: Attempting to reconnect to %s", address)
+					btLogger.Info("Reconnecting", "addr", address)
 					ctx := ble.WithSigHandler(context.WithTimeout(context.Background(), 30*time.Second))
 					c, err := ble.Connect(ctx, filter(address))
 					if err != nil {
-						log.Printf("bt: Failed to reconnect to %s: %v", address, err)
+						btLogger.Warn("Failed to reconnect", "addr", address, "err", err)
 						time.Sleep(5 * time.Second)
 						continue
 					}
@@ -56,7 +58,7 @@ func handleDisconnect(c ble.Client) {
 					connectedDevices[address] = c
 					deviceMutex.Unlock()
 
-					log.Printf("bt: Successfully reconnected to %s", address)
+					btLogger.Info("Reconnected", "addr", address)
 					return
 				}
 			}(addr)
@@ -73,31 +75,14 @@ func filter(addr string) ble.AdvFilter {
 }
 
 func RunBluetooth() {
-	// Open bt-debug.log file for logging in the current directory
-	debugFile, err := os.OpenFile("bt-debug.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
-	if err != nil {
-		log.Fatalf("bt: Can't open bt-debug.log file: %s", err)
-	}
-	defer debugFile.Close()
-
-	// Create a new logger for this package
-	btLogger := log.New(debugFile, "bt: ", log.LstdFlags)
-
-	// Set the output of the default logger to the debug file
-	log.SetOutput(debugFile)
-
-	// Use the package-specific logger
-	log.SetOutput(btLogger.Writer())
-
 	d, err := dev.NewDevice("Hexagon")
 	if err != nil {
-		log.Fatalf("bt: Can't create device : %s", err)
+		btLogger.Error("Can't create device", "err", err)
+		os.Exit(1)
 	}
 	ble.SetDefaultDevice(d)
 
-	// Log the device MAC address
-	log.Printf("bt: Device Info uuid: %s", ble.DeviceInfoUUID.String())
-	log.Printf("bt: Device Name uuid: %s", ble.DeviceNameUUID.String())
+	btLogger.Info("Device created", "info_uuid", ble.DeviceInfoUUID.String(), "name_uuid", ble.DeviceNameUUID.String())
 
 	// Define a characteristic for receiving data
 	rxChar := ble.NewCharacteristic(ble.MustParse("19B10001-E8F2-537E-4F6C-D104768A1214"))
@@ -105,9 +90,7 @@ func RunBluetooth() {
 		ble.WriteHandlerFunc(func(req ble.Request, rsp ble.ResponseWriter) {
 			data := req.Data()
 			if len(data) > 0 {
-				log.Printf("bt: Received raw data: %v", data)
-				log.Printf("bt: Received string data: %s", string(data))
-				log.Printf("bt: Received data: %s", string(data))
+				btLogger.Debug("Received data", "raw", data, "data", string(data))
 
 				// Send the received string data to the main screen drawing
 				if screenUpdateChan != nil {
@@ -117,10 +100,10 @@ func RunBluetooth() {
 						Output:   string(data),
 					}:
 					default:
-						log.Println("bt: Failed to send update, channel full")
+						btLogger.Warn("Failed to send update, channel full")
 					}
 				} else {
-					log.Println("bt: screenUpdateChan is nil")
+					btLogger.Warn("screenUpdateChan is nil")
 				}
 			}
 		}),
@@ -132,7 +115,8 @@ func RunBluetooth() {
 
 	// Add the service to the device
 	if err := ble.AddService(svc); err != nil {
-		log.Fatalf("bt: Can't add service: %s", err)
+		btLogger.Error("Can't add service", "err", err)
+		os.Exit(1)
 	}
 
 	// Start advertising
@@ -148,26 +132,26 @@ func RunBluetooth() {
 		addr := fmt.Sprintf("%02X:%02X:%02X:%02X:%02X:%02X",
 			evt.PeerAddress()[5], evt.PeerAddress()[4], evt.PeerAddress()[3],
 			evt.PeerAddress()[2], evt.PeerAddress()[1], evt.PeerAddress()[0])
-		log.Printf("bt: OptConnectHandler called for address %s", addr)
+		btLogger.Debug("OptConnectHandler called", "addr", addr)
 
 		ctx := context.Background()
 		c, err := ble.Connect(ctx, filter(addr))
 		if err != nil {
-			log.Printf("bt: Failed to connect to %s: %v", addr, err)
+			btLogger.Warn("Failed to connect", "addr", addr, "err", err)
 			return
 		}
 
-		log.Printf("bt: Successfully connected to %s", addr)
+		btLogger.Info("Connected", "addr", addr)
 
 		deviceMutex.Lock()
 		connectedDevices[addr] = c
 		deviceMutex.Unlock()
 
-		log.Printf("bt: Added %s to connectedDevices", addr)
+		btLogger.Debug("Added to connectedDevices", "addr", addr)
 
 		go func() {
 			<-c.Disconnected()
-			log.Printf("bt: Disconnection detected for %s", addr)
+			btLogger.Info("Disconnection detected", "addr", addr)
 			handleDisconnect(c)
 		}()
 
@@ -177,20 +161,20 @@ func RunBluetooth() {
 				DeviceID: "BT",
 				Output:   "Connected to " + addr,
 			}:
-				log.Printf("bt: Sent connection update for %s", addr)
+				btLogger.Debug("Sent connection update", "addr", addr)
 			default:
-				log.Printf("bt: Failed to send connection update for %s, channel full", addr)
+				btLogger.Warn("Failed to send connection update, channel full", "addr", addr)
 			}
 		} else {
-			log.Printf("bt: screenUpdateChan is nil for ble connection to %s", addr)
+			btLogger.Warn("screenUpdateChan is nil for ble connection", "addr", addr)
 		}
 
-		log.Printf("bt: OptConnectHandler completed for %s", addr)
+		btLogger.Debug("OptConnectHandler completed", "addr", addr)
 	})
 
 	// Handle disconnections
 	ble.OptDisconnectHandler(func(evt evt.DisconnectionComplete) {
-		log.Printf("bt: Disconnected from %s", strconv.Itoa(int(evt.ConnectionHandle())))
+		btLogger.Info("Disconnected", "handle", evt.ConnectionHandle())
 		if screenUpdateChan != nil {
 			select {
 			case screenUpdateChan <- ScreenUpdate{
@@ -198,10 +182,10 @@ func RunBluetooth() {
 				Output:   "Disconnected from " + strconv.Itoa(int(evt.ConnectionHandle())),
 			}:
 			default:
-				log.Println("bt: Failed to send disconnection update, channel full")
+				btLogger.Warn("Failed to send disconnection update, channel full")
 			}
 		} else {
-			log.Println("bt: screenUpdateChan is nil for ble disconnection")
+			btLogger.Warn("screenUpdateChan is nil for ble disconnection")
 		}
 		// Start advertising again after disconnection
 		go advertise()
@@ -215,10 +199,11 @@ func chkErr(err error) {
 	switch errors.Cause(err) {
 	case nil:
 	case context.DeadlineExceeded:
-		log.Printf("bt: timeout deadline exceeded\n")
+		btLogger.Warn("Timeout deadline exceeded")
 	case context.Canceled:
-		log.Printf("bt:canceled\n")
+		btLogger.Warn("Canceled")
 	default:
-		log.Fatal(err)
+		btLogger.Error("Can't advertise", "err", err)
+		os.Exit(1)
 	}
 }
@@ -2,7 +2,6 @@ package comms
 
 import (
 	"io"
-	"log"
 	"sync"
 	"time"
 )
@@ -11,7 +10,7 @@ func SendCommandToAll(command string, connectionsMutex *sync.Mutex, connections
 	connectionsMutex.Lock()
 	defer connectionsMutex.Unlock()
 
-	log.Printf("Sending command to all devices: %s", command)
+	logger.Info("Sending command to all devices", "command", command)
 	for _, conn := range connections {
 		sendWithRetry(conn, command)
 	}
@@ -22,13 +21,26 @@ func sendWithRetry(conn *SerialConnection, command string) {
 	for i := 0; i < maxRetries; i++ {
 		_, err := conn.Port.Write([]byte(command + "\n"))
 		if err == nil {
-			log.Printf("Command sent successfully to %s", conn.DeviceID)
+			commandsSent.Inc(conn.DeviceID)
+			logger.Debug("Command sent", "device", conn.DeviceID)
 			return
 		}
-		log.Printf("Error sending command to %s (attempt %d): %v", conn.DeviceID, i+1, err)
+		logger.Warn("Error sending command", "device", conn.DeviceID, "attempt", i+1, "err", err)
 		time.Sleep(time.Millisecond * 100)
 	}
-	log.Printf("Failed to send command to %s after %d attempts", conn.DeviceID, maxRetries)
+	commandsFailed.Inc(conn.DeviceID)
+	logger.Error("Failed to send command", "device", conn.DeviceID, "command", command, "attempts", maxRetries)
+}
+
+// WriteCommand sends a command without reading the reply, which leaves
+// everything the board sends to the reader goroutine and its listeners.
+func WriteCommand(command string, connectionsMutex *sync.Mutex, connections []*SerialConnection, currentPortIndex int) {
+	connectionsMutex.Lock()
+	conn := connections[currentPortIndex]
+	connectionsMutex.Unlock()
+
+	logger.Info("Sending command", "device", conn.DeviceID, "command", command)
+	sendWithRetry(conn, command)
 }
 
 func SendCommand(command string, connectionsMutex *sync.Mutex, connections []*SerialConnection, currentPortIndex int) {
@@ -36,7 +48,7 @@ func SendCommand(command string, connectionsMutex *sync.Mutex, connections []*Se
 	conn := connections[currentPortIndex]
 	connectionsMutex.Unlock()
 
-	log.Printf("Sending command to %s: %s", conn.DeviceID, command)
+	logger.Info("Sending command", "device", conn.DeviceID, "command", command)
 
 	// Clear existing output
 	connectionsMutex.Lock()
@@ -65,15 +77,15 @@ func SendCommand(command string, connectionsMutex *sync.Mutex, connections []*Se
 
 	select {
 	case response := <-responseChan:
-		log.Printf("Immediate response from %s: %s", conn.DeviceID, response)
+		logger.Debug("Immediate response", "device", conn.DeviceID, "response", response)
 		connectionsMutex.Lock()
 		conn.Output += response
 		connectionsMutex.Unlock()
 	case err := <-errorChan:
 		if err != io.EOF {
-			log.Printf("Error reading response from %s: %v", conn.DeviceID, err)
+			logger.Warn("Error reading response", "device", conn.DeviceID, "err", err)
 		}
 	case <-time.After(200 * time.Millisecond):
-		log.Printf("Timeout waiting for immediate response from %s", conn.DeviceID)
+		logger.Debug("No immediate response", "device", conn.DeviceID)
 	}
 }
@@ -0,0 +1,62 @@
+package comms
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// DefaultDevices are the seven boards of the lamp, used when there is no
+// devices file yet.
+var DefaultDevices = []DeviceInfo{
+	{DeviceSerialNo: "0671FF383159503043112607", DeviceID: "0", SerialPort: "/dev/ttyACM0"},
+	{DeviceSerialNo: "066DFF515049657187212124", DeviceID: "1", SerialPort: "/dev/ttyACM1"},
+	{DeviceSerialNo: "066CFF383159503043112637", DeviceID: "2", SerialPort: "/dev/ttyACM2"},
+	{DeviceSerialNo: "066BFF515049657187203314", DeviceID: "3", SerialPort: "/dev/ttyACM3"},
+	{DeviceSerialNo: "066FFF383159503043114308", DeviceID: "4", SerialPort: "/dev/ttyACM4"},
+	{DeviceSerialNo: "066EFF383159503043112729", DeviceID: "5", SerialPort: "/dev/ttyACM5"},
+	{DeviceSerialNo: "066CFF383159503043112926", DeviceID: "6", SerialPort: "/dev/ttyACM6"},
+}
+
+// LoadDevices reads the device list from a JSON file, falling back to
+// DefaultDevices if it doesn't exist.
+func LoadDevices(path string) ([]DeviceInfo, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		devices := make([]DeviceInfo, len(DefaultDevices))
+		copy(devices, DefaultDevices)
+		return devices, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading devices: %w", err)
+	}
+
+	var devices []DeviceInfo
+	if err := json.Unmarshal(data, &devices); err != nil {
+		return nil, fmt.Errorf("error parsing devices: %w", err)
+	}
+	return devices, nil
+}
+
+// SaveDevices writes the device list, e.g. after calibrating zero offsets.
+func SaveDevices(path string, devices []DeviceInfo) error {
+	data, err := json.MarshalIndent(devices, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing devices: %w", err)
+	}
+	return nil
+}
+
+// FindDevice returns the device with the given ID.
+func FindDevice(devices []DeviceInfo, deviceID string) *DeviceInfo {
+	for i := range devices {
+		if devices[i].DeviceID == deviceID {
+			return &devices[i]
+		}
+	}
+	return nil
+}
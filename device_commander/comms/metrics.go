@@ -0,0 +1,22 @@
+package comms
+
+import "device_commander/metrics"
+
+var (
+	deviceConnected = metrics.NewGauge("device_commander_device_connected",
+		"Whether the device's serial link is up, 1 or 0.", "device")
+	deviceReconnects = metrics.NewCounter("device_commander_device_reconnects_total",
+		"Attempts to reopen a device's serial link, by result.", "device", "result")
+	handshakeSeconds = metrics.NewHistogram("device_commander_handshake_seconds",
+		"Time from sending a handshake to the device's reply.", metrics.LatencyBuckets, "device")
+	commandsSent = metrics.NewCounter("device_commander_commands_sent_total",
+		"Commands written to a device.", "device")
+	commandsFailed = metrics.NewCounter("device_commander_commands_failed_total",
+		"Commands that couldn't be written to a device after every retry.", "device")
+	bleConnections = metrics.NewGaugeFunc("device_commander_ble_connections",
+		"Bluetooth clients connected.", func(emit func(float64, ...string)) {
+			deviceMutex.Lock()
+			defer deviceMutex.Unlock()
+			emit(float64(len(connectedDevices)))
+		})
+)
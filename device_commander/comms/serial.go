@@ -2,11 +2,11 @@ package comms
 
 import (
 	"bufio"
+	"device_commander/logging"
 	"fmt"
 	"io"
-	"log"
 	"math/rand"
-	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -14,18 +14,11 @@ import (
 	"go.bug.st/serial"
 )
 
-var debugSerial bool
+var logger = logging.Logger(logging.Comms)
 
-func init() {
-	debugSerial = os.Getenv("DEBUG_SERIAL") != ""
-	fmt.Printf("DEBUG_SERIAL=%v\n", debugSerial)
-}
-
-func debugLog(format string, v ...interface{}) {
-	if debugSerial {
-		log.Printf(format, v...)
-	}
-}
+// OpenPort opens a serial port by name. It can be replaced to record the
+// traffic or to stand in for the boards.
+var OpenPort = serial.Open
 
 type ScreenUpdate struct {
 	DeviceID string
@@ -37,9 +30,11 @@ const (
 )
 
 type DeviceInfo struct {
-	DeviceSerialNo string `json:"device_serial_no"`
-	DeviceID       string `json:"device_id"`
-	SerialPort     string `json:"serial_port"`
+	DeviceSerialNo string             `json:"device_serial_no"`
+	DeviceID       string             `json:"device_id"`
+	SerialPort     string             `json:"serial_port"`
+	ZeroOffset     *float64           `json:"zero_offset,omitempty"` // Sensor angle of the neutral pose, rad; unset until calibrated
+	Params         map[string]float64 `json:"params,omitempty"`      // Tuned motor parameters, applied whenever the board starts
 }
 
 type SerialConnection struct {
@@ -61,13 +56,13 @@ func OpenSerialPort(port string, connections []*SerialConnection, connectionsMut
 	}
 
 	for retries := 0; retries < 5; retries++ {
-		debugLog("Attempting to open port %s (attempt %d)", port, retries+1)
-		conn, err = serial.Open(port, mode)
+		logger.Debug("Opening port", "port", port, "attempt", retries+1)
+		conn, err = OpenPort(port, mode)
 		if err == nil {
 			time.Sleep(time.Millisecond * 100)
 			break
 		}
-		debugLog("Failed to open port %s: %v. Retrying...", port, err)
+		logger.Warn("Failed to open port, retrying", "port", port, "err", err)
 		time.Sleep(time.Duration(500+rand.Intn(1000)) * time.Millisecond)
 	}
 	if err != nil {
@@ -94,25 +89,27 @@ func OpenSerialPort(port string, connections []*SerialConnection, connectionsMut
 	// Start periodic handshake goroutine
 	// go PeriodicHandshake(serialConn, connections, connectionsMutex)
 
-	debugLog("Successfully opened and initialized port %s", port)
+	logger.Info("Opened port", "port", port)
 	return serialConn, nil
 }
 
 func PerformHandshake(conn *SerialConnection) error {
 	for retries := 0; retries < 5; retries++ {
-		debugLog("Sending handshake to %s (attempt %d)", conn.PortName, retries+1)
+		logger.Debug("Sending handshake", "port", conn.PortName, "attempt", retries+1)
+		sent := time.Now()
 		_, err := conn.Port.Write([]byte("H\n"))
 		if err != nil {
-			debugLog("Failed to send handshake to %s: %v", conn.PortName, err)
+			logger.Warn("Failed to send handshake", "port", conn.PortName, "err", err)
 			continue
 		}
 
 		response, err := waitForAnyResponse(conn, []string{"K", "HB"}, 10*time.Second)
 		if err == nil {
-			debugLog("Received valid handshake response %s from device on port %s", response, conn.PortName)
+			handshakeSeconds.ObserveDuration(time.Since(sent), conn.DeviceID)
+			logger.Debug("Received handshake response", "port", conn.PortName, "response", response)
 			return nil
 		}
-		debugLog("Handshake attempt %d failed for %s: %v", retries+1, conn.PortName, err)
+		logger.Warn("Handshake attempt failed", "port", conn.PortName, "attempt", retries+1, "err", err)
 		time.Sleep(time.Duration(500+rand.Intn(1000)) * time.Millisecond)
 	}
 	return fmt.Errorf("handshake failed after 5 attempts for %s", conn.PortName)
@@ -126,9 +123,9 @@ func PeriodicHandshake(conn *SerialConnection, connections []*SerialConnection,
 	for {
 		<-ticker.C
 		if err := PerformHandshake(conn); err != nil {
-			debugLog("Handshake failed for device %s: %v", conn.DeviceID, err)
-			go AttemptReconnection(conn, connections, connectionsMutex)
-			return // Exit this goroutine, as reconnection will start a new one if successful
+			logger.Error("Handshake failed", "device", conn.DeviceID, "err", err)
+			// The reader sees the port close and reconnects
+			conn.Port.Close()
 		}
 	}
 }
@@ -136,7 +133,7 @@ func PeriodicHandshake(conn *SerialConnection, connections []*SerialConnection,
 func waitForAnyResponse(conn *SerialConnection, expectedResponses []string, timeout time.Duration) (string, error) {
 	startTime := time.Now()
 	buffer := make([]byte, 128)
-	debugLog("Starting to wait for response from %s (expecting: %v)", conn.PortName, expectedResponses)
+	logger.Debug("Waiting for response", "port", conn.PortName, "expecting", expectedResponses)
 
 	// Set read timeout for this operation
 	conn.Port.SetReadTimeout(timeout)
@@ -145,16 +142,13 @@ func waitForAnyResponse(conn *SerialConnection, expectedResponses []string, time
 		n, err := conn.Port.Read(buffer)
 		if err != nil {
 			if err != io.EOF {
-				debugLog("Error reading from %s: %v", conn.PortName, err)
+				logger.Warn("Error reading", "port", conn.PortName, "err", err)
 			}
 			return "", err
 		}
 		if n > 0 {
 			// Add hex dump of received data
-			debugLog("Raw data from %s: [%X] as string: [%s]",
-				conn.PortName,
-				buffer[:n],
-				string(buffer[:n]))
+			logger.Debug("Raw data", "port", conn.PortName, "hex", fmt.Sprintf("%X", buffer[:n]), "data", string(buffer[:n]))
 
 			receivedData := string(buffer[:n])
 			conn.Output += receivedData
@@ -169,23 +163,102 @@ func waitForAnyResponse(conn *SerialConnection, expectedResponses []string, time
 	return "", fmt.Errorf("timeout waiting for response from %s. Received data: %s", conn.PortName, conn.Output)
 }
 
+// lineListeners get every complete line read from a connection, see Listen.
+var (
+	listenersMutex sync.Mutex
+	lineListeners  = make(map[*SerialConnection]map[int]func(string, time.Time))
+	nextListener   int
+)
+
+// Listen calls f with every line read from conn and the time it arrived,
+// until the returned function is called. f runs on the reader goroutine and
+// must not block.
+func Listen(conn *SerialConnection, f func(line string, at time.Time)) (stop func()) {
+	listenersMutex.Lock()
+	defer listenersMutex.Unlock()
+	if lineListeners[conn] == nil {
+		lineListeners[conn] = make(map[int]func(string, time.Time))
+	}
+	id := nextListener
+	nextListener++
+	lineListeners[conn][id] = f
+
+	return func() {
+		listenersMutex.Lock()
+		defer listenersMutex.Unlock()
+		delete(lineListeners[conn], id)
+	}
+}
+
+func notifyLines(conn *SerialConnection, lines []string, at time.Time) {
+	listenersMutex.Lock()
+	defer listenersMutex.Unlock()
+	for _, f := range lineListeners[conn] {
+		for _, line := range lines {
+			f(strings.TrimRight(line, "\r"), at)
+		}
+	}
+}
+
+// ParseMonitorLine reads a line of tab separated monitored variables. Lines
+// of a single variable can't be told from replies and aren't taken.
+func ParseMonitorLine(line string) ([]float64, bool) {
+	fields := strings.Split(strings.TrimSpace(line), "\t")
+	if len(fields) < 2 {
+		return nil, false
+	}
+	values := make([]float64, len(fields))
+	for i, f := range fields {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return nil, false
+		}
+		values[i] = v
+	}
+	return values, true
+}
+
+// Reconnected is the update ReadSerialOutput sends when it has reopened a
+// device's link. The board may have reset and forgotten its settings.
+const Reconnected = "RECONNECTED"
+
+// ReadSerialOutput reads a device's output, sending it as updates and to
+// the listeners. When reading fails it reconnects, sends Reconnected and
+// carries on reading. It never returns.
 func ReadSerialOutput(conn *SerialConnection, deviceUpdateChan chan<- ScreenUpdate, connections []*SerialConnection, connectionsMutex *sync.Mutex) {
+	for {
+		readOutput(conn, deviceUpdateChan, connectionsMutex)
+		AttemptReconnection(conn, connections, connectionsMutex)
+		deviceUpdateChan <- ScreenUpdate{DeviceID: conn.DeviceID, Output: Reconnected + "\n"}
+	}
+}
+
+// readOutput reads from the connection's port until reading fails.
+func readOutput(conn *SerialConnection, deviceUpdateChan chan<- ScreenUpdate, connectionsMutex *sync.Mutex) {
+	connectionsMutex.Lock()
 	reader := bufio.NewReader(conn.Port)
+	connectionsMutex.Unlock()
 	buffer := make([]byte, 1024)
+	partial := "" // Reads don't end on line breaks
+	deviceConnected.Set(1, conn.DeviceID)
 
 	for {
 		n, err := reader.Read(buffer)
 		if err != nil {
 			if err != io.EOF {
-				debugLog("Error reading from %s: %v", conn.DeviceID, err)
+				logger.Warn("Error reading", "device", conn.DeviceID, "err", err)
 			}
-			go AttemptReconnection(conn, connections, connectionsMutex)
+			deviceConnected.Set(0, conn.DeviceID)
 			return
 		}
 
 		if n > 0 {
 			data := string(buffer[:n])
-			debugLog("Received from device %s: %s", conn.DeviceID, data)
+			logger.Debug("Received", "device", conn.DeviceID, "data", data)
+
+			complete := strings.Split(partial+data, "\n")
+			partial = complete[len(complete)-1]
+			notifyLines(conn, complete[:len(complete)-1], time.Now())
 
 			lines := strings.Split(data, "\n")
 			var filteredLines []string
@@ -195,6 +268,8 @@ func ReadSerialOutput(conn *SerialConnection, deviceUpdateChan chan<- ScreenUpda
 					deviceUpdateChan <- ScreenUpdate{DeviceID: conn.DeviceID, Output: "ACK\n"}
 				} else if trimmedLine == "HB" {
 					deviceUpdateChan <- ScreenUpdate{DeviceID: conn.DeviceID, Output: "HB\n"}
+				} else if _, ok := ParseMonitorLine(trimmedLine); ok {
+					// Telemetry goes to the listeners only
 				} else if trimmedLine != "" {
 					filteredLines = append(filteredLines, line)
 				}
@@ -217,25 +292,25 @@ func AttemptReconnection(conn *SerialConnection, connections []*SerialConnection
 	if conn.Port != nil {
 		conn.Port.Close()
 	}
+	deviceConnected.Set(0, conn.DeviceID)
 
 	for {
-		debugLog("Attempting to reconnect to %s", conn.PortName)
+		logger.Info("Reconnecting", "port", conn.PortName)
 		newConn, err := OpenSerialPort(conn.PortName, connections, connectionsMutex)
 		if err == nil {
-			newConn.DeviceID = conn.DeviceID
+			// The connection keeps its identity, so the connections list
+			// and listeners registered with Listen still find it
 			connectionsMutex.Lock()
-			for i, c := range connections {
-				if c.DeviceID == conn.DeviceID {
-					connections[i] = newConn
-					break
-				}
-			}
+			conn.Port = newConn.Port
+			conn.Output = ""
 			connectionsMutex.Unlock()
-			debugLog("Successfully reconnected to %s", conn.PortName)
-			*conn = *newConn // Update the original connection with the new one
+			deviceReconnects.Inc(conn.DeviceID, "ok")
+			deviceConnected.Set(1, conn.DeviceID)
+			logger.Info("Reconnected", "port", conn.PortName)
 			return
 		}
-		debugLog("Failed to reconnect to %s: %v", conn.PortName, err)
+		deviceReconnects.Inc(conn.DeviceID, "failed")
+		logger.Warn("Failed to reconnect", "port", conn.PortName, "err", err)
 		time.Sleep(time.Second * 30)
 	}
 }
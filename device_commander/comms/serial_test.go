@@ -0,0 +1,70 @@
+package comms
+
+import (
+	"device_commander/emulator"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// waitFor waits for an update with the given output.
+func waitFor(t *testing.T, updates <-chan ScreenUpdate, output string) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case u := <-updates:
+			if strings.Contains(u.Output, output) {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("no %s update", output)
+		}
+	}
+}
+
+func TestReadSerialOutputReconnects(t *testing.T) {
+	open := OpenPort
+	t.Cleanup(func() { OpenPort = open })
+	OpenPort = func(string, *serial.Mode) (serial.Port, error) {
+		return emulator.NewBoard("SECOND"), nil
+	}
+
+	var connectionsMutex sync.Mutex
+	conn := &SerialConnection{Port: emulator.NewBoard("FIRST"), DeviceID: "0", PortName: "emulated"}
+	connections := []*SerialConnection{conn}
+
+	var linesMutex sync.Mutex
+	var lines []string
+	stop := Listen(conn, func(line string, at time.Time) {
+		linesMutex.Lock()
+		lines = append(lines, line)
+		linesMutex.Unlock()
+	})
+	defer stop()
+
+	updates := make(chan ScreenUpdate, 100)
+	go ReadSerialOutput(conn, updates, connections, &connectionsMutex)
+	WriteCommand("S", &connectionsMutex, connections, 0)
+	waitFor(t, updates, "FIRST")
+
+	connectionsMutex.Lock()
+	conn.Port.Close()
+	connectionsMutex.Unlock()
+	waitFor(t, updates, Reconnected)
+	if connections[0] != conn {
+		t.Error("the connection was replaced on reconnecting")
+	}
+
+	// The reader carries on with the new port, listeners included
+	WriteCommand("S", &connectionsMutex, connections, 0)
+	waitFor(t, updates, "SECOND")
+	linesMutex.Lock()
+	defer linesMutex.Unlock()
+	if !strings.Contains(strings.Join(lines, "\n"), "SECOND") {
+		t.Errorf("listener got %q, not the new board's reply", lines)
+	}
+}
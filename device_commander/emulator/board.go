@@ -0,0 +1,406 @@
+// Package emulator is a virtual lamp board: a serial port that answers like
+// the simplefoc_tuning firmware and drives a simulated motor, so tools can
+// be tried without hardware.
+package emulator
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// Monitored variables, in the order SimpleFOC prints them
+const (
+	monitorTarget = 1 << (6 - iota)
+	monitorVoltageQ
+	monitorVoltageD
+	monitorCurrentQ
+	monitorCurrentD
+	monitorVelocity
+	monitorAngle
+)
+
+const (
+	loopPeriod = time.Millisecond // Simulated FOC loop
+	tickPeriod = 10 * time.Millisecond
+)
+
+var errClosed = errors.New("emulated port closed")
+
+// Board is a virtual board behind a serial.Port.
+type Board struct {
+	mu          sync.Mutex
+	serialNo    string
+	in          []byte // Received, up to the next newline
+	out         bytes.Buffer
+	notify      chan struct{}
+	readTimeout time.Duration
+	closed      bool
+	done        chan struct{}
+
+	running bool // Initialized with I, motor commands only work after
+	motor   motor
+	rand    *rand.Rand
+
+	monitorVars       int
+	monitorDownsample int
+	loops             int
+}
+
+// NewBoard starts a board with the firmware's defaults. It runs until the
+// port is closed.
+func NewBoard(serialNo string) *Board {
+	b := &Board{
+		serialNo:    serialNo,
+		notify:      make(chan struct{}, 1),
+		readTimeout: serial.NoTimeout,
+		done:        make(chan struct{}),
+		rand:        rand.New(rand.NewSource(int64(len(serialNo)))),
+	}
+	b.reset()
+	b.println("K_SETUP")
+	go b.run()
+	return b
+}
+
+// reset puts the board in the state it powers up in.
+func (b *Board) reset() {
+	b.running = false
+	b.motor = newMotor()
+	b.monitorVars = 0
+	b.monitorDownsample = 10
+	b.loops = 0
+}
+
+func (b *Board) run() {
+	ticker := time.NewTicker(tickPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+		}
+
+		b.mu.Lock()
+		for i := 0; i < int(tickPeriod/loopPeriod); i++ {
+			if !b.running {
+				break
+			}
+			b.motor.step(loopPeriod.Seconds(), b.rand)
+			b.loops++
+			if b.monitorVars != 0 && b.loops%b.monitorDownsample == 0 {
+				b.printMonitor()
+			}
+		}
+		b.mu.Unlock()
+	}
+}
+
+// println queues a line for the host, b.mu must be held or the board not
+// yet running.
+func (b *Board) println(line string) {
+	b.out.WriteString(line + "\r\n")
+	select {
+	case b.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (b *Board) printMonitor() {
+	m := &b.motor
+	values := []struct {
+		bit   int
+		value float64
+	}{
+		{monitorTarget, m.target},
+		{monitorVoltageQ, m.voltageQ},
+		{monitorVoltageD, 0},
+		{monitorCurrentQ, m.voltageQ / phaseResistance},
+		{monitorCurrentD, 0},
+		{monitorVelocity, m.velocity},
+		{monitorAngle, m.shaftAngle()},
+	}
+	var fields []string
+	for _, v := range values {
+		if b.monitorVars&v.bit != 0 {
+			fields = append(fields, strconv.FormatFloat(v.value, 'f', 4, 64))
+		}
+	}
+	b.println(strings.Join(fields, "\t"))
+}
+
+// command runs one line sent by the host.
+func (b *Board) command(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	switch line[0] {
+	case 'H':
+		b.println("K")
+	case 'S':
+		b.println("SERIAL_NO:")
+		b.println(b.serialNo)
+	case 'R':
+		b.println("K_RESET")
+		b.reset()
+		b.println("K_SETUP")
+	case 'I':
+		if b.running {
+			b.println("ALREADY_INITED")
+			return
+		}
+		b.println("K_INIT")
+		b.println("M_READY")
+		b.println("SERIAL_NO:")
+		b.println(b.serialNo)
+		b.println("K_RUNNING")
+		b.running = true
+	case 'M':
+		// Registered when the motor is initialized
+		if !b.running {
+			return
+		}
+		b.println(b.motorCommand(line[1:]))
+		b.println("K_MOT: " + line)
+	}
+}
+
+// motorCommand answers the SimpleFOC commander's motor commands the way it
+// does in verbose mode.
+func (b *Board) motorCommand(cmd string) string {
+	m := &b.motor
+	if cmd == "" {
+		return fmt.Sprintf("Target: %.3f", m.target)
+	}
+
+	// A bare number sets the target
+	if v, err := strconv.ParseFloat(cmd, 64); err == nil {
+		m.target = v
+		return fmt.Sprintf("Target: %.3f", m.target)
+	}
+
+	value, set := parseValue(cmd[1:])
+	switch cmd[0] {
+	case 'C':
+		if set {
+			m.angleMode = value == 2
+		}
+		if m.angleMode {
+			return "Control: angle"
+		}
+		return "Control: vel"
+	case 'V':
+		return pidCommand("PID vel", &m.velocityPID, &m.velocityTf, cmd[1:])
+	case 'A':
+		return pidCommand("PID angle", &m.anglePID, &m.angleTf, cmd[1:])
+	case 'Q':
+		return pidCommand("PID curq", &m.currentQPID, &m.currentQTf, cmd[1:])
+	case 'L':
+		if len(cmd) < 2 {
+			break
+		}
+		value, set := parseValue(cmd[2:])
+		switch cmd[1] {
+		case 'U':
+			if set {
+				m.voltageLimit = value
+			}
+			return fmt.Sprintf("Limits| volt: %.3f", m.voltageLimit)
+		case 'V':
+			if set {
+				m.velocityLimit = value
+				m.anglePID.limit = value
+			}
+			return fmt.Sprintf("Limits| vel: %.3f", m.velocityLimit)
+		case 'C':
+			if set {
+				m.currentLimit = value
+			}
+			return fmt.Sprintf("Limits| curr: %.3f", m.currentLimit)
+		}
+	case 'S':
+		if len(cmd) < 2 || cmd[1] != 'M' {
+			break
+		}
+		if value, set := parseValue(cmd[2:]); set {
+			m.sensorOffset = value
+		}
+		return fmt.Sprintf("Sensor| offset: %.3f", m.sensorOffset)
+	case 'M':
+		return b.monitorCommand(cmd[1:])
+	}
+	return "err"
+}
+
+func (b *Board) monitorCommand(cmd string) string {
+	if cmd == "" {
+		return "err"
+	}
+	m := &b.motor
+	value, set := parseValue(cmd[1:])
+	switch cmd[0] {
+	case 'G':
+		names := []string{"target", "Vq", "Vd", "Cq", "Cd", "vel", "angle"}
+		values := []float64{m.target, m.voltageQ, 0, m.voltageQ / phaseResistance, 0, m.velocity, m.shaftAngle()}
+		i := int(value)
+		if i < 0 || i >= len(values) {
+			return "err"
+		}
+		return fmt.Sprintf("Monitor | %s: %.3f", names[i], values[i])
+	case 'D':
+		if set && value >= 1 {
+			b.monitorDownsample = int(value)
+		}
+		return fmt.Sprintf("Monitor | downsample: %d", b.monitorDownsample)
+	case 'C':
+		b.monitorVars = 0
+		return "Monitor | clear"
+	case 'S':
+		bits := cmd[1:]
+		if bits != "" {
+			b.monitorVars = 0
+		}
+		for i := 0; i < len(bits) && i < 7; i++ {
+			if bits[i] == '1' {
+				b.monitorVars |= 1 << (6 - i)
+			}
+		}
+		return "Monitor | " + bits
+	}
+	return "err"
+}
+
+func pidCommand(label string, pid *pid, tf *float64, cmd string) string {
+	if cmd == "" {
+		return "err"
+	}
+	value, set := parseValue(cmd[1:])
+	var name string
+	var field *float64
+	switch cmd[0] {
+	case 'P':
+		name, field = "P", &pid.p
+	case 'I':
+		name, field = "I", &pid.i
+	case 'D':
+		name, field = "D", &pid.d
+	case 'R':
+		name, field = "ramp", &pid.ramp
+	case 'L':
+		name, field = "limit", &pid.limit
+	case 'F':
+		name, field = "Tf", tf
+	default:
+		return "err"
+	}
+	if set {
+		*field = value
+	}
+	return fmt.Sprintf("%s| %s: %.3f", label, name, *field)
+}
+
+func parseValue(s string) (float64, bool) {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	return v, err == nil
+}
+
+// Read blocks until the board has sent something, the read timeout passes
+// or the port is closed.
+func (b *Board) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	timeout := b.readTimeout
+	b.mu.Unlock()
+
+	var deadline <-chan time.Time
+	if timeout != serial.NoTimeout {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	for {
+		b.mu.Lock()
+		if b.closed {
+			b.mu.Unlock()
+			return 0, errClosed
+		}
+		if b.out.Len() > 0 {
+			n, _ := b.out.Read(p)
+			b.mu.Unlock()
+			return n, nil
+		}
+		b.mu.Unlock()
+
+		select {
+		case <-b.notify:
+		case <-b.done:
+		case <-deadline:
+			return 0, nil
+		}
+	}
+}
+
+// Write takes commands from the host, running each complete line.
+func (b *Board) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, errClosed
+	}
+	b.in = append(b.in, p...)
+	for {
+		i := bytes.IndexByte(b.in, '\n')
+		if i < 0 {
+			break
+		}
+		line := string(b.in[:i])
+		b.in = b.in[i+1:]
+		b.command(line)
+	}
+	return len(p), nil
+}
+
+func (b *Board) ResetInputBuffer() error {
+	b.mu.Lock()
+	b.out.Reset()
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *Board) ResetOutputBuffer() error {
+	return nil
+}
+
+func (b *Board) SetReadTimeout(t time.Duration) error {
+	b.mu.Lock()
+	b.readTimeout = t
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *Board) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.closed {
+		b.closed = true
+		close(b.done)
+	}
+	return nil
+}
+
+func (b *Board) SetMode(mode *serial.Mode) error { return nil }
+func (b *Board) Drain() error                    { return nil }
+func (b *Board) SetDTR(dtr bool) error           { return nil }
+func (b *Board) SetRTS(rts bool) error           { return nil }
+func (b *Board) Break(t time.Duration) error     { return nil }
+func (b *Board) GetModemStatusBits() (*serial.ModemStatusBits, error) {
+	return &serial.ModemStatusBits{}, nil
+}
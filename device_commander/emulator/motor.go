@@ -0,0 +1,100 @@
+package emulator
+
+import (
+	"math"
+	"math/rand"
+)
+
+// The simulated gimbal motor: a first order plant that turns at
+// motorGain rad/s per volt once it has caught up, with a mechanical time
+// constant of motorTau.
+const (
+	motorGain       = 2.0  // rad/s per V
+	motorTau        = 0.08 // s
+	phaseResistance = 10.0 // Ω, for the current estimate
+	velocityNoise   = 0.05 // rad/s, sensor noise before filtering
+)
+
+// pid is SimpleFOC's PID controller.
+type pid struct {
+	p, i, d     float64
+	ramp, limit float64
+
+	integral, lastError, lastOutput float64
+}
+
+func (c *pid) update(err, dt float64) float64 {
+	proportional := c.p * err
+	c.integral = clamp(c.integral+c.i*dt*0.5*(err+c.lastError), c.limit)
+	derivative := c.d * (err - c.lastError) / dt
+	output := clamp(proportional+c.integral+derivative, c.limit)
+
+	if c.ramp > 0 {
+		rate := (output - c.lastOutput) / dt
+		if rate > c.ramp {
+			output = c.lastOutput + c.ramp*dt
+		} else if rate < -c.ramp {
+			output = c.lastOutput - c.ramp*dt
+		}
+	}
+	c.lastError = err
+	c.lastOutput = output
+	return output
+}
+
+type motor struct {
+	target    float64
+	angleMode bool
+
+	velocityPID, anglePID, currentQPID pid
+	velocityTf, angleTf, currentQTf    float64
+	voltageLimit                       float64
+	currentLimit                       float64
+	velocityLimit                      float64
+	sensorOffset                       float64
+
+	trueVelocity float64 // What the rotor does
+	velocity     float64 // Filtered, what the board measures
+	angle        float64 // Sensor angle, before the offset
+	voltageQ     float64
+}
+
+// newMotor has the gains and limits the firmware sets up.
+func newMotor() motor {
+	return motor{
+		velocityPID:   pid{p: 0.751, i: 2.672, d: 0.00005, ramp: 100000, limit: 50},
+		anglePID:      pid{p: 20, limit: 20},
+		currentQPID:   pid{p: 5, i: 1000, ramp: 1e6, limit: 12},
+		velocityTf:    0.05,
+		currentQTf:    0.005,
+		voltageLimit:  12,
+		currentLimit:  1,
+		velocityLimit: 20,
+	}
+}
+
+func (m *motor) shaftAngle() float64 {
+	return m.angle - m.sensorOffset
+}
+
+// step runs one loop of the velocity or angle controller and moves the
+// rotor dt seconds on.
+func (m *motor) step(dt float64, noise *rand.Rand) {
+	measured := m.trueVelocity + noise.NormFloat64()*velocityNoise
+	alpha := m.velocityTf / (m.velocityTf + dt)
+	m.velocity = alpha*m.velocity + (1-alpha)*measured
+
+	setpoint := m.target
+	if m.angleMode {
+		setpoint = m.anglePID.update(m.target-m.shaftAngle(), dt)
+	}
+	setpoint = clamp(setpoint, m.velocityLimit)
+	m.voltageQ = clamp(m.velocityPID.update(setpoint-m.velocity, dt), m.voltageLimit)
+
+	m.trueVelocity += (motorGain*m.voltageQ - m.trueVelocity) / motorTau * dt
+	m.angle += m.trueVelocity * dt
+}
+
+func clamp(v, limit float64) float64 {
+	return math.Max(-limit, math.Min(limit, v))
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"device_commander/telemetry"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+const (
+	graphLabelWidth = 16
+	minGraphWindow  = 2 * time.Second
+	maxGraphWindow  = 2 * time.Minute
+)
+
+var (
+	showGraphs  bool
+	graphWindow = 10 * time.Second
+)
+
+var sparkRunes = []rune("▁▂▃▄▅▆▇█")
+
+// graphSeries is one row of a device's graph pane. Rows of the same group
+// share a scale, so commanded and measured velocity can be compared.
+type graphSeries struct {
+	label string
+	group string
+	color tcell.Color
+	value func(telemetry.Sample) float64
+}
+
+// In the order they are dropped last when a pane is short of rows
+var graphSeriesList = []graphSeries{
+	{"velocity", "velocity", tcell.ColorGreen, func(s telemetry.Sample) float64 { return s.Velocity }},
+	{"target", "velocity", tcell.ColorYellow, func(s telemetry.Sample) float64 { return s.Target }},
+	{"current q", "current", tcell.ColorRed, func(s telemetry.Sample) float64 { return s.CurrentQ }},
+	{"angle", "angle", tcell.ColorDodgerBlue, func(s telemetry.Sample) float64 { return s.Angle }},
+}
+
+// changeGraphWindow doubles or halves how much time the graphs show.
+func changeGraphWindow(longer bool) {
+	if longer && graphWindow*2 <= maxGraphWindow {
+		graphWindow *= 2
+	} else if !longer && graphWindow/2 >= minGraphWindow {
+		graphWindow /= 2
+	}
+}
+
+// bucketSamples averages a series into one value per column over the graph
+// window. Columns without samples are NaN.
+func bucketSamples(samples []telemetry.Sample, now time.Time, columns int, value func(telemetry.Sample) float64) []float64 {
+	sums := make([]float64, columns)
+	counts := make([]int, columns)
+	start := now.Add(-graphWindow)
+	for _, s := range samples {
+		column := int(float64(s.At.Sub(start)) / float64(graphWindow) * float64(columns))
+		if column < 0 || column >= columns {
+			continue
+		}
+		sums[column] += value(s)
+		counts[column]++
+	}
+	for i := range sums {
+		if counts[i] == 0 {
+			sums[i] = math.NaN()
+		} else {
+			sums[i] /= float64(counts[i])
+		}
+	}
+	return sums
+}
+
+// drawDeviceGraphs draws a device's telemetry as sparklines, one series per
+// row, in as many rows as it is given.
+func drawDeviceGraphs(x, y, width, rows int, deviceID string) {
+	if rows <= 0 {
+		return
+	}
+	now := time.Now()
+	samples := telemetryMonitor.Samples(deviceID, now.Add(-graphWindow))
+	if len(samples) == 0 {
+		drawText(x, y, width, "No telemetry, type TEL in Send to All to start it")
+		return
+	}
+
+	columns := width - graphLabelWidth
+	if columns <= 0 {
+		return
+	}
+	series := graphSeriesList[:min(rows, len(graphSeriesList))]
+	values := make([][]float64, len(series))
+	low := make(map[string]float64)
+	high := make(map[string]float64)
+	for i, gs := range series {
+		values[i] = bucketSamples(samples, now, columns, gs.value)
+		for _, v := range values[i] {
+			if math.IsNaN(v) {
+				continue
+			}
+			if l, ok := low[gs.group]; !ok || v < l {
+				low[gs.group] = v
+			}
+			if h, ok := high[gs.group]; !ok || v > h {
+				high[gs.group] = v
+			}
+		}
+	}
+
+	latest := samples[len(samples)-1]
+	for i, gs := range series {
+		style := tcell.StyleDefault.Foreground(gs.color)
+		drawStyledText(x, y+i, graphLabelWidth, fmt.Sprintf("%-9s%7.2f", gs.label, gs.value(latest)), style)
+
+		l, h := low[gs.group], high[gs.group]
+		for column, v := range values[i] {
+			r := ' '
+			if !math.IsNaN(v) {
+				level := len(sparkRunes) / 2
+				if h > l {
+					level = int((v - l) / (h - l) * float64(len(sparkRunes)-1))
+				}
+				r = sparkRunes[level]
+			}
+			screen.SetContent(x+graphLabelWidth+column, y+i, r, nil, style)
+		}
+	}
+}
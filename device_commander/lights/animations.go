@@ -1,8 +1,9 @@
 package lights
 
 import (
-	"log"
+	"fmt"
 	"math"
+	"sort"
 	"time"
 
 	"github.com/fogleman/gg"
@@ -12,15 +13,13 @@ type RGBW struct {
 	R, G, B, W uint8
 }
 
+// RGBA shows the colour as it would appear on screen, with the W channel
+// added to R, G and B.
 func (c RGBW) RGBA() (r, g, b, a uint32) {
-	r = uint32(c.R)
-	g = uint32(c.G)
-	b = uint32(c.B)
 	w := uint32(c.W)
-	// Combine RGB and W, you might need to adjust this based on your LED characteristics
-	r = (r*255 + w*255) / 255
-	g = (g*255 + w*255) / 255
-	b = (b*255 + w*255) / 255
+	r = min(255, uint32(c.R)+w) * 0x101
+	g = min(255, uint32(c.G)+w) * 0x101
+	b = min(255, uint32(c.B)+w) * 0x101
 	a = 0xffff
 	return
 }
@@ -55,155 +54,232 @@ var LUT = [LUT_LEN]int{
 	__, __, __, 53, __, 52, __, 51, __, __, __,
 }
 
-func growingShrinkingHexagon(panel *HexagonPanel) {
-	maxSize := float64(LUT_W)/2 + 1 // Maximum size of the hexagon
+// Animation is a light animation rendered one frame at a time. Render is
+// called with the time elapsed since the animation started and the frame
+// buffer to draw into; the buffer still holds the previous frame, so an
+// animation only needs to write the LEDs it changes.
+type Animation struct {
+	Name     string
+	Duration time.Duration // Length of one pass, 0 means it runs until replaced
+	Render   func(t time.Duration, leds []RGBW)
+}
 
-	log.Printf("Playing animation: growingShrinking hexagon: %f", maxSize)
-	for {
-		for size := 0.0; size <= maxSize; size += 0.1 {
-			renderHexagonFrame(panel, size)
-		}
+// AnimationFactory builds an animation from optional named parameters.
+type AnimationFactory func(params map[string]float64) Animation
+
+var animationRegistry = map[string]AnimationFactory{
+	"growingShrinkingHexagon": growingShrinkingHexagon,
+	"whiteLEDCascade":         whiteLEDCascade,
+	"offLEDCascade":           offLEDCascade,
+	"rainbowHueShift":         rainbowHueShift,
+	"solidColor":              solidColor,
+	"flash":                   flash,
+}
 
-		for size := maxSize; size >= 0; size -= 0.1 {
-			renderHexagonFrame(panel, size)
+// NewAnimation looks up a registered animation by name and builds it with
+// the given parameters. Missing parameters fall back to the defaults.
+func NewAnimation(name string, params map[string]float64) (Animation, error) {
+	factory, ok := animationRegistry[name]
+	if !ok {
+		return Animation{}, fmt.Errorf("unknown animation: %s", name)
+	}
+	// Times, colours and levels are all positive
+	for key, v := range params {
+		if v < 0 {
+			return Animation{}, fmt.Errorf("%s: %s can't be negative, got %v", name, key, v)
 		}
 	}
+	animation := factory(params)
+	// An animation that takes time must still take some, or a playlist
+	// would spin on it and the render would divide by zero
+	if factory(nil).Duration > 0 && animation.Duration <= 0 {
+		return Animation{}, fmt.Errorf("%s: parameters leave it no length, got %v", name, params)
+	}
+	return animation, nil
+}
+
+// AnimationNames returns the names of all registered animations.
+func AnimationNames() []string {
+	names := make([]string, 0, len(animationRegistry))
+	for name := range animationRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func param(params map[string]float64, key string, fallback float64) float64 {
+	if v, ok := params[key]; ok {
+		return v
+	}
+	return fallback
+}
+
+func growingShrinkingHexagon(params map[string]float64) Animation {
+	maxSize := float64(LUT_W)/2 + 1 // Maximum size of the hexagon
+	step := time.Duration(param(params, "step", 50)) * time.Millisecond
+	half := time.Duration(maxSize/0.1) * step
+
+	return Animation{
+		Name:     "growingShrinkingHexagon",
+		Duration: 2 * half,
+		Render: func(t time.Duration, leds []RGBW) {
+			t %= 2 * half
+			size := float64(t) / float64(half) * maxSize
+			if t > half {
+				size = 2*maxSize - size
+			}
+			renderHexagonFrame(leds, size)
+		},
+	}
 }
 
-func renderHexagonFrame(panel *HexagonPanel, size float64) {
+func renderHexagonFrame(leds []RGBW, size float64) {
 	dc := gg.NewContext(LUT_W, LUT_H)
 	dc.SetRGB(0, 0, 0) // Set background to black
 	dc.Clear()
 
 	// Calculate color based on size (gradient from red to blue)
-	r := uint8(255 * (size / (float64(LUT_W) / 2)))
+	ratio := math.Min(1, size/(float64(LUT_W)/2))
+	r := uint8(255 * ratio)
 	g := uint8(0)
-	b := uint8(255 * (1 - (size / (float64(LUT_W) / 2))))
+	b := uint8(255 * (1 - ratio))
 	DrawHexagon(dc, float64(LUT_W)/2, float64(LUT_H)/2, size, RGBW{R: r, G: g, B: b, W: 0})
 
 	// Convert the drawing to LED data
-	panel.DrawToPanel(dc)
-
-	// Convert RGBW to uint32 for the LED driver
-	ledData := make([]uint32, len(panel.Leds))
-	for i, led := range panel.Leds {
-		ledData[i] = uint32(led.R)<<24 | uint32(led.G)<<16 | uint32(led.B)<<8 | uint32(led.W)
-	}
-
-	// Render the frame
-	if err := panel.Driver.Render(ledData); err != nil {
-		log.Printf("Error rendering frame: %v", err)
-	}
-
-	// Add debug logging
-	log.Printf("Rendered frame with size: %f, LED data: %v", size, ledData)
-
-	time.Sleep(50 * time.Millisecond) // Adjust speed as needed
+	drawToLeds(dc, leds)
 }
 
 func (h *HexagonPanel) DrawToPanel(dc *gg.Context) {
-	for y := 0; y < h.Height; y++ {
-		for x := 0; x < h.Width; x++ {
-			index := LUT[y*h.Width+x]
+	drawToLeds(dc, h.Leds)
+}
+
+func drawToLeds(dc *gg.Context, leds []RGBW) {
+	for y := 0; y < LUT_H; y++ {
+		for x := 0; x < LUT_W; x++ {
+			index := LUT[y*LUT_W+x]
 			if index != __ {
-				r, g, b, a := dc.Image().At(x, y).RGBA()
-				h.Leds[index] = RGBW{
+				// White is pulled into the W channel by the colour pipeline
+				r, g, b, _ := dc.Image().At(x, y).RGBA()
+				leds[index] = RGBW{
 					R: uint8(r >> 8),
 					G: uint8(g >> 8),
 					B: uint8(b >> 8),
-					W: uint8(a >> 8),
 				}
 			}
 		}
 	}
 }
 
-func whiteLEDCascade(panel *HexagonPanel) {
-	log.Println("Playing animation: White LED Cascade")
-	// White LED Cascade animation
-	for i := 0; i < len(panel.Leds); i++ {
-		// Set each LED to full white (255 for all channels)
-		panel.Leds[i] = RGBW{R: 120, G: 120, B: 120, W: 120}
-
-		// Create a slice to hold LED data for rendering
-		ledData := make([]uint32, len(panel.Leds))
-
-		// Convert RGBW values to uint32 for each LED
-		for j, led := range panel.Leds {
-			ledData[j] = uint32(led.R)<<24 | uint32(led.G)<<16 | uint32(led.B)<<8 | uint32(led.W)
-		}
-
-		// Render the current frame
-		if err := panel.Driver.Render(ledData); err != nil {
-			log.Printf("Error rendering frame: %v", err)
-		}
-
-		// Pause briefly between each LED lighting up
-		time.Sleep(200 * time.Millisecond)
+func whiteLEDCascade(params map[string]float64) Animation {
+	interval := time.Duration(param(params, "interval", 200)) * time.Millisecond
+	hold := time.Duration(param(params, "hold", 2000)) * time.Millisecond
+	level := uint8(param(params, "level", 120))
+
+	return Animation{
+		Name:     "whiteLEDCascade",
+		Duration: time.Duration(ledCounts)*interval + hold,
+		Render: func(t time.Duration, leds []RGBW) {
+			// Light one more LED every interval, then hold with all of them lit
+			lit := len(leds)
+			if interval > 0 {
+				lit = min(len(leds), int(t/interval)+1)
+			}
+			for i := 0; i < lit; i++ {
+				leds[i] = RGBW{R: level, G: level, B: level, W: level}
+			}
+		},
 	}
-
-	// Pause after all LEDs are lit
-	time.Sleep(2 * time.Second)
 }
 
-func offLEDCascade(panel *HexagonPanel) {
-	log.Println("Playing animation: Turn Off LEDs One by One")
-
-	// Create a slice to hold LED data for rendering
-	ledData := make([]uint32, len(panel.Leds))
-
-	// Turn off LEDs one by one
-	for i := 0; i < len(ledData); i++ {
-		// Turn off the current LED
-		ledData[i] = 0 // All channels off
-
-		// Render the current frame
-		if err := panel.Driver.Render(ledData); err != nil {
-			log.Printf("Error rendering frame: %v", err)
-		}
-
-		// Pause briefly between turning off each LED
-		time.Sleep(200 * time.Millisecond)
+func offLEDCascade(params map[string]float64) Animation {
+	interval := time.Duration(param(params, "interval", 200)) * time.Millisecond
+	hold := time.Duration(param(params, "hold", 2000)) * time.Millisecond
+
+	return Animation{
+		Name:     "offLEDCascade",
+		Duration: time.Duration(ledCounts)*interval + hold,
+		Render: func(t time.Duration, leds []RGBW) {
+			// Turn off LEDs one by one, leaving the rest as they were
+			off := len(leds)
+			if interval > 0 {
+				off = min(len(leds), int(t/interval)+1)
+			}
+			for i := 0; i < off; i++ {
+				leds[i] = RGBW{}
+			}
+		},
 	}
-
-	// Pause after all LEDs are off
-	time.Sleep(2 * time.Second)
 }
 
-func rainbowHueShift(panel *HexagonPanel) {
-	log.Println("Playing animation: Rainbow Hue Shift")
-
-	// Create a slice to hold LED data for rendering
-	ledData := make([]uint32, len(panel.Leds))
+func rainbowHueShift(params map[string]float64) Animation {
+	// Duration of one complete hue cycle
+	cycleDuration := time.Duration(param(params, "cycle", 180)) * time.Second
 
-	// Define the duration of one complete hue cycle
-	cycleDuration := 180 * time.Second
+	return Animation{
+		Name:     "rainbowHueShift",
+		Duration: cycleDuration,
+		Render: func(t time.Duration, leds []RGBW) {
+			// Calculate the current hue (0-360 degrees)
+			hue := float64(t%cycleDuration) / float64(cycleDuration) * 360.0
 
-	// Calculate the number of steps for a smooth transition
-	steps := 500
+			// Convert HSV to RGB
+			r, g, b := hsvToRgb(hue, 1.0, 1.0)
 
-	for step := 0; step < steps; step++ {
-		// Calculate the current hue (0-360 degrees)
-		hue := float64(step) / float64(steps) * 360.0
-
-		// Convert HSV to RGB
-		r, g, b := hsvToRgb(hue, 1.0, 1.0)
+			// Set all LEDs to the current color, W channel is 0
+			for i := range leds {
+				leds[i] = RGBW{R: r, G: g, B: b, W: 0}
+			}
+		},
+	}
+}
 
-		// Set all LEDs to the current color
-		for i := range ledData {
-			ledData[i] = uint32(r)<<24 | uint32(g)<<16 | uint32(b)<<8 | uint32(0) // W channel is 0
-		}
+func solidColor(params map[string]float64) Animation {
+	color := RGBW{
+		R: uint8(param(params, "r", 0)),
+		G: uint8(param(params, "g", 0)),
+		B: uint8(param(params, "b", 0)),
+		W: uint8(param(params, "w", 0)),
+	}
 
-		// Render the current frame
-		if err := panel.Driver.Render(ledData); err != nil {
-			log.Printf("Error rendering frame: %v", err)
-		}
+	return Animation{
+		Name: "solidColor",
+		Render: func(t time.Duration, leds []RGBW) {
+			for i := range leds {
+				leds[i] = color
+			}
+		},
+	}
+}
 
-		// Calculate sleep duration for smooth transition
-		time.Sleep(cycleDuration / time.Duration(steps))
+func flash(params map[string]float64) Animation {
+	color := RGBW{
+		R: uint8(param(params, "r", 0)),
+		G: uint8(param(params, "g", 0)),
+		B: uint8(param(params, "b", 0)),
+		W: uint8(param(params, "w", 255)),
+	}
+	decay := time.Duration(param(params, "decay", 300)) * time.Millisecond
+
+	return Animation{
+		Name:     "flash",
+		Duration: decay,
+		Render: func(t time.Duration, leds []RGBW) {
+			// Full brightness at the start, fading linearly to off
+			level := math.Max(0, 1-float64(t)/float64(decay))
+			for i := range leds {
+				leds[i] = mix(RGBW{}, color, level)
+			}
+		},
 	}
 }
 
+// HSV returns the RGBW colour for a hue (0-360), saturation and value (0-1).
+func HSV(h, s, v float64) RGBW {
+	r, g, b := hsvToRgb(math.Mod(math.Mod(h, 360)+360, 360), s, v)
+	return RGBW{R: r, G: g, B: b}
+}
+
 // hsvToRgb converts HSV (Hue, Saturation, Value) to RGB
 func hsvToRgb(h, s, v float64) (uint8, uint8, uint8) {
 	c := v * s
@@ -229,19 +305,3 @@ func hsvToRgb(h, s, v float64) (uint8, uint8, uint8) {
 
 	return uint8((r + m) * 255), uint8((g + m) * 255), uint8((b + m) * 255)
 }
-
-func Run(panel *HexagonPanel) {
-	// Define animation functions
-	animations := []func(*HexagonPanel){
-		// growingShrinkingHexagon,
-		rainbowHueShift,
-		offLEDCascade,
-		whiteLEDCascade,
-	}
-
-	for {
-		for _, animation := range animations {
-			animation(panel)
-		}
-	}
-}
@@ -0,0 +1,36 @@
+package lights
+
+import "testing"
+
+func TestNewAnimationParams(t *testing.T) {
+	tests := []struct {
+		name   string
+		params map[string]float64
+		valid  bool
+	}{
+		{"whiteLEDCascade", nil, true},
+		{"whiteLEDCascade", map[string]float64{"interval": 0}, true},
+		{"whiteLEDCascade", map[string]float64{"hold": 0}, true},
+		{"whiteLEDCascade", map[string]float64{"hold": -6000}, false},
+		{"offLEDCascade", map[string]float64{"interval": 0, "hold": 0}, false},
+		{"growingShrinkingHexagon", map[string]float64{"step": 0}, false},
+		{"growingShrinkingHexagon", map[string]float64{"step": 0.0001}, false},
+		{"rainbowHueShift", map[string]float64{"cycle": 0}, false},
+		{"flash", map[string]float64{"decay": 0}, false},
+		{"flash", map[string]float64{"r": -1}, false},
+		{"solidColor", nil, true},
+	}
+	for _, test := range tests {
+		animation, err := NewAnimation(test.name, test.params)
+		if test.valid && err != nil {
+			t.Errorf("%s %v: %v", test.name, test.params, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s %v is valid, %s long", test.name, test.params, animation.Duration)
+		}
+		if err == nil && animation.Duration > 0 {
+			// Renders without dividing by zero
+			animation.Render(animation.Duration/2, make([]RGBW, ledCounts))
+		}
+	}
+}
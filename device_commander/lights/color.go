@@ -0,0 +1,99 @@
+package lights
+
+import (
+	"fmt"
+	"math"
+)
+
+// Calibration describes how colours are corrected before they are sent to
+// the SK6812 RGBW strip.
+type Calibration struct {
+	// Gamma per channel in R, G, B, W order. The LEDs are linear in PWM while
+	// animations (and the pattern_editor) work in perceptual sRGB values.
+	Gamma [4]float64 `json:"gamma"`
+	// Balance scales the R, G and B channels so that equal values look
+	// neutral on the panel.
+	Balance [3]float64 `json:"balance"`
+	// WhitePoint is the colour of the W LED at full brightness expressed as
+	// linear R, G, B values. It decides how much of a colour can be moved
+	// into the W channel.
+	WhitePoint [3]uint8 `json:"white_point"`
+	// ExtractWhite moves the white shared by R, G and B into the W channel.
+	ExtractWhite bool `json:"extract_white"`
+}
+
+// DefaultCalibration matches the neutral white SK6812 LEDs on the panel.
+var DefaultCalibration = Calibration{
+	Gamma:        [4]float64{2.6, 2.6, 2.6, 2.6},
+	Balance:      [3]float64{1.0, 0.85, 0.75},
+	WhitePoint:   [3]uint8{255, 224, 180},
+	ExtractWhite: true,
+}
+
+func (c Calibration) Validate() error {
+	for i, g := range c.Gamma {
+		if g <= 0 {
+			return fmt.Errorf("invalid gamma for channel %d: %f", i, g)
+		}
+	}
+	for i, b := range c.Balance {
+		if b < 0 || b > 1 {
+			return fmt.Errorf("invalid balance for channel %d: %f", i, b)
+		}
+	}
+	if c.ExtractWhite && (c.WhitePoint[0] == 0 || c.WhitePoint[1] == 0 || c.WhitePoint[2] == 0) {
+		return fmt.Errorf("white point needs all channels to extract white: %v", c.WhitePoint)
+	}
+	return nil
+}
+
+// ColorPipeline turns the colours produced by animations into the values
+// written to the strip: gamma, then white extraction, then white balance.
+type ColorPipeline struct {
+	calibration Calibration
+	gamma       [4][256]uint8
+}
+
+func NewColorPipeline(calibration Calibration) *ColorPipeline {
+	p := &ColorPipeline{calibration: calibration}
+	for ch, g := range calibration.Gamma {
+		for v := 0; v < 256; v++ {
+			p.gamma[ch][v] = uint8(math.Round(math.Pow(float64(v)/255, g) * 255))
+		}
+	}
+	return p
+}
+
+func (p *ColorPipeline) Calibration() Calibration {
+	return p.calibration
+}
+
+// Apply converts one animation colour to the value for the strip.
+func (p *ColorPipeline) Apply(c RGBW) RGBW {
+	r := float64(p.gamma[0][c.R])
+	g := float64(p.gamma[1][c.G])
+	b := float64(p.gamma[2][c.B])
+	w := float64(p.gamma[3][c.W])
+
+	if p.calibration.ExtractWhite {
+		// The largest amount of the W LED's colour that fits inside r, g, b
+		wp := p.calibration.WhitePoint
+		shared := math.Min(r/float64(wp[0]), math.Min(g/float64(wp[1]), b/float64(wp[2])))
+		shared = math.Min(shared, (255-w)/255)
+		r -= shared * float64(wp[0])
+		g -= shared * float64(wp[1])
+		b -= shared * float64(wp[2])
+		w += shared * 255
+	}
+
+	return RGBW{
+		R: clampChannel(r * p.calibration.Balance[0]),
+		G: clampChannel(g * p.calibration.Balance[1]),
+		B: clampChannel(b * p.calibration.Balance[2]),
+		W: clampChannel(w),
+	}
+}
+
+func clampChannel(v float64) uint8 {
+	return uint8(math.Max(0, math.Min(255, math.Round(v))))
+}
@@ -0,0 +1,428 @@
+package lights
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	sACNPort   = 5568
+	artNetPort = 6454
+
+	dmxChannels    = 512
+	channelsPerLED = 4 // R, G, B, W
+
+	sACNDataOffset     = 126
+	sACNOptionTerminus = 0x40
+	sACNOptionPreview  = 0x80
+	artNetOpDmx        = 0x5000
+	artNetDataOffset   = 18
+)
+
+var (
+	sACNIdentifier   = []byte("ASC-E1.17\x00\x00\x00")
+	artNetIdentifier = []byte("Art-Net\x00")
+)
+
+// DMXConfig maps a DMX universe onto the panel, four channels (R, G, B, W)
+// per LED starting at StartAddress.
+type DMXConfig struct {
+	Enabled        bool       `json:"enabled"`
+	Universe       int        `json:"universe"`
+	StartAddress   int        `json:"start_address"` // 1-based DMX address of LED 0's red channel
+	SACN           bool       `json:"sacn"`
+	ArtNet         bool       `json:"artnet"`
+	Multicast      bool       `json:"multicast"` // Join the sACN multicast group as well as listening for unicast
+	ArtNetPriority int        `json:"artnet_priority"`
+	Timeout        int        `json:"timeout"` // Milliseconds without data before a source is dropped
+	Release        Transition `json:"release"` // How to hand back to the playlist when the stream stops
+}
+
+var DefaultDMXConfig = DMXConfig{
+	Universe:       1,
+	StartAddress:   1,
+	SACN:           true,
+	ArtNet:         true,
+	ArtNetPriority: 100,
+	Timeout:        2500, // The E1.31 network data loss timeout
+	Release:        Transition{Kind: TransitionCrossfade, Duration: 2000},
+}
+
+func (c DMXConfig) Validate() error {
+	if c.Universe < 0 || c.Universe > 63999 {
+		return fmt.Errorf("invalid DMX universe: %d", c.Universe)
+	}
+	if c.StartAddress < 1 || c.StartAddress > dmxChannels {
+		return fmt.Errorf("invalid DMX start address: %d", c.StartAddress)
+	}
+	if c.ArtNetPriority < 0 || c.ArtNetPriority > 200 {
+		return fmt.Errorf("invalid Art-Net priority: %d", c.ArtNetPriority)
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("invalid DMX timeout: %d", c.Timeout)
+	}
+	return c.Release.Validate()
+}
+
+// dmxPacket is the part of an sACN or Art-Net packet the receiver uses.
+type dmxPacket struct {
+	universe   int
+	priority   int
+	terminated bool
+	data       []byte
+}
+
+type dmxSource struct {
+	priority int
+	lastSeen time.Time
+	data     [dmxChannels]byte
+}
+
+type DMXStatus struct {
+	Active   bool `json:"active"`
+	Sources  int  `json:"sources"`
+	Universe int  `json:"universe"`
+	Priority int  `json:"priority"`
+}
+
+// DMXReceiver drives the panel from sACN (E1.31) and Art-Net. While a source
+// is sending it takes over the engine; once every source has stopped or
+// timed out the engine goes back to whatever the playlist is playing.
+type DMXReceiver struct {
+	config DMXConfig
+	engine *Engine
+
+	mu      sync.Mutex
+	sources map[string]*dmxSource
+	leds    []RGBW
+	active  bool
+	conns   []net.PacketConn // Open listeners, for Close
+	closed  bool
+}
+
+func NewDMXReceiver(engine *Engine, config DMXConfig) *DMXReceiver {
+	return &DMXReceiver{
+		config:  config,
+		engine:  engine,
+		sources: make(map[string]*dmxSource),
+		leds:    make([]RGBW, ledCounts),
+	}
+}
+
+// Run starts the configured listeners and the source timeout watchdog. It
+// only returns if a listener fails.
+func (r *DMXReceiver) Run() error {
+	errs := make(chan error, 2)
+	if r.config.SACN {
+		go func() { errs <- r.ListenSACN(fmt.Sprintf(":%d", sACNPort)) }()
+	}
+	if r.config.ArtNet {
+		go func() { errs <- r.ListenArtNet(fmt.Sprintf(":%d", artNetPort)) }()
+	}
+	if !r.config.SACN && !r.config.ArtNet {
+		return fmt.Errorf("no DMX protocol enabled")
+	}
+
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-errs:
+			return err
+		case now := <-ticker.C:
+			r.expireSources(now)
+		}
+	}
+}
+
+// ListenSACN receives E1.31 packets on a UDP address until reading fails.
+// With multicast on it joins the universe's group on the address's port.
+// That socket is bound to every local address, so it gets unicast packets
+// as well and no second socket is needed.
+func (r *DMXReceiver) ListenSACN(addr string) error {
+	var conn net.PacketConn
+	if r.config.Multicast {
+		local, err := net.ResolveUDPAddr("udp4", addr)
+		if err != nil {
+			return fmt.Errorf("error listening for sACN: %w", err)
+		}
+		group := &net.UDPAddr{
+			IP:   net.IPv4(239, 255, byte(r.config.Universe>>8), byte(r.config.Universe)),
+			Port: local.Port,
+		}
+		if conn, err = net.ListenMulticastUDP("udp4", nil, group); err != nil {
+			return fmt.Errorf("error joining sACN multicast group %s: %w", group, err)
+		}
+	} else {
+		var err error
+		if conn, err = net.ListenPacket("udp", addr); err != nil {
+			return fmt.Errorf("error listening for sACN: %w", err)
+		}
+	}
+	if err := r.track(conn); err != nil {
+		conn.Close()
+		return err
+	}
+	defer conn.Close()
+	logger.Info("Listening for sACN", "universe", r.config.Universe, "addr", conn.LocalAddr(), "multicast", r.config.Multicast)
+	return r.listen(conn, "sacn", parseSACN)
+}
+
+// ListenArtNet receives ArtDmx packets on a UDP address until reading fails.
+func (r *DMXReceiver) ListenArtNet(addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return fmt.Errorf("error listening for Art-Net: %w", err)
+	}
+	if err := r.track(conn); err != nil {
+		conn.Close()
+		return err
+	}
+	defer conn.Close()
+	logger.Info("Listening for Art-Net", "universe", r.config.Universe, "addr", conn.LocalAddr())
+	return r.listen(conn, "artnet", r.parseArtNet)
+}
+
+// track keeps a listener's socket so Close can stop it.
+func (r *DMXReceiver) track(conn net.PacketConn) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return fmt.Errorf("DMX receiver closed")
+	}
+	r.conns = append(r.conns, conn)
+	return nil
+}
+
+// Close stops the listeners, which then return an error.
+func (r *DMXReceiver) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	var errs []error
+	for _, conn := range r.conns {
+		errs = append(errs, conn.Close())
+	}
+	r.conns = nil
+	return errors.Join(errs...)
+}
+
+func (r *DMXReceiver) listen(conn net.PacketConn, protocol string, parse func([]byte) (dmxPacket, error)) error {
+	buffer := make([]byte, 1144) // Largest E1.31 data packet
+	for {
+		n, from, err := conn.ReadFrom(buffer)
+		if err != nil {
+			return fmt.Errorf("error reading %s packet: %w", protocol, err)
+		}
+
+		packet, err := parse(buffer[:n])
+		if err != nil {
+			logger.Debug("Ignoring packet", "protocol", protocol, "from", from, "err", err)
+			continue
+		}
+		if packet.universe != r.config.Universe {
+			continue
+		}
+		r.handlePacket(protocol+"/"+from.String(), packet, time.Now())
+	}
+}
+
+func (r *DMXReceiver) handlePacket(key string, packet dmxPacket, now time.Time) {
+	r.mu.Lock()
+	if packet.terminated {
+		delete(r.sources, key)
+		logger.Info("DMX source stopped sending", "source", key)
+		r.takeover(r.update())
+		return
+	}
+
+	source, ok := r.sources[key]
+	if !ok {
+		source = &dmxSource{}
+		r.sources[key] = source
+		logger.Info("DMX source started sending", "source", key, "universe", packet.universe)
+	}
+	source.priority = packet.priority
+	source.lastSeen = now
+	source.data = [dmxChannels]byte{}
+	copy(source.data[:], packet.data)
+	r.takeover(r.update())
+}
+
+func (r *DMXReceiver) expireSources(now time.Time) {
+	r.mu.Lock()
+	timeout := time.Duration(r.config.Timeout) * time.Millisecond
+	expired := false
+	for key, source := range r.sources {
+		if now.Sub(source.lastSeen) > timeout {
+			logger.Warn("DMX source timed out", "source", key)
+			delete(r.sources, key)
+			expired = true
+		}
+	}
+	if !expired {
+		r.mu.Unlock()
+		return
+	}
+	r.takeover(r.update())
+}
+
+// update merges the sources into the LED frame and reports whether the
+// receiver should hold the engine. The highest priority wins; sources
+// sharing that priority are merged highest-takes-precedence per channel.
+// Must be called with mu held.
+func (r *DMXReceiver) update() bool {
+	priority := -1
+	for _, source := range r.sources {
+		priority = max(priority, source.priority)
+	}
+
+	var merged [dmxChannels]byte
+	for _, source := range r.sources {
+		if source.priority != priority {
+			continue
+		}
+		for i, v := range source.data {
+			merged[i] = max(merged[i], v)
+		}
+	}
+
+	for i := range r.leds {
+		channel := r.config.StartAddress - 1 + i*channelsPerLED
+		if channel+channelsPerLED > dmxChannels {
+			r.leds[i] = RGBW{}
+			continue
+		}
+		r.leds[i] = RGBW{R: merged[channel], G: merged[channel+1], B: merged[channel+2], W: merged[channel+3]}
+	}
+
+	return len(r.sources) > 0
+}
+
+// takeover takes over or releases the engine and unlocks mu. The engine is
+// only called once mu is released because rendering locks mu from the
+// engine's side.
+func (r *DMXReceiver) takeover(active bool) {
+	changed := active != r.active
+	r.active = active
+	r.mu.Unlock()
+
+	switch {
+	case changed && active:
+		r.engine.Takeover(Animation{Name: "dmx", Render: r.render})
+	case changed && !active:
+		r.engine.Release("dmx", r.config.Release)
+	}
+}
+
+func (r *DMXReceiver) render(t time.Duration, leds []RGBW) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	copy(leds, r.leds)
+}
+
+func (r *DMXReceiver) Status() DMXStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	status := DMXStatus{Active: r.active, Sources: len(r.sources), Universe: r.config.Universe}
+	for _, source := range r.sources {
+		status.Priority = max(status.Priority, source.priority)
+	}
+	return status
+}
+
+func parseSACN(b []byte) (dmxPacket, error) {
+	if len(b) < sACNDataOffset || !bytes.Equal(b[4:16], sACNIdentifier) {
+		return dmxPacket{}, fmt.Errorf("not an E1.31 packet")
+	}
+	if binary.BigEndian.Uint32(b[18:22]) != 0x00000004 || binary.BigEndian.Uint32(b[40:44]) != 0x00000002 {
+		return dmxPacket{}, fmt.Errorf("not an E1.31 data packet")
+	}
+	if b[117] != 0x02 || b[125] != 0x00 {
+		return dmxPacket{}, fmt.Errorf("not DMX512 level data")
+	}
+
+	options := b[112]
+	if options&sACNOptionPreview != 0 {
+		return dmxPacket{}, fmt.Errorf("preview data")
+	}
+
+	// The property value count includes the start code
+	count := int(binary.BigEndian.Uint16(b[123:125])) - 1
+	if count < 0 || sACNDataOffset+count > len(b) {
+		return dmxPacket{}, fmt.Errorf("invalid property value count")
+	}
+
+	return dmxPacket{
+		universe:   int(binary.BigEndian.Uint16(b[113:115])),
+		priority:   int(b[108]),
+		terminated: options&sACNOptionTerminus != 0,
+		data:       b[sACNDataOffset : sACNDataOffset+count],
+	}, nil
+}
+
+func (r *DMXReceiver) parseArtNet(b []byte) (dmxPacket, error) {
+	if len(b) < artNetDataOffset || !bytes.Equal(b[:8], artNetIdentifier) {
+		return dmxPacket{}, fmt.Errorf("not an Art-Net packet")
+	}
+	if binary.LittleEndian.Uint16(b[8:10]) != artNetOpDmx {
+		return dmxPacket{}, fmt.Errorf("not an ArtDmx packet")
+	}
+
+	length := int(binary.BigEndian.Uint16(b[16:18]))
+	if artNetDataOffset+length > len(b) {
+		return dmxPacket{}, fmt.Errorf("invalid data length")
+	}
+
+	return dmxPacket{
+		universe: int(b[15]&0x7f)<<8 | int(b[14]),
+		priority: r.config.ArtNetPriority,
+		data:     b[artNetDataOffset : artNetDataOffset+length],
+	}, nil
+}
+
+// EncodeSACN builds an E1.31 data packet, e.g. for testing the receiver
+// with a local UDP sender.
+func EncodeSACN(universe, priority int, sequence byte, data []byte) []byte {
+	count := len(data) + 1
+	b := make([]byte, sACNDataOffset+len(data))
+	binary.BigEndian.PutUint16(b[0:2], 0x0010)
+	copy(b[4:16], sACNIdentifier)
+	binary.BigEndian.PutUint16(b[16:18], 0x7000|uint16(len(b)-16))
+	binary.BigEndian.PutUint32(b[18:22], 0x00000004)
+	copy(b[22:38], "hexagon_lamp____")
+	binary.BigEndian.PutUint16(b[38:40], 0x7000|uint16(len(b)-38))
+	binary.BigEndian.PutUint32(b[40:44], 0x00000002)
+	copy(b[44:108], "hexagon_lamp")
+	b[108] = byte(priority)
+	b[111] = sequence
+	binary.BigEndian.PutUint16(b[113:115], uint16(universe))
+	binary.BigEndian.PutUint16(b[115:117], 0x7000|uint16(len(b)-115))
+	b[117] = 0x02
+	b[118] = 0xa1
+	binary.BigEndian.PutUint16(b[121:123], 1)
+	binary.BigEndian.PutUint16(b[123:125], uint16(count))
+	copy(b[sACNDataOffset:], data)
+	return b
+}
+
+// EncodeArtDmx builds an ArtDmx packet, e.g. for testing the receiver with a
+// local UDP sender.
+func EncodeArtDmx(universe int, sequence byte, data []byte) []byte {
+	b := make([]byte, artNetDataOffset+len(data))
+	copy(b[:8], artNetIdentifier)
+	binary.LittleEndian.PutUint16(b[8:10], artNetOpDmx)
+	b[11] = 14 // Protocol version
+	b[12] = sequence
+	b[14] = byte(universe)
+	b[15] = byte(universe>>8) & 0x7f
+	binary.BigEndian.PutUint16(b[16:18], uint16(len(data)))
+	copy(b[artNetDataOffset:], data)
+	return b
+}
@@ -0,0 +1,238 @@
+package lights
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// freePort returns a UDP port on the loopback address that nothing is
+// listening on.
+func freePort(t *testing.T) int {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// sendUntilActive starts a listener and sends it packets until the receiver
+// takes over the engine.
+func sendUntilActive(t *testing.T, r *DMXReceiver, listen func(addr string) error, packet []byte) {
+	t.Helper()
+	port := freePort(t)
+	errs := make(chan error, 1)
+	go func() { errs <- listen(fmt.Sprintf(":%d", port)) }()
+
+	sender, err := net.Dial("udp4", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+
+	deadline := time.After(2 * time.Second)
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	for !r.Status().Active {
+		select {
+		case err := <-errs:
+			if r.config.Multicast {
+				t.Skipf("multicast not available: %v", err)
+			}
+			t.Fatalf("listener stopped: %v", err)
+		case <-deadline:
+			t.Fatal("receiver did not become active")
+		case <-ticker.C:
+			sender.Write(packet)
+		}
+	}
+}
+
+func newTestReceiver(config DMXConfig) *DMXReceiver {
+	return NewDMXReceiver(NewEngine(&HexagonPanel{Leds: make([]RGBW, ledCounts)}), config)
+}
+
+func TestDMXLoopback(t *testing.T) {
+	data := []byte{10, 20, 30, 40, 50, 60, 70, 80}
+
+	tests := []struct {
+		name      string
+		multicast bool
+		listen    func(r *DMXReceiver) func(string) error
+		packet    []byte
+		priority  int
+	}{
+		{"sacn", false, func(r *DMXReceiver) func(string) error { return r.ListenSACN }, EncodeSACN(1, 150, 0, data), 150},
+		{"sacn multicast", true, func(r *DMXReceiver) func(string) error { return r.ListenSACN }, EncodeSACN(1, 150, 0, data), 150},
+		{"artnet", false, func(r *DMXReceiver) func(string) error { return r.ListenArtNet }, EncodeArtDmx(1, 0, data), DefaultDMXConfig.ArtNetPriority},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := DefaultDMXConfig
+			config.Multicast = test.multicast
+			r := newTestReceiver(config)
+			t.Cleanup(func() { r.Close() })
+
+			sendUntilActive(t, r, test.listen(r), test.packet)
+
+			status := r.Status()
+			if status.Sources != 1 || status.Priority != test.priority {
+				t.Errorf("status = %+v, want 1 source at priority %d", status, test.priority)
+			}
+			if r.engine.Current() != "dmx" {
+				t.Errorf("engine shows %q, want dmx", r.engine.Current())
+			}
+			leds := make([]RGBW, ledCounts)
+			r.render(0, leds)
+			want := []RGBW{{R: 10, G: 20, B: 30, W: 40}, {R: 50, G: 60, B: 70, W: 80}, {}}
+			for i, led := range want {
+				if leds[i] != led {
+					t.Errorf("LED %d = %+v, want %+v", i, leds[i], led)
+				}
+			}
+		})
+	}
+}
+
+// channels returns a universe's data with the given first channels set.
+func channels(values ...byte) []byte {
+	data := make([]byte, dmxChannels)
+	copy(data, values)
+	return data
+}
+
+func TestDMXMerge(t *testing.T) {
+	type send struct {
+		source string
+		packet dmxPacket
+	}
+	tests := []struct {
+		name     string
+		sends    []send
+		priority int
+		want     RGBW
+	}{
+		{
+			name:     "one source",
+			sends:    []send{{"a", dmxPacket{universe: 1, priority: 100, data: channels(1, 2, 3, 4)}}},
+			priority: 100,
+			want:     RGBW{R: 1, G: 2, B: 3, W: 4},
+		},
+		{
+			name: "highest priority wins",
+			sends: []send{
+				{"a", dmxPacket{universe: 1, priority: 100, data: channels(200, 200, 200, 200)}},
+				{"b", dmxPacket{universe: 1, priority: 150, data: channels(1, 2, 3, 4)}},
+			},
+			priority: 150,
+			want:     RGBW{R: 1, G: 2, B: 3, W: 4},
+		},
+		{
+			name: "equal priorities take the highest channel",
+			sends: []send{
+				{"a", dmxPacket{universe: 1, priority: 100, data: channels(10, 0, 30, 0)}},
+				{"b", dmxPacket{universe: 1, priority: 100, data: channels(0, 20, 5, 40)}},
+			},
+			priority: 100,
+			want:     RGBW{R: 10, G: 20, B: 30, W: 40},
+		},
+		{
+			name: "terminated source drops out",
+			sends: []send{
+				{"a", dmxPacket{universe: 1, priority: 100, data: channels(1, 2, 3, 4)}},
+				{"b", dmxPacket{universe: 1, priority: 150, data: channels(9, 9, 9, 9)}},
+				{"b", dmxPacket{universe: 1, priority: 150, terminated: true}},
+			},
+			priority: 100,
+			want:     RGBW{R: 1, G: 2, B: 3, W: 4},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newTestReceiver(DefaultDMXConfig)
+			now := time.Now()
+			for _, send := range test.sends {
+				r.handlePacket(send.source, send.packet, now)
+			}
+
+			if status := r.Status(); !status.Active || status.Priority != test.priority {
+				t.Errorf("status = %+v, want active at priority %d", status, test.priority)
+			}
+			leds := make([]RGBW, ledCounts)
+			r.render(0, leds)
+			if leds[0] != test.want {
+				t.Errorf("LED 0 = %+v, want %+v", leds[0], test.want)
+			}
+		})
+	}
+}
+
+func TestDMXTimeout(t *testing.T) {
+	config := DefaultDMXConfig
+	config.Timeout = 1000
+	start := time.Now()
+
+	tests := []struct {
+		name    string
+		after   time.Duration
+		sources int
+	}{
+		{"before the timeout", 1000 * time.Millisecond, 2},
+		{"one source late", 1500 * time.Millisecond, 1},
+		{"both late", 2500 * time.Millisecond, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newTestReceiver(config)
+			r.handlePacket("a", dmxPacket{universe: 1, priority: 100, data: channels(1)}, start)
+			r.handlePacket("b", dmxPacket{universe: 1, priority: 100, data: channels(2)}, start.Add(time.Second))
+
+			r.expireSources(start.Add(test.after))
+			status := r.Status()
+			if status.Sources != test.sources || status.Active != (test.sources > 0) {
+				t.Errorf("status = %+v, want %d sources", status, test.sources)
+			}
+		})
+	}
+}
+
+func TestDMXFallback(t *testing.T) {
+	config := DefaultDMXConfig
+	config.Release = Transition{}
+	start := time.Now()
+	packet := dmxPacket{universe: 1, priority: 100, data: channels(1, 2, 3, 4)}
+
+	tests := []struct {
+		name string
+		stop func(r *DMXReceiver)
+	}{
+		{"terminated", func(r *DMXReceiver) {
+			r.handlePacket("a", dmxPacket{universe: 1, priority: 100, terminated: true}, start)
+		}},
+		{"timed out", func(r *DMXReceiver) {
+			r.expireSources(start.Add(time.Duration(config.Timeout+1) * time.Millisecond))
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newTestReceiver(config)
+			r.engine.Play(Animation{Name: "playlist", Render: func(time.Duration, []RGBW) {}}, Transition{})
+
+			r.handlePacket("a", packet, start)
+			if got := r.engine.Current(); got != "dmx" {
+				t.Fatalf("engine shows %q while DMX is sending, want dmx", got)
+			}
+
+			test.stop(r)
+			if got := r.engine.Current(); got != "playlist" {
+				t.Errorf("engine shows %q after DMX stopped, want playlist", got)
+			}
+			if r.Status().Active {
+				t.Error("receiver still active")
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package lights
+
+import (
+	"device_commander/metrics"
+	"sync"
+	"time"
+)
+
+const frameInterval = 20 * time.Millisecond
+
+type layer struct {
+	animation Animation
+	start     time.Time
+	leds      []RGBW
+}
+
+// Engine renders animations onto the panel at a fixed frame rate and
+// handles the transitions between them.
+type Engine struct {
+	panel *HexagonPanel
+
+	mu              sync.Mutex
+	current         *layer
+	previous        *layer
+	overrides       []*layer // Live inputs shown instead of the current animation, the latest on top
+	transition      Transition
+	transitionStart time.Time
+}
+
+func NewEngine(panel *HexagonPanel) *Engine {
+	return &Engine{panel: panel}
+}
+
+// Play switches to the given animation using the given transition. The new
+// animation starts from whatever is currently shown on the panel.
+func (e *Engine) Play(animation Animation, transition Transition) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	leds := make([]RGBW, len(e.panel.Leds))
+	if len(e.overrides) > 0 && e.current != nil {
+		copy(leds, e.current.leds)
+	} else {
+		copy(leds, e.panel.Leds)
+	}
+
+	if e.current != nil && transition.length() > 0 {
+		e.previous = e.current
+	} else {
+		e.previous = nil
+	}
+	e.current = &layer{animation: animation, start: now, leds: leds}
+	e.transition = transition
+	e.transitionStart = now
+
+	logger.Info("Playing animation", "animation", animation.Name, "transition", transition.Kind, "duration_ms", transition.Duration)
+}
+
+// Takeover shows the given animation straight away in place of whatever is
+// playing, until Release is called with its name. Animations started with
+// Play in the meantime carry on underneath. Each live input takes over under
+// its own name, the latest is shown and the others wait underneath it.
+func (e *Engine) Takeover(animation Animation) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.removeOverride(animation.Name)
+	leds := make([]RGBW, len(e.panel.Leds))
+	copy(leds, e.panel.Leds)
+	e.overrides = append(e.overrides, &layer{animation: animation, start: time.Now(), leds: leds})
+
+	logger.Info("Animation took over the panel", "animation", animation.Name)
+}
+
+// Release ends the named animation's takeover. Once no takeover is left the
+// panel goes back to the current animation using the given transition.
+func (e *Engine) Release(name string, transition Transition) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	released := e.removeOverride(name)
+	if released == nil {
+		return
+	}
+	if len(e.overrides) == 0 && e.current != nil && transition.length() > 0 {
+		e.previous = released
+		e.transition = transition
+		e.transitionStart = time.Now()
+	}
+	logger.Info("Animation released the panel", "animation", name)
+}
+
+// override returns the takeover being shown, if any. e.mu must be held.
+func (e *Engine) override() *layer {
+	if len(e.overrides) == 0 {
+		return nil
+	}
+	return e.overrides[len(e.overrides)-1]
+}
+
+// removeOverride takes the named takeover off the stack and returns it.
+// e.mu must be held.
+func (e *Engine) removeOverride(name string) *layer {
+	for i, override := range e.overrides {
+		if override.animation.Name == name {
+			e.overrides = append(e.overrides[:i], e.overrides[i+1:]...)
+			return override
+		}
+	}
+	return nil
+}
+
+// Current returns the name of the animation being shown.
+func (e *Engine) Current() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if override := e.override(); override != nil {
+		return override.animation.Name
+	}
+	if e.current == nil {
+		return ""
+	}
+	return e.current.animation.Name
+}
+
+// Frame returns a copy of the frame currently shown on the panel, before
+// colour correction and power limiting.
+func (e *Engine) Frame() []RGBW {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	leds := make([]RGBW, len(e.panel.Leds))
+	copy(leds, e.panel.Leds)
+	return leds
+}
+
+// Run renders frames until the program exits.
+func (e *Engine) Run() {
+	ticker := time.NewTicker(frameInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		e.renderFrame(now)
+	}
+}
+
+func (e *Engine) renderFrame(now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if override := e.override(); override != nil {
+		override.animation.Render(now.Sub(override.start), override.leds)
+		copy(e.panel.Leds, override.leds)
+		e.render()
+		return
+	}
+	if e.current == nil {
+		return
+	}
+
+	e.current.animation.Render(now.Sub(e.current.start), e.current.leds)
+
+	if e.previous != nil {
+		elapsed := now.Sub(e.transitionStart)
+		if elapsed >= e.transition.length() {
+			e.previous = nil
+		} else {
+			e.previous.animation.Render(now.Sub(e.previous.start), e.previous.leds)
+			progress := float64(elapsed) / float64(e.transition.length())
+			e.transition.Blend(e.previous.leds, e.current.leds, e.panel.Leds, progress)
+		}
+	}
+	if e.previous == nil {
+		copy(e.panel.Leds, e.current.leds)
+	}
+
+	e.render()
+}
+
+var framesRendered = metrics.NewCounter("device_commander_led_frames_total",
+	"Frames sent to the LEDs, rate() gives the frame rate.")
+
+// render sends the panel's LEDs out. e.mu must be held.
+func (e *Engine) render() {
+	if err := e.panel.Render(); err != nil {
+		logger.Error("Error rendering frame", "err", err)
+		return
+	}
+	framesRendered.Inc()
+}
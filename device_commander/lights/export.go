@@ -0,0 +1,115 @@
+package lights
+
+import (
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io"
+	"math"
+	"time"
+
+	"github.com/fogleman/gg"
+)
+
+// ExportOptions controls how an animation is rendered headlessly.
+type ExportOptions struct {
+	Duration time.Duration // How much of the animation to render
+	FPS      float64
+	CellSize float64 // Pixels per LUT cell
+	Columns  int     // Frames per row of a PNG sheet
+}
+
+var DefaultExportOptions = ExportOptions{
+	Duration: 10 * time.Second,
+	FPS:      20,
+	CellSize: 16,
+	Columns:  10,
+}
+
+func (o ExportOptions) Validate() error {
+	if o.Duration <= 0 || o.FPS <= 0 {
+		return fmt.Errorf("invalid export length: %s at %f fps", o.Duration, o.FPS)
+	}
+	if o.CellSize <= 0 || o.Columns <= 0 {
+		return fmt.Errorf("invalid export layout: cell size %f, %d columns", o.CellSize, o.Columns)
+	}
+	return nil
+}
+
+// RenderFrames renders an animation without a panel, starting from all LEDs
+// off. Frames are sampled at the given frame rate over the duration.
+func RenderFrames(animation Animation, duration time.Duration, fps float64) [][]RGBW {
+	interval := time.Duration(float64(time.Second) / fps)
+	leds := make([]RGBW, ledCounts)
+
+	var frames [][]RGBW
+	for t := time.Duration(0); t < duration; t += interval {
+		animation.Render(t, leds)
+		frame := make([]RGBW, len(leds))
+		copy(frame, leds)
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+// frameSize returns the size in pixels of a drawn frame. A LUT row is
+// HEX_HEIGHT_RATIO times as tall as a column is wide, as in SampleImage.
+func frameSize(cellSize float64) (int, int) {
+	return int(LUT_W * cellSize), int(math.Round(LUT_H * HEX_HEIGHT_RATIO * cellSize))
+}
+
+// DrawFrame draws every LED of a frame as a hexagon at its physical position
+// on a black background.
+func DrawFrame(frame []RGBW, cellSize float64) image.Image {
+	dc := gg.NewContext(frameSize(cellSize))
+	dc.SetRGB(0, 0, 0)
+	dc.Clear()
+
+	for i, pos := range LEDPositions() {
+		DrawHexagon(dc, pos.X*cellSize, pos.Y*HEX_HEIGHT_RATIO*cellSize, cellSize*0.55, frame[i])
+	}
+	return dc.Image()
+}
+
+// ExportGIF renders an animation to an animated GIF.
+func ExportGIF(w io.Writer, animation Animation, opts ExportOptions) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	// GIF delays are in hundredths of a second
+	delay := max(1, int(100/opts.FPS))
+	out := &gif.GIF{}
+	for _, frame := range RenderFrames(animation, opts.Duration, opts.FPS) {
+		img := DrawFrame(frame, opts.CellSize)
+		paletted := image.NewPaletted(img.Bounds(), palette.Plan9)
+		// No dithering, so the output is stable enough for golden images
+		draw.Draw(paletted, img.Bounds(), img, image.Point{}, draw.Src)
+		out.Image = append(out.Image, paletted)
+		out.Delay = append(out.Delay, delay)
+	}
+	return gif.EncodeAll(w, out)
+}
+
+// ExportPNGSheet renders an animation to a single PNG with the frames laid
+// out left to right, top to bottom.
+func ExportPNGSheet(w io.Writer, animation Animation, opts ExportOptions) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	frames := RenderFrames(animation, opts.Duration, opts.FPS)
+	frameW, frameH := frameSize(opts.CellSize)
+	columns := min(opts.Columns, len(frames))
+	rows := (len(frames) + columns - 1) / columns
+
+	sheet := image.NewRGBA(image.Rect(0, 0, columns*frameW, rows*frameH))
+	for i, frame := range frames {
+		x, y := (i%columns)*frameW, (i/columns)*frameH
+		draw.Draw(sheet, image.Rect(x, y, x+frameW, y+frameH), DrawFrame(frame, opts.CellSize), image.Point{}, draw.Src)
+	}
+	return png.Encode(w, sheet)
+}
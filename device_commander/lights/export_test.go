@@ -0,0 +1,110 @@
+package lights
+
+import (
+	"bytes"
+	"flag"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden images in testdata")
+
+// exportOptions render a whole pass of growingShrinkingHexagon small enough
+// to keep as a golden image.
+var exportOptions = ExportOptions{
+	Duration: 6500 * time.Millisecond,
+	FPS:      2,
+	CellSize: 8,
+	Columns:  5,
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	return rgba
+}
+
+func TestExportPNGSheetGolden(t *testing.T) {
+	animation, err := NewAnimation("growingShrinkingHexagon", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := ExportPNGSheet(&buf, animation, exportOptions); err != nil {
+		t.Fatal(err)
+	}
+
+	golden := filepath.Join("testdata", "growingShrinkingHexagon.png")
+	if *update {
+		if err := os.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(golden)
+	if err != nil {
+		t.Fatalf("%v, run the test with -update to create it", err)
+	}
+	defer f.Close()
+	want, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Bounds() != want.Bounds() {
+		t.Fatalf("sheet is %v, golden image is %v", got.Bounds(), want.Bounds())
+	}
+	if !bytes.Equal(toRGBA(got).Pix, toRGBA(want).Pix) {
+		t.Errorf("sheet differs from %s, check the change and run the test with -update if it is intended", golden)
+	}
+}
+
+func TestExportGIF(t *testing.T) {
+	animation, err := NewAnimation("growingShrinkingHexagon", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := ExportGIF(&buf, animation, exportOptions); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := gif.DecodeAll(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Image) != 13 {
+		t.Errorf("got %d frames, want 13", len(g.Image))
+	}
+	for i, delay := range g.Delay {
+		if delay != 50 {
+			t.Errorf("frame %d lasts %d hundredths, want 50", i, delay)
+		}
+	}
+	if size := g.Image[0].Bounds().Size(); size != image.Pt(88, 78) {
+		t.Errorf("frames are %v, want 88x78", size)
+	}
+}
+
+func TestExportOptionsValidate(t *testing.T) {
+	for _, opts := range []ExportOptions{
+		{Duration: 0, FPS: 20, CellSize: 16, Columns: 10},
+		{Duration: time.Second, FPS: 0, CellSize: 16, Columns: 10},
+		{Duration: time.Second, FPS: 20, CellSize: 0, Columns: 10},
+		{Duration: time.Second, FPS: 20, CellSize: 16, Columns: 0},
+	} {
+		if err := opts.Validate(); err == nil {
+			t.Errorf("%+v is valid", opts)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package lights
+
+import "math"
+
+// LEDPosition is the physical position of an LED on the panel, in LUT cell
+// units with the origin at the top-left corner of the LUT.
+type LEDPosition struct {
+	X, Y float64
+}
+
+// LEDPositions returns the position of every LED, indexed by LED number. The
+// positions are the centres of the LUT cells the LEDs occupy, which is also
+// where DrawToPanel samples a gg.Context.
+func LEDPositions() []LEDPosition {
+	positions := make([]LEDPosition, ledCounts)
+	for y := 0; y < LUT_H; y++ {
+		for x := 0; x < LUT_W; x++ {
+			if index := LUT[y*LUT_W+x]; index != __ {
+				positions[index] = LEDPosition{X: float64(x) + 0.5, Y: float64(y) + 0.5}
+			}
+		}
+	}
+	return positions
+}
+
+// axisProjection projects every LED onto one of the three hexagon axes
+// (0 = horizontal, 1 = 60 degrees, 2 = 120 degrees) and normalises the
+// result to 0..1 across the panel.
+func axisProjection(axis int) []float64 {
+	angle := float64(axis%3) * PI / 3
+	dx, dy := math.Cos(angle), math.Sin(angle)
+
+	positions := LEDPositions()
+	projection := make([]float64, len(positions))
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for i, p := range positions {
+		projection[i] = p.X*dx + p.Y*dy
+		lo = math.Min(lo, projection[i])
+		hi = math.Max(hi, projection[i])
+	}
+	for i := range projection {
+		projection[i] = (projection[i] - lo) / (hi - lo)
+	}
+	return projection
+}
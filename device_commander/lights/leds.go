@@ -15,21 +15,23 @@
 package lights
 
 import (
+	"device_commander/logging"
 	"fmt"
-	"log"
 	"time"
 
 	ws2811 "github.com/rpi-ws281x/rpi-ws281x-go"
 )
 
 const (
-	brightness = 255
+	brightness = 255 // Default master brightness, applied by the PowerLimiter
 	ledCounts  = 54
 	sleepTime  = 200
 	gpioPin    = 12
 	stripType  = ws2811.SK6812StripGRBW
 )
 
+var logger = logging.Logger(logging.Lights)
+
 type LEDDriver struct {
 	ws *ws2811.WS2811
 }
@@ -38,6 +40,8 @@ type HexagonPanel struct {
 	Height int
 	Leds   []RGBW
 	Driver *LEDDriver
+	Color  *ColorPipeline
+	Power  *PowerLimiter
 }
 
 func NewHexagonPanel() (*HexagonPanel, error) {
@@ -51,6 +55,8 @@ func NewHexagonPanel() (*HexagonPanel, error) {
 		Height: LUT_H,
 		Leds:   make([]RGBW, 54), // 54 is the highest LED index in the LUT + 1
 		Driver: driver,
+		Color:  NewColorPipeline(DefaultCalibration),
+		Power:  NewPowerLimiter(DefaultPowerConfig),
 	}, nil
 }
 
@@ -90,6 +96,28 @@ func (d *LEDDriver) Render(data []uint32) error {
 	return d.ws.Render()
 }
 
+// Render sends the current contents of Leds through the colour pipeline and
+// the power limiter to the strip.
+func (h *HexagonPanel) Render() error {
+	frame := make([]RGBW, len(h.Leds))
+	for i, led := range h.Leds {
+		frame[i] = h.Color.Apply(led)
+	}
+	h.Power.Limit(frame)
+
+	ledData := make([]uint32, len(frame))
+	for i, led := range frame {
+		ledData[i] = packLED(led)
+	}
+	return h.Driver.Render(ledData)
+}
+
+// packLED packs a colour in the 0xWWRRGGBB layout rpi_ws281x expects; the
+// library reorders the channels for the GRBW strip itself.
+func packLED(c RGBW) uint32 {
+	return uint32(c.W)<<24 | uint32(c.R)<<16 | uint32(c.G)<<8 | uint32(c.B)
+}
+
 // Initialize the LED panel
 func InitializeLEDs(panel *HexagonPanel) {
 	// Create a slice to hold LED data
@@ -102,7 +130,7 @@ func InitializeLEDs(panel *HexagonPanel) {
 
 	// Render the initial state
 	if err := panel.Driver.Render(ledData); err != nil {
-		log.Printf("Error rendering initial LED state: %v", err)
+		logger.Error("Error rendering initial LED state", "err", err)
 	}
 
 	// Short delay to ensure LEDs are initialized
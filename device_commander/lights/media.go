@@ -0,0 +1,242 @@
+package lights
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// MediaAnimation is the animation name playlists and the API use for images,
+// GIFs and raw frame files.
+const MediaAnimation = "media"
+
+// DefaultMediaDir is where the API looks for media files unless the config
+// names another directory.
+const DefaultMediaDir = "media"
+
+// MediaPath resolves a media file a client asked for inside the config's
+// media directory. Absolute paths and ".." segments are refused, so a
+// request can't open files anywhere else on disk.
+func (c *Config) MediaPath(file string) (string, error) {
+	dir := c.MediaDir
+	if dir == "" {
+		dir = DefaultMediaDir
+	}
+	if file == "" || filepath.IsAbs(file) || strings.HasPrefix(file, "/") || strings.HasPrefix(file, `\`) {
+		return "", fmt.Errorf("invalid media file %q: must be a path inside the media directory", file)
+	}
+	for _, segment := range strings.FieldsFunc(file, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if segment == ".." {
+			return "", fmt.Errorf("invalid media file %q: must not contain ..", file)
+		}
+	}
+	return filepath.Join(dir, filepath.FromSlash(file)), nil
+}
+
+// ledPitch is the distance between neighbouring LEDs in a row, in LUT
+// columns.
+const ledPitch = 2
+
+// sampleOffsets are the points averaged for each LED, a small hexagonal
+// lattice covering the LED's cell so thin features in the source image
+// still reach the nearest LED. They are in LUT column widths in both
+// directions; SampleImage scales them to the cell's height.
+var sampleOffsets = hexSampleOffsets(2, ledPitch/2.0)
+
+func hexSampleOffsets(rings int, radius float64) []LEDPosition {
+	offsets := []LEDPosition{{}}
+	for ring := 1; ring <= rings; ring++ {
+		r := radius * float64(ring) / float64(rings)
+		for corner := 0; corner < 6*ring; corner++ {
+			angle := 2 * PI * float64(corner) / float64(6*ring)
+			offsets = append(offsets, LEDPosition{X: r * math.Cos(angle), Y: r * math.Sin(angle)})
+		}
+	}
+	return offsets
+}
+
+// mediaFrame is one frame of media already resampled onto the LEDs.
+type mediaFrame struct {
+	leds  []RGBW
+	delay time.Duration
+}
+
+// LoadMedia loads a PNG or JPEG image, an animated GIF or a raw RGB frame
+// file (.rgb) as an animation. Raw files need the "width" and "height"
+// params and play at "fps" frames per second (default 25).
+func LoadMedia(path string, params map[string]float64) (Animation, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Animation{}, fmt.Errorf("error opening media: %w", err)
+	}
+	defer f.Close()
+
+	var frames []mediaFrame
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".gif":
+		frames, err = decodeGIF(f)
+	case ".rgb", ".raw":
+		frames, err = decodeRawFrames(f, int(param(params, "width", 0)), int(param(params, "height", 0)), param(params, "fps", 25))
+	default:
+		frames, err = decodeImage(f)
+	}
+	if err != nil {
+		return Animation{}, fmt.Errorf("error decoding media %s: %w", path, err)
+	}
+	if len(frames) == 0 {
+		return Animation{}, fmt.Errorf("media %s has no frames", path)
+	}
+
+	return newMediaAnimation(filepath.Base(path), frames), nil
+}
+
+func newMediaAnimation(name string, frames []mediaFrame) Animation {
+	var total time.Duration
+	for _, frame := range frames {
+		total += frame.delay
+	}
+
+	return Animation{
+		Name:     name,
+		Duration: total,
+		Render: func(t time.Duration, leds []RGBW) {
+			if total == 0 {
+				copy(leds, frames[0].leds)
+				return
+			}
+			t %= total
+			for _, frame := range frames {
+				if t < frame.delay {
+					copy(leds, frame.leds)
+					return
+				}
+				t -= frame.delay
+			}
+		},
+	}
+}
+
+func decodeImage(r io.Reader) ([]mediaFrame, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	return []mediaFrame{{leds: SampleImage(img)}}, nil
+}
+
+func decodeGIF(r io.Reader) ([]mediaFrame, error) {
+	g, err := gif.DecodeAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// GIF frames are patches over the previous frame, so composite them
+	// onto a canvas the size of the whole animation
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	canvas := image.NewRGBA(bounds)
+	frames := make([]mediaFrame, 0, len(g.Image))
+	for i, img := range g.Image {
+		var previous *image.RGBA
+		if i < len(g.Disposal) && g.Disposal[i] == gif.DisposalPrevious {
+			previous = image.NewRGBA(bounds)
+			draw.Draw(previous, bounds, canvas, image.Point{}, draw.Src)
+		}
+
+		draw.Draw(canvas, img.Bounds(), img, img.Bounds().Min, draw.Over)
+
+		// GIF delays are in hundredths of a second; browsers treat 0 as 100ms
+		delay := 100 * time.Millisecond
+		if i < len(g.Delay) && g.Delay[i] > 0 {
+			delay = time.Duration(g.Delay[i]) * 10 * time.Millisecond
+		}
+		frames = append(frames, mediaFrame{leds: SampleImage(canvas), delay: delay})
+
+		if i < len(g.Disposal) {
+			switch g.Disposal[i] {
+			case gif.DisposalBackground:
+				draw.Draw(canvas, img.Bounds(), image.Transparent, image.Point{}, draw.Src)
+			case gif.DisposalPrevious:
+				canvas = previous
+			}
+		}
+	}
+	return frames, nil
+}
+
+// decodeRawFrames reads back-to-back frames of 8-bit RGB pixels, as written
+// by e.g. ffmpeg -f rawvideo -pix_fmt rgb24.
+func decodeRawFrames(r io.Reader, width, height int, fps float64) ([]mediaFrame, error) {
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("raw frames need width and height, got %dx%d", width, height)
+	}
+	if fps <= 0 {
+		return nil, fmt.Errorf("invalid frame rate: %f", fps)
+	}
+
+	delay := time.Duration(float64(time.Second) / fps)
+	buffer := make([]byte, width*height*3)
+	var frames []mediaFrame
+	for {
+		if _, err := io.ReadFull(r, buffer); err == io.EOF {
+			break
+		} else if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("truncated frame %d", len(frames))
+		} else if err != nil {
+			return nil, err
+		}
+
+		img := image.NewRGBA(image.Rect(0, 0, width, height))
+		for p := 0; p < width*height; p++ {
+			img.Pix[p*4] = buffer[p*3]
+			img.Pix[p*4+1] = buffer[p*3+1]
+			img.Pix[p*4+2] = buffer[p*3+2]
+			img.Pix[p*4+3] = 0xff
+		}
+		frames = append(frames, mediaFrame{leds: SampleImage(img), delay: delay})
+	}
+	return frames, nil
+}
+
+// SampleImage resamples an image onto the LEDs. The image is scaled to fit
+// the panel, keeping its aspect ratio, and each LED takes the average colour
+// of the hexagonal area around its position. A LUT row is HEX_HEIGHT_RATIO
+// times as tall as a column is wide, which SampleImage allows for.
+func SampleImage(img image.Image) []RGBW {
+	bounds := img.Bounds()
+	height := LUT_H * HEX_HEIGHT_RATIO
+	scale := math.Min(float64(bounds.Dx())/LUT_W, float64(bounds.Dy())/height)
+	offsetX := float64(bounds.Min.X) + (float64(bounds.Dx())-LUT_W*scale)/2
+	offsetY := float64(bounds.Min.Y) + (float64(bounds.Dy())-height*scale)/2
+
+	leds := make([]RGBW, ledCounts)
+	for i, pos := range LEDPositions() {
+		var r, g, b, n float64
+		for _, offset := range sampleOffsets {
+			x := int(math.Floor(offsetX + (pos.X+offset.X)*scale))
+			y := int(math.Floor(offsetY + (pos.Y*HEX_HEIGHT_RATIO+offset.Y)*scale))
+			if !image.Pt(x, y).In(bounds) {
+				continue // Cells on the edge of the panel reach past the image
+			}
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			r += float64(c.R)
+			g += float64(c.G)
+			b += float64(c.B)
+			n++
+		}
+		if n == 0 {
+			continue
+		}
+		leds[i] = RGBW{R: clampChannel(r / n), G: clampChannel(g / n), B: clampChannel(b / n)}
+	}
+	return leds
+}
@@ -0,0 +1,41 @@
+package lights
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestMediaPath(t *testing.T) {
+	config := &Config{MediaDir: "/srv/media"}
+	tests := []struct {
+		file string
+		want string
+	}{
+		{"logo.png", "/srv/media/logo.png"},
+		{"loops/waves.gif", "/srv/media/loops/waves.gif"},
+		{"./loops/./waves.gif", "/srv/media/loops/waves.gif"},
+		{"", ""},
+		{"/etc/passwd", ""},
+		{"../playlists.json", ""},
+		{"loops/../../devices.json", ""},
+		{`..\devices.json`, ""},
+	}
+	for _, test := range tests {
+		got, err := config.MediaPath(test.file)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("%q resolved to %s, want an error", test.file, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.file, err)
+		} else if got != filepath.FromSlash(test.want) {
+			t.Errorf("%q resolved to %s, want %s", test.file, got, test.want)
+		}
+	}
+
+	if got, err := (&Config{}).MediaPath("logo.png"); err != nil || got != filepath.Join(DefaultMediaDir, "logo.png") {
+		t.Errorf("without a media directory got %s, %v", got, err)
+	}
+}
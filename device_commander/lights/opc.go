@@ -0,0 +1,205 @@
+package lights
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	opcCommandSetPixels = 0
+	opcHeaderLen        = 4
+)
+
+// OPCConfig configures the Open Pixel Control server.
+type OPCConfig struct {
+	Enabled bool       `json:"enabled"`
+	Address string     `json:"address"`
+	Channel int        `json:"channel"` // OPC channel of the panel; 0 broadcast is always accepted
+	Timeout int        `json:"timeout"` // Milliseconds without frames before handing back to the playlist
+	Release Transition `json:"release"`
+}
+
+var DefaultOPCConfig = OPCConfig{
+	Address: ":7890",
+	Channel: 1,
+	Timeout: 2000,
+	Release: Transition{Kind: TransitionCrossfade, Duration: 2000},
+}
+
+func (c OPCConfig) Validate() error {
+	if c.Channel < 0 || c.Channel > 255 {
+		return fmt.Errorf("invalid OPC channel: %d", c.Channel)
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("invalid OPC timeout: %d", c.Timeout)
+	}
+	return c.Release.Validate()
+}
+
+type OPCStatus struct {
+	Active  bool `json:"active"`
+	Clients int  `json:"clients"`
+	Frames  int  `json:"frames"`
+}
+
+// OPCServer receives Open Pixel Control frames over TCP and shows them on
+// the panel, taking over the engine while frames keep arriving.
+type OPCServer struct {
+	config OPCConfig
+	engine *Engine
+
+	mu        sync.Mutex
+	leds      []RGBW
+	lastFrame time.Time
+	active    bool
+	clients   int
+	frames    int
+}
+
+func NewOPCServer(engine *Engine, config OPCConfig) *OPCServer {
+	return &OPCServer{
+		config: config,
+		engine: engine,
+		leds:   make([]RGBW, ledCounts),
+	}
+}
+
+// Run accepts OPC clients until the listener fails.
+func (s *OPCServer) Run() error {
+	listener, err := net.Listen("tcp", s.config.Address)
+	if err != nil {
+		return fmt.Errorf("error listening for OPC: %w", err)
+	}
+	defer listener.Close()
+	logger.Info("Listening for OPC", "channel", s.config.Channel, "addr", listener.Addr())
+
+	go s.watchTimeout()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return fmt.Errorf("error accepting OPC client: %w", err)
+		}
+		go s.handleClient(conn)
+	}
+}
+
+func (s *OPCServer) handleClient(conn net.Conn) {
+	defer conn.Close()
+	logger.Info("OPC client connected", "addr", conn.RemoteAddr())
+
+	s.mu.Lock()
+	s.clients++
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.clients--
+		s.mu.Unlock()
+		logger.Info("OPC client disconnected", "addr", conn.RemoteAddr())
+	}()
+
+	reader := bufio.NewReader(conn)
+	header := make([]byte, opcHeaderLen)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if err != io.EOF {
+				logger.Warn("Error reading OPC header", "addr", conn.RemoteAddr(), "err", err)
+			}
+			return
+		}
+		channel, command := int(header[0]), header[1]
+		data := make([]byte, binary.BigEndian.Uint16(header[2:4]))
+		if _, err := io.ReadFull(reader, data); err != nil {
+			logger.Warn("Error reading OPC message", "addr", conn.RemoteAddr(), "err", err)
+			return
+		}
+
+		if command != opcCommandSetPixels || (channel != 0 && channel != s.config.Channel) {
+			logger.Debug("Ignoring OPC command", "command", command, "channel", channel)
+			continue
+		}
+		s.setPixels(data)
+	}
+}
+
+// setPixels shows a frame of 8-bit RGB triplets. OPC has no white channel,
+// the colour pipeline pulls the shared white into W.
+func (s *OPCServer) setPixels(data []byte) {
+	s.mu.Lock()
+	for i := range s.leds {
+		if i*3+2 >= len(data) {
+			s.leds[i] = RGBW{}
+			continue
+		}
+		s.leds[i] = RGBW{R: data[i*3], G: data[i*3+1], B: data[i*3+2]}
+	}
+	s.lastFrame = time.Now()
+	s.frames++
+	takeover := !s.active
+	s.active = true
+	s.mu.Unlock()
+
+	// The engine is called without mu held because rendering locks mu
+	if takeover {
+		s.engine.Takeover(Animation{Name: "opc", Render: s.render})
+	}
+}
+
+func (s *OPCServer) watchTimeout() {
+	timeout := time.Duration(s.config.Timeout) * time.Millisecond
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		s.mu.Lock()
+		release := s.active && now.Sub(s.lastFrame) > timeout
+		if release {
+			s.active = false
+		}
+		s.mu.Unlock()
+
+		if release {
+			logger.Info("OPC frames stopped, handing back to the playlist")
+			s.engine.Release("opc", s.config.Release)
+		}
+	}
+}
+
+func (s *OPCServer) render(t time.Duration, leds []RGBW) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	copy(leds, s.leds)
+}
+
+func (s *OPCServer) Status() OPCStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return OPCStatus{Active: s.active, Clients: s.clients, Frames: s.frames}
+}
+
+// LayoutPoint is one entry of an OPC layout file.
+type LayoutPoint struct {
+	Point [3]float64 `json:"point"`
+}
+
+// Layout returns the physical LED positions in the OPC layout format used by
+// gl_server, Processing and LX Studio, in LED order. The panel is centred on
+// the origin with y pointing up, one unit per LUT column and rows
+// HEX_HEIGHT_RATIO apart, the same shape SampleImage uses.
+func Layout() []LayoutPoint {
+	positions := LEDPositions()
+	layout := make([]LayoutPoint, len(positions))
+	for i, p := range positions {
+		layout[i] = LayoutPoint{Point: [3]float64{
+			p.X - LUT_W/2.0,
+			(LUT_H/2.0 - p.Y) * HEX_HEIGHT_RATIO,
+			0,
+		}}
+	}
+	return layout
+}
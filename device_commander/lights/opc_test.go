@@ -0,0 +1,136 @@
+package lights
+
+import (
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+// opcMessage builds an OPC message with the given channel, command and data.
+func opcMessage(channel, command byte, data ...byte) []byte {
+	return append([]byte{channel, command, byte(len(data) >> 8), byte(len(data))}, data...)
+}
+
+func TestOPCClient(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages [][]byte
+		frames   int
+		want     []RGBW
+	}{
+		{
+			name:     "set pixels",
+			messages: [][]byte{opcMessage(1, opcCommandSetPixels, 1, 2, 3, 4, 5, 6)},
+			frames:   1,
+			want:     []RGBW{{R: 1, G: 2, B: 3}, {R: 4, G: 5, B: 6}, {}},
+		},
+		{
+			name:     "broadcast channel",
+			messages: [][]byte{opcMessage(0, opcCommandSetPixels, 7, 8, 9)},
+			frames:   1,
+			want:     []RGBW{{R: 7, G: 8, B: 9}, {}},
+		},
+		{
+			name: "other channel and command ignored",
+			messages: [][]byte{
+				opcMessage(1, opcCommandSetPixels, 1, 2, 3),
+				opcMessage(2, opcCommandSetPixels, 9, 9, 9),
+				opcMessage(1, 255, 0, 1, 9, 9),
+			},
+			frames: 1,
+			want:   []RGBW{{R: 1, G: 2, B: 3}, {}},
+		},
+		{
+			name: "later frame replaces earlier",
+			messages: [][]byte{
+				opcMessage(1, opcCommandSetPixels, 1, 2, 3, 4, 5, 6),
+				opcMessage(1, opcCommandSetPixels, 10, 20, 30),
+			},
+			frames: 2,
+			want:   []RGBW{{R: 10, G: 20, B: 30}, {}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := NewOPCServer(NewEngine(&HexagonPanel{Leds: make([]RGBW, ledCounts)}), DefaultOPCConfig)
+			client, server := net.Pipe()
+			done := make(chan struct{})
+			go func() {
+				s.handleClient(server)
+				close(done)
+			}()
+
+			for _, message := range test.messages {
+				if _, err := client.Write(message); err != nil {
+					t.Fatal(err)
+				}
+			}
+			client.Close()
+			select {
+			case <-done:
+			case <-time.After(2 * time.Second):
+				t.Fatal("client handler did not return")
+			}
+
+			status := s.Status()
+			if status.Frames != test.frames || !status.Active || status.Clients != 0 {
+				t.Errorf("status = %+v, want %d frames, active and no clients", status, test.frames)
+			}
+			if got := s.engine.Current(); got != "opc" {
+				t.Errorf("engine shows %q, want opc", got)
+			}
+			leds := make([]RGBW, ledCounts)
+			s.render(0, leds)
+			for i, led := range test.want {
+				if leds[i] != led {
+					t.Errorf("LED %d = %+v, want %+v", i, leds[i], led)
+				}
+			}
+		})
+	}
+}
+
+func TestTakeoverPerSource(t *testing.T) {
+	engine := NewEngine(&HexagonPanel{Leds: make([]RGBW, ledCounts)})
+	engine.Play(Animation{Name: "playlist", Render: func(time.Duration, []RGBW) {}}, Transition{})
+	render := func(time.Duration, []RGBW) {}
+
+	steps := []struct {
+		action func()
+		want   string
+	}{
+		{func() { engine.Takeover(Animation{Name: "dmx", Render: render}) }, "dmx"},
+		{func() { engine.Takeover(Animation{Name: "opc", Render: render}) }, "opc"},
+		{func() { engine.Release("dmx", Transition{}) }, "opc"},
+		{func() { engine.Takeover(Animation{Name: "dmx", Render: render}) }, "dmx"},
+		{func() { engine.Release("dmx", Transition{}) }, "opc"},
+		{func() { engine.Release("dmx", Transition{}) }, "opc"},
+		{func() { engine.Release("opc", Transition{}) }, "playlist"},
+	}
+	for i, step := range steps {
+		step.action()
+		if got := engine.Current(); got != step.want {
+			t.Errorf("step %d: engine shows %q, want %q", i, got, step.want)
+		}
+	}
+}
+
+func TestLayout(t *testing.T) {
+	positions := LEDPositions()
+	layout := Layout()
+	if len(layout) != len(positions) {
+		t.Fatalf("layout has %d points, want %d", len(layout), len(positions))
+	}
+
+	// Distances between LEDs match the panel, rows HEX_HEIGHT_RATIO apart
+	for i := range positions {
+		dx := layout[i].Point[0] - layout[0].Point[0]
+		dy := layout[i].Point[1] - layout[0].Point[1]
+		wantX := positions[i].X - positions[0].X
+		wantY := (positions[0].Y - positions[i].Y) * HEX_HEIGHT_RATIO
+		if math.Abs(dx-wantX) > 1e-9 || math.Abs(dy-wantY) > 1e-9 {
+			t.Errorf("LED %d is %v, %v from LED 0, want %v, %v", i, dx, dy, wantX, wantY)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package lights
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// PlaylistEntry is one animation in a playlist.
+type PlaylistEntry struct {
+	Animation  string             `json:"animation"`
+	File       string             `json:"file,omitempty"` // Image, GIF or raw frames for the media animation
+	Params     map[string]float64 `json:"params"`
+	Duration   int                `json:"duration"` // Milliseconds, 0 uses the animation's own length
+	Transition Transition         `json:"transition"`
+}
+
+// Build creates the animation the entry describes.
+func (e PlaylistEntry) Build() (Animation, error) {
+	if e.Animation == MediaAnimation {
+		return LoadMedia(e.File, e.Params)
+	}
+	return NewAnimation(e.Animation, e.Params)
+}
+
+type Playlist struct {
+	Name    string          `json:"name"`
+	Entries []PlaylistEntry `json:"entries"`
+}
+
+// ScheduleEntry selects a playlist from a time of day ("HH:MM") until the
+// next entry starts.
+type ScheduleEntry struct {
+	Start    string `json:"start"`
+	Playlist string `json:"playlist"`
+}
+
+type Config struct {
+	Default     string          `json:"default"`
+	Playlists   []Playlist      `json:"playlists"`
+	Schedule    []ScheduleEntry `json:"schedule"`
+	Calibration *Calibration    `json:"calibration,omitempty"`
+	Power       *PowerConfig    `json:"power,omitempty"`
+	DMX         *DMXConfig      `json:"dmx,omitempty"`
+	OPC         *OPCConfig      `json:"opc,omitempty"`
+	MediaDir    string          `json:"media_dir,omitempty"` // Where the API may play media files from, DefaultMediaDir if empty
+}
+
+// DefaultConfig is the sequence the lamp played before playlists existed.
+func DefaultConfig() *Config {
+	transition := Transition{Kind: TransitionCrossfade, Duration: 1000}
+	return &Config{
+		Default: "default",
+		Playlists: []Playlist{{
+			Name: "default",
+			Entries: []PlaylistEntry{
+				{Animation: "rainbowHueShift", Transition: transition},
+				{Animation: "offLEDCascade", Transition: transition},
+				{Animation: "whiteLEDCascade", Transition: transition},
+			},
+		}},
+	}
+}
+
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading lights config: %w", err)
+	}
+
+	// Sections that are present are decoded over their defaults
+	power := DefaultPowerConfig
+	dmx := DefaultDMXConfig
+	opc := DefaultOPCConfig
+	config := Config{Power: &power, DMX: &dmx, OPC: &opc}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("error decoding lights config: %w", err)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func (c *Config) Validate() error {
+	if c.Calibration != nil {
+		if err := c.Calibration.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.Power != nil {
+		if err := c.Power.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.DMX != nil {
+		if err := c.DMX.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.OPC != nil {
+		if err := c.OPC.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, playlist := range c.Playlists {
+		if len(playlist.Entries) == 0 {
+			return fmt.Errorf("playlist %s has no entries", playlist.Name)
+		}
+		for _, entry := range playlist.Entries {
+			if entry.Animation == MediaAnimation {
+				if entry.File == "" {
+					return fmt.Errorf("playlist %s: media entry has no file", playlist.Name)
+				}
+			} else if _, err := NewAnimation(entry.Animation, entry.Params); err != nil {
+				return fmt.Errorf("playlist %s: %w", playlist.Name, err)
+			}
+			if err := entry.Transition.Validate(); err != nil {
+				return fmt.Errorf("playlist %s: %w", playlist.Name, err)
+			}
+		}
+	}
+	if c.Default != "" && c.playlist(c.Default) == nil {
+		return fmt.Errorf("unknown default playlist: %s", c.Default)
+	}
+	for _, entry := range c.Schedule {
+		if _, err := startMinute(entry.Start); err != nil {
+			return fmt.Errorf("invalid schedule start %q: %w", entry.Start, err)
+		}
+		if c.playlist(entry.Playlist) == nil {
+			return fmt.Errorf("schedule: unknown playlist: %s", entry.Playlist)
+		}
+	}
+	return nil
+}
+
+func (c *Config) playlist(name string) *Playlist {
+	for i := range c.Playlists {
+		if c.Playlists[i].Name == name {
+			return &c.Playlists[i]
+		}
+	}
+	return nil
+}
+
+// startMinute parses a schedule start into minutes since midnight. Hours
+// may be written with one digit, e.g. "9:30".
+func startMinute(start string) (int, error) {
+	t, err := time.Parse("15:04", start)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// scheduled returns the playlist the schedule selects at the given time,
+// falling back to the default playlist when there is no schedule.
+func (c *Config) scheduled(now time.Time) string {
+	if len(c.Schedule) == 0 {
+		return c.Default
+	}
+
+	type start struct {
+		minute   int
+		playlist string
+	}
+	starts := make([]start, 0, len(c.Schedule))
+	for _, entry := range c.Schedule {
+		if minute, err := startMinute(entry.Start); err == nil {
+			starts = append(starts, start{minute, entry.Playlist})
+		}
+	}
+	if len(starts) == 0 {
+		return c.Default
+	}
+	sort.SliceStable(starts, func(i, j int) bool {
+		return starts[i].minute < starts[j].minute
+	})
+
+	// Before the first entry of the day the last entry of yesterday still applies
+	current := starts[len(starts)-1].playlist
+	clock := now.Hour()*60 + now.Minute()
+	for _, s := range starts {
+		if s.minute <= clock {
+			current = s.playlist
+		}
+	}
+	return current
+}
+
+// Player plays playlists on an engine, either the one selected at runtime or
+// the one the schedule picks for the time of day.
+type Player struct {
+	engine *Engine
+	config *Config
+
+	mu       sync.Mutex
+	selected string // Manual selection, empty follows the schedule
+	playing  string
+	changed  chan struct{}
+}
+
+func NewPlayer(engine *Engine, config *Config) *Player {
+	return &Player{
+		engine:  engine,
+		config:  config,
+		changed: make(chan struct{}, 1),
+	}
+}
+
+// Select plays the named playlist until another one is selected. An empty
+// name hands control back to the schedule.
+func (p *Player) Select(name string) error {
+	if name != "" && p.config.playlist(name) == nil {
+		return fmt.Errorf("unknown playlist: %s", name)
+	}
+
+	p.mu.Lock()
+	p.selected = name
+	p.mu.Unlock()
+
+	select {
+	case p.changed <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Playing returns the name of the playlist being played.
+func (p *Player) Playing() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.playing
+}
+
+// Playlists returns the names of all configured playlists.
+func (p *Player) Playlists() []string {
+	names := make([]string, 0, len(p.config.Playlists))
+	for _, playlist := range p.config.Playlists {
+		names = append(names, playlist.Name)
+	}
+	return names
+}
+
+func (p *Player) active(now time.Time) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.selected != "" {
+		return p.selected
+	}
+	return p.config.scheduled(now)
+}
+
+// Run plays playlists forever. The engine's render loop must already be
+// running.
+func (p *Player) Run() {
+	scheduleTicker := time.NewTicker(time.Minute)
+	defer scheduleTicker.Stop()
+
+	for {
+		name := p.active(time.Now())
+		playlist := p.config.playlist(name)
+		if playlist == nil {
+			logger.Info("No playlist to play, waiting for a selection")
+			select {
+			case <-p.changed:
+			case <-scheduleTicker.C:
+			}
+			continue
+		}
+
+		p.mu.Lock()
+		p.playing = name
+		p.mu.Unlock()
+		logger.Info("Playing playlist", "playlist", name)
+
+		p.playPlaylist(playlist, scheduleTicker.C)
+	}
+}
+
+// playlistRetry is how long the player waits before trying a playlist again
+// when none of its entries could be played.
+const playlistRetry = time.Minute
+
+// playPlaylist loops over the playlist until the selection or the schedule
+// switches to another one.
+func (p *Player) playPlaylist(playlist *Playlist, scheduleTick <-chan time.Time) {
+	for {
+		played := false
+		for _, entry := range playlist.Entries {
+			animation, err := entry.Build()
+			if err != nil {
+				logger.Error("Error creating animation", "animation", entry.Animation, "err", err)
+				continue
+			}
+			played = true
+			p.engine.Play(animation, entry.Transition)
+
+			duration := time.Duration(entry.Duration) * time.Millisecond
+			if duration == 0 {
+				duration = animation.Duration
+			}
+			if !p.hold(playlist.Name, duration, scheduleTick) {
+				return
+			}
+		}
+
+		if !played {
+			logger.Error("No entry of the playlist could be played, retrying later", "playlist", playlist.Name, "retry", playlistRetry)
+			if !p.hold(playlist.Name, playlistRetry, scheduleTick) {
+				return
+			}
+		}
+	}
+}
+
+// hold waits for the duration of a playlist entry and reports whether the
+// playlist should carry on. A zero duration holds until something changes.
+func (p *Player) hold(name string, duration time.Duration, scheduleTick <-chan time.Time) bool {
+	var done <-chan time.Time
+	if duration > 0 {
+		timer := time.NewTimer(duration)
+		defer timer.Stop()
+		done = timer.C
+	}
+
+	for {
+		select {
+		case <-done:
+			return true
+		case <-p.changed:
+			return false
+		case now := <-scheduleTick:
+			if p.active(now) != name {
+				return false
+			}
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package lights
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduled(t *testing.T) {
+	config := &Config{
+		Default: "default",
+		Schedule: []ScheduleEntry{
+			{Start: "22:00", Playlist: "night"},
+			{Start: "9:30", Playlist: "morning"},
+			{Start: "10:00", Playlist: "day"},
+		},
+	}
+
+	tests := []struct {
+		clock string
+		want  string
+	}{
+		{"00:00", "night"},
+		{"09:29", "night"},
+		{"09:30", "morning"},
+		{"09:59", "morning"},
+		{"10:00", "day"},
+		{"21:59", "day"},
+		{"22:00", "night"},
+	}
+	for _, test := range tests {
+		now, err := time.Parse("15:04", test.clock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := config.scheduled(now); got != test.want {
+			t.Errorf("at %s got %s, want %s", test.clock, got, test.want)
+		}
+	}
+}
+
+func TestScheduledWithoutSchedule(t *testing.T) {
+	config := &Config{Default: "default"}
+	if got := config.scheduled(time.Now()); got != "default" {
+		t.Errorf("got %s, want the default playlist", got)
+	}
+}
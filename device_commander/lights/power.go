@@ -0,0 +1,129 @@
+package lights
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+// PowerConfig is the current model of the strip and the budget it has to
+// stay within on the Pi supply.
+type PowerConfig struct {
+	BudgetAmps       float64 `json:"budget_amps"`
+	ChannelMilliamps float64 `json:"channel_milliamps"` // One channel of one LED at full brightness
+	IdleMilliamps    float64 `json:"idle_milliamps"`    // One LED with all channels off
+}
+
+var DefaultPowerConfig = PowerConfig{
+	BudgetAmps:       2.0,
+	ChannelMilliamps: 18,
+	IdleMilliamps:    1,
+}
+
+func (c PowerConfig) Validate() error {
+	if c.BudgetAmps <= 0 {
+		return fmt.Errorf("invalid power budget: %fA", c.BudgetAmps)
+	}
+	if c.ChannelMilliamps <= 0 || c.IdleMilliamps < 0 {
+		return fmt.Errorf("invalid current model: %fmA per channel, %fmA idle", c.ChannelMilliamps, c.IdleMilliamps)
+	}
+	return nil
+}
+
+// EstimateCurrent returns the current in amps the strip draws to show leds.
+func (c PowerConfig) EstimateCurrent(leds []RGBW) float64 {
+	var channels float64
+	for _, led := range leds {
+		channels += float64(led.R) + float64(led.G) + float64(led.B) + float64(led.W)
+	}
+	milliamps := float64(len(leds))*c.IdleMilliamps + channels/255*c.ChannelMilliamps
+	return milliamps / 1000
+}
+
+type PowerStatus struct {
+	Brightness    uint8   `json:"brightness"`
+	BudgetAmps    float64 `json:"budget_amps"`
+	EstimatedAmps float64 `json:"estimated_amps"` // After brightness and limiting
+	RequestedAmps float64 `json:"requested_amps"` // Before limiting
+	Limited       bool    `json:"limited"`
+}
+
+// PowerLimiter applies the master brightness to every frame and scales it
+// down further when it would draw more than the budget.
+type PowerLimiter struct {
+	mu         sync.Mutex
+	config     PowerConfig
+	brightness uint8
+	status     PowerStatus
+}
+
+func NewPowerLimiter(config PowerConfig) *PowerLimiter {
+	return &PowerLimiter{
+		config:     config,
+		brightness: brightness,
+		status:     PowerStatus{Brightness: brightness, BudgetAmps: config.BudgetAmps},
+	}
+}
+
+func (l *PowerLimiter) SetBrightness(b uint8) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.brightness = b
+}
+
+func (l *PowerLimiter) Brightness() uint8 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.brightness
+}
+
+// Status returns the estimate for the last frame.
+func (l *PowerLimiter) Status() PowerStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.status
+}
+
+// Limit scales leds in place for the master brightness and the budget.
+func (l *PowerLimiter) Limit(leds []RGBW) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	scale := float64(l.brightness) / 255
+	scaleFrame(leds, scale)
+
+	requested := l.config.EstimateCurrent(leds)
+	estimated := requested
+	limited := false
+
+	// The idle current can't be scaled away, only the channel current
+	idle := float64(len(leds)) * l.config.IdleMilliamps / 1000
+	if requested > l.config.BudgetAmps && requested > idle {
+		factor := math.Max(0, (l.config.BudgetAmps-idle)/(requested-idle))
+		scaleFrame(leds, factor)
+		estimated = l.config.EstimateCurrent(leds)
+		limited = true
+	}
+
+	l.status = PowerStatus{
+		Brightness:    l.brightness,
+		BudgetAmps:    l.config.BudgetAmps,
+		EstimatedAmps: estimated,
+		RequestedAmps: requested,
+		Limited:       limited,
+	}
+}
+
+func scaleFrame(leds []RGBW, factor float64) {
+	if factor >= 1 {
+		return
+	}
+	for i, led := range leds {
+		leds[i] = RGBW{
+			R: uint8(float64(led.R) * factor),
+			G: uint8(float64(led.G) * factor),
+			B: uint8(float64(led.B) * factor),
+			W: uint8(float64(led.W) * factor),
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package lights
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+type TransitionKind string
+
+const (
+	TransitionCut              TransitionKind = "cut"
+	TransitionCrossfade        TransitionKind = "crossfade"
+	TransitionWipe             TransitionKind = "wipe"
+	TransitionFadeThroughBlack TransitionKind = "fadeThroughBlack"
+)
+
+// wipeEdge is the width of the soft edge of a wipe, as a fraction of the panel.
+const wipeEdge = 0.15
+
+// Transition describes how the engine moves from one animation to the next.
+type Transition struct {
+	Kind     TransitionKind `json:"kind"`
+	Duration int            `json:"duration"` // Milliseconds
+	Axis     int            `json:"axis"`     // Hexagon axis for wipes: 0, 1 or 2
+}
+
+func (tr Transition) Validate() error {
+	switch tr.Kind {
+	case "", TransitionCut, TransitionCrossfade, TransitionWipe, TransitionFadeThroughBlack:
+	default:
+		return fmt.Errorf("unknown transition kind: %s", tr.Kind)
+	}
+	if tr.Duration < 0 {
+		return fmt.Errorf("invalid transition duration: %d", tr.Duration)
+	}
+	if tr.Axis < 0 || tr.Axis > 2 {
+		return fmt.Errorf("invalid wipe axis: %d", tr.Axis)
+	}
+	return nil
+}
+
+func (tr Transition) length() time.Duration {
+	if tr.Kind == "" || tr.Kind == TransitionCut {
+		return 0
+	}
+	return time.Duration(tr.Duration) * time.Millisecond
+}
+
+// Blend writes the frame at the given progress (0..1) of the transition from
+// one frame to the other into out.
+func (tr Transition) Blend(from, to, out []RGBW, progress float64) {
+	progress = math.Max(0, math.Min(1, progress))
+
+	switch tr.Kind {
+	case TransitionCrossfade:
+		for i := range out {
+			out[i] = mix(from[i], to[i], progress)
+		}
+	case TransitionWipe:
+		projection := axisProjection(tr.Axis)
+		// Move the edge from just before the panel to just past it so both
+		// ends of the wipe are fully one frame or the other
+		edge := progress*(1+wipeEdge) - wipeEdge
+		for i := range out {
+			amount := math.Max(0, math.Min(1, (edge-projection[i])/wipeEdge+1))
+			out[i] = mix(from[i], to[i], amount)
+		}
+	case TransitionFadeThroughBlack:
+		for i := range out {
+			if progress < 0.5 {
+				out[i] = mix(from[i], RGBW{}, progress*2)
+			} else {
+				out[i] = mix(RGBW{}, to[i], progress*2-1)
+			}
+		}
+	default:
+		copy(out, to)
+	}
+}
+
+func mix(a, b RGBW, amount float64) RGBW {
+	lerp := func(x, y uint8) uint8 {
+		return uint8(math.Round(float64(x) + (float64(y)-float64(x))*amount))
+	}
+	return RGBW{R: lerp(a.R, b.R), G: lerp(a.G, b.G), B: lerp(a.B, b.B), W: lerp(a.W, b.W)}
+}
@@ -0,0 +1,184 @@
+package livemidi
+
+import (
+	"device_commander/lights"
+	"device_commander/show"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// Instrument turns incoming MIDI into motor speeds and the state of a light
+// animation, following a profile.
+type Instrument struct {
+	profile Profile
+	move    show.MotorFunc
+
+	mu         sync.Mutex
+	hue        float64
+	saturation float64
+	value      float64
+	white      float64
+	flash      float64 // Flash level at flashAt, 0-1
+	flashAt    time.Time
+	speeds     map[int]float64
+	held       map[int]uint8 // Note currently driving each motor
+}
+
+func NewInstrument(profile Profile, move show.MotorFunc) *Instrument {
+	return &Instrument{
+		profile:    profile,
+		move:       move,
+		saturation: 1,
+		value:      1,
+		speeds:     make(map[int]float64),
+		held:       make(map[int]uint8),
+	}
+}
+
+// Animation shows the colour set from MIDI with the white flash decaying on
+// top of it.
+func (in *Instrument) Animation() lights.Animation {
+	decay := time.Duration(in.profile.FlashDecay) * time.Millisecond
+	return lights.Animation{
+		Name: "midi",
+		Render: func(t time.Duration, leds []lights.RGBW) {
+			in.mu.Lock()
+			color := lights.HSV(in.hue, in.saturation, in.value)
+			white := in.white
+			if since := time.Since(in.flashAt); since < decay {
+				white = math.Max(white, 255*in.flash*(1-float64(since)/float64(decay)))
+			}
+			in.mu.Unlock()
+
+			color.W = uint8(math.Min(255, white))
+			for i := range leds {
+				leds[i] = color
+			}
+		},
+	}
+}
+
+// Handle applies one MIDI message.
+func (in *Instrument) Handle(msg midi.Message) {
+	var channel, key, velocity, controller, value uint8
+	switch {
+	case msg.GetNoteStart(&channel, &key, &velocity):
+		in.noteOn(channel, key, velocity)
+	case msg.GetNoteEnd(&channel, &key):
+		in.noteOff(channel, key)
+	case msg.GetControlChange(&channel, &controller, &value):
+		// All sound off and all notes off stop the motors too
+		if controller == midi.AllSoundOff || controller == midi.AllNotesOff {
+			in.Stop()
+			return
+		}
+		in.controlChange(channel, controller, value)
+	}
+}
+
+func (in *Instrument) noteOn(channel, key, velocity uint8) {
+	level := float64(velocity) / 127
+	for _, n := range in.profile.Notes {
+		if !matches(n.Channel, channel) || key < n.Low || key > n.High {
+			continue
+		}
+		switch n.Target {
+		case TargetMotor:
+			motorId := n.Motor + int(key-n.Low)
+			in.mu.Lock()
+			in.held[motorId] = key
+			in.mu.Unlock()
+			in.setSpeed(motorId, n.Speed*level)
+		case TargetFlash:
+			in.mu.Lock()
+			in.flash, in.flashAt = level, time.Now()
+			in.mu.Unlock()
+		case TargetColor:
+			in.mu.Lock()
+			in.hue = float64(key%12) * 30
+			in.mu.Unlock()
+		}
+	}
+}
+
+func (in *Instrument) noteOff(channel, key uint8) {
+	for _, n := range in.profile.Notes {
+		if n.Target != TargetMotor || n.Hold || !matches(n.Channel, channel) || key < n.Low || key > n.High {
+			continue
+		}
+		motorId := n.Motor + int(key-n.Low)
+		in.mu.Lock()
+		held, ok := in.held[motorId]
+		// A later note on the same motor keeps it running
+		if ok && held == key {
+			delete(in.held, motorId)
+		}
+		in.mu.Unlock()
+		if ok && held == key {
+			in.setSpeed(motorId, 0)
+		}
+	}
+}
+
+func (in *Instrument) controlChange(channel, controller, value uint8) {
+	for _, c := range in.profile.Controls {
+		if !matches(c.Channel, channel) || c.Controller != controller {
+			continue
+		}
+		scaled := c.Min + (c.Max-c.Min)*float64(value)/127
+
+		if c.Target == TargetMotor {
+			in.setSpeed(c.Motor, scaled)
+			continue
+		}
+		in.mu.Lock()
+		switch c.Target {
+		case TargetHue:
+			in.hue = scaled
+		case TargetSaturation:
+			in.saturation = scaled
+		case TargetValue:
+			in.value = scaled
+		case TargetWhite:
+			in.white = scaled
+		}
+		in.mu.Unlock()
+	}
+}
+
+// setSpeed moves a motor, rounded to a tenth so a sweeping controller
+// doesn't flood the serial line with near identical commands.
+func (in *Instrument) setSpeed(motorId int, speed float64) {
+	speed = math.Round(speed*10) / 10
+	in.mu.Lock()
+	last, ok := in.speeds[motorId]
+	in.speeds[motorId] = speed
+	in.mu.Unlock()
+	if ok && last == speed {
+		return
+	}
+	if err := in.move(motorId, speed); err != nil {
+		log.Printf("Error moving motor %d: %v", motorId, err)
+	}
+}
+
+// Stop stops every motor that was moved and forgets the held notes.
+func (in *Instrument) Stop() {
+	in.mu.Lock()
+	var running []int
+	for motorId, speed := range in.speeds {
+		if speed != 0 {
+			running = append(running, motorId)
+		}
+	}
+	in.held = make(map[int]uint8)
+	in.mu.Unlock()
+
+	for _, motorId := range running {
+		in.setSpeed(motorId, 0)
+	}
+}
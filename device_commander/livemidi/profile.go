@@ -0,0 +1,135 @@
+package livemidi
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Note targets
+const (
+	TargetMotor = "motor" // Note velocity or controller value sets a motor speed
+	TargetFlash = "flash" // Note velocity flashes the white channel
+	TargetColor = "color" // Note picks the hue from its pitch class
+)
+
+// numMotors is how many motors mappings can drive, one per panel.
+const numMotors = 7
+
+// Controller-only targets
+const (
+	TargetHue        = "hue"
+	TargetSaturation = "saturation"
+	TargetValue      = "value"
+	TargetWhite      = "white"
+)
+
+// NoteMapping maps a range of notes to a target.
+type NoteMapping struct {
+	Channel int     `json:"channel"` // 1-16, 0 matches every channel
+	Low     uint8   `json:"low"`
+	High    uint8   `json:"high"`
+	Target  string  `json:"target"`
+	Motor   int     `json:"motor"` // Motor of the lowest note; each note above it drives the next motor
+	Speed   float64 `json:"speed"` // Motor speed at velocity 127, negative turns the other way
+	Hold    bool    `json:"hold"`  // Keep the motor running after note off
+}
+
+// ControlMapping maps a control change to a target, scaling the 0-127
+// controller value into Min..Max.
+type ControlMapping struct {
+	Channel    int     `json:"channel"` // 1-16, 0 matches every channel
+	Controller uint8   `json:"controller"`
+	Target     string  `json:"target"`
+	Motor      int     `json:"motor"`
+	Min        float64 `json:"min"`
+	Max        float64 `json:"max"`
+}
+
+// Profile is a named set of mappings from incoming MIDI to the lamp.
+type Profile struct {
+	Name       string           `json:"name"`
+	Notes      []NoteMapping    `json:"notes"`
+	Controls   []ControlMapping `json:"controls"`
+	FlashDecay int              `json:"flashDecay"` // Milliseconds
+}
+
+// DefaultProfile plays the motors from the octave above middle C and picks
+// the colour an octave below on channel 1, flashes on any drum hit and puts
+// colour on the mod wheel, volume and brightness controllers.
+var DefaultProfile = Profile{
+	Name: "default",
+	Notes: []NoteMapping{
+		{Channel: 1, Low: 60, High: 66, Target: TargetMotor, Motor: 0, Speed: 10},
+		{Channel: 1, Low: 48, High: 59, Target: TargetColor},
+		{Channel: 10, Low: 0, High: 127, Target: TargetFlash},
+	},
+	Controls: []ControlMapping{
+		{Controller: 1, Target: TargetHue, Min: 0, Max: 360},
+		{Controller: 7, Target: TargetValue, Min: 0, Max: 1},
+		{Controller: 74, Target: TargetSaturation, Min: 0, Max: 1},
+		{Controller: 71, Target: TargetWhite, Min: 0, Max: 255},
+	},
+	FlashDecay: 200,
+}
+
+// LoadProfile reads a profile from a JSON file.
+func LoadProfile(path string) (Profile, error) {
+	var p Profile
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return p, fmt.Errorf("error reading MIDI profile: %w", err)
+	}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return p, fmt.Errorf("error parsing MIDI profile: %w", err)
+	}
+	return p, p.Validate()
+}
+
+func (p Profile) Validate() error {
+	for _, n := range p.Notes {
+		if n.Channel < 0 || n.Channel > 16 {
+			return fmt.Errorf("invalid channel %d for notes %d-%d", n.Channel, n.Low, n.High)
+		}
+		if n.Low > n.High || n.High > 127 {
+			return fmt.Errorf("invalid note range %d-%d", n.Low, n.High)
+		}
+		switch n.Target {
+		case TargetMotor:
+			// Each note above Low drives the next motor
+			if n.Motor < 0 || n.Motor+int(n.High-n.Low) >= numMotors {
+				return fmt.Errorf("notes %d-%d from motor %d go past the %d motors", n.Low, n.High, n.Motor, numMotors)
+			}
+		case TargetFlash, TargetColor:
+		default:
+			return fmt.Errorf("unknown note target %q", n.Target)
+		}
+	}
+	for _, c := range p.Controls {
+		if c.Channel < 0 || c.Channel > 16 {
+			return fmt.Errorf("invalid channel %d for controller %d", c.Channel, c.Controller)
+		}
+		if c.Controller > 127 {
+			return fmt.Errorf("invalid controller %d", c.Controller)
+		}
+		switch c.Target {
+		case TargetMotor:
+			if c.Motor < 0 || c.Motor >= numMotors {
+				return fmt.Errorf("invalid motor %d for controller %d", c.Motor, c.Controller)
+			}
+		case TargetHue, TargetSaturation, TargetValue, TargetWhite:
+		default:
+			return fmt.Errorf("unknown controller target %q", c.Target)
+		}
+	}
+	if p.FlashDecay < 0 {
+		return fmt.Errorf("invalid flash decay: %d", p.FlashDecay)
+	}
+	return nil
+}
+
+// matches reports whether a mapping channel (1-16, 0 for any) matches a
+// message channel (0-15).
+func matches(mappingChannel int, channel uint8) bool {
+	return mappingChannel == 0 || mappingChannel == int(channel)+1
+}
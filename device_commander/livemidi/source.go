@@ -0,0 +1,151 @@
+package livemidi
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/drivers"
+)
+
+// Listen feeds MIDI from a source to the instrument until the source fails
+// or ends. Sources are
+//
+//	tcp:<addr>   raw MIDI bytes from every client connecting to addr
+//	udp:<addr>   raw MIDI bytes, any number of messages per datagram
+//	port:<name>  a port of the linked gomidi driver
+//	<path>       a raw MIDI device such as /dev/snd/midiC1D0, or a FIFO
+//
+// The socket sources let anything that can write bytes play the lamp, e.g.
+// a bridge from a USB keyboard on another machine, or a test script.
+func (in *Instrument) Listen(source string) error {
+	kind, addr, found := strings.Cut(source, ":")
+	if !found {
+		return in.listenFile(source)
+	}
+	switch kind {
+	case "tcp":
+		return in.listenTCP(addr)
+	case "udp":
+		return in.listenUDP(addr)
+	case "port":
+		return in.listenPort(addr)
+	default:
+		return in.listenFile(source)
+	}
+}
+
+// newReader returns a reader that parses a byte stream, keeping running
+// status across reads.
+func (in *Instrument) newReader() *drivers.Reader {
+	return drivers.NewReader(drivers.ListenConfig{}, func(msg []byte, _ int32) {
+		in.Handle(midi.Message(msg))
+	})
+}
+
+// readStream parses a byte stream until it ends.
+func (in *Instrument) readStream(r io.Reader) error {
+	reader := in.newReader()
+	buf := make([]byte, 256)
+	for {
+		n, err := r.Read(buf)
+		reader.EachMessage(buf[:n], 0)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (in *Instrument) listenFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening MIDI source: %w", err)
+	}
+	defer f.Close()
+	log.Printf("Reading MIDI from %s", path)
+
+	defer in.Stop()
+	if err := in.readStream(f); err != nil {
+		return fmt.Errorf("error reading MIDI from %s: %w", path, err)
+	}
+	return nil
+}
+
+func (in *Instrument) listenTCP(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("error listening for MIDI: %w", err)
+	}
+	defer listener.Close()
+	log.Printf("Listening for MIDI on tcp %s", listener.Addr())
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return fmt.Errorf("error accepting MIDI client: %w", err)
+		}
+		go func() {
+			defer conn.Close()
+			log.Printf("MIDI client connected from %s", conn.RemoteAddr())
+			if err := in.readStream(conn); err != nil {
+				log.Printf("Error reading MIDI from %s: %v", conn.RemoteAddr(), err)
+			}
+			// A dropped client can't send its note offs
+			in.Stop()
+			log.Printf("MIDI client %s disconnected", conn.RemoteAddr())
+		}()
+	}
+}
+
+func (in *Instrument) listenUDP(addr string) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return fmt.Errorf("invalid MIDI address %q: %w", addr, err)
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return fmt.Errorf("error listening for MIDI: %w", err)
+	}
+	defer conn.Close()
+	log.Printf("Listening for MIDI on udp %s", conn.LocalAddr())
+
+	// Datagrams can be lost, so running status isn't carried between them
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return fmt.Errorf("error receiving MIDI: %w", err)
+		}
+		in.newReader().EachMessage(buf[:n], 0)
+	}
+}
+
+// listenPort listens on a port of the gomidi driver linked into the build.
+// None is linked by default as the drivers need cgo and system MIDI
+// libraries.
+func (in *Instrument) listenPort(name string) error {
+	if drivers.Get() == nil {
+		return fmt.Errorf("no MIDI driver in this build, use a tcp:, udp: or device source")
+	}
+	port, err := midi.FindInPort(name)
+	if err != nil {
+		return fmt.Errorf("MIDI port %q not found, available: %s", name, midi.GetInPorts())
+	}
+	stop, err := midi.ListenTo(port, func(msg midi.Message, _ int32) {
+		in.Handle(msg)
+	})
+	if err != nil {
+		return fmt.Errorf("error listening on MIDI port %q: %w", name, err)
+	}
+	defer stop()
+	log.Printf("Listening for MIDI on port %s", port)
+
+	select {}
+}
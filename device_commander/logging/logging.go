@@ -0,0 +1,276 @@
+// Package logging is the structured log of the commander. Every subsystem
+// logs through its own slog.Logger, tagged with a subsystem field and
+// filtered by a level that can be changed while running. Everything goes to
+// one rotating file, as text or JSON, and the latest entries are kept in
+// memory for the TUI.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// The subsystems with a level of their own. Plain log calls are logged as
+// Main.
+const (
+	Main   = "main"
+	Comms  = "comms"
+	BT     = "bt"
+	Motors = "motors"
+	Lights = "lights"
+	HTTP   = "http"
+)
+
+// Subsystems in the order they are listed.
+var Subsystems = []string{Main, Comms, BT, Motors, Lights, HTTP}
+
+var levels = func() map[string]*slog.LevelVar {
+	m := make(map[string]*slog.LevelVar)
+	for _, s := range Subsystems {
+		m[s] = new(slog.LevelVar)
+	}
+	return m
+}()
+
+// root is where the subsystems' records go once Setup has run. Until then
+// they go to slog's default handler, i.e. the standard log.
+var root atomic.Pointer[slog.Handler]
+
+// Options configure where and how the log is written.
+type Options struct {
+	Path     string
+	JSON     bool
+	MaxSize  int64 // Bytes before the file is rotated, 0 never rotates
+	Backups  int   // Rotated files kept, as Path.1 to Path.<Backups>
+	Retained int   // Entries kept in memory for Recent
+}
+
+var DefaultOptions = Options{
+	Path:     "debug.log",
+	MaxSize:  10 << 20,
+	Backups:  3,
+	Retained: 1000,
+}
+
+// Setup sends every subsystem's log, and the standard log, to the file in
+// opts. Closing the returned file stops writing it.
+func Setup(opts Options) (io.Closer, error) {
+	file, err := OpenRotating(opts.Path, opts.MaxSize, opts.Backups)
+	if err != nil {
+		return nil, err
+	}
+
+	handlerOpts := &slog.HandlerOptions{
+		AddSource: true,
+		// Subsystem levels filter before the handler sees anything
+		Level:       slog.LevelDebug,
+		ReplaceAttr: shortSource,
+	}
+	var out slog.Handler
+	if opts.JSON {
+		out = slog.NewJSONHandler(file, handlerOpts)
+	} else {
+		out = slog.NewTextHandler(file, handlerOpts)
+	}
+	retained.resize(opts.Retained)
+	var h slog.Handler = fanout{out, &memoryHandler{}}
+	root.Store(&h)
+
+	slog.SetDefault(Logger(Main))
+	return file, nil
+}
+
+// shortSource trims the source of an entry to the file's name, like
+// log.Lshortfile.
+func shortSource(groups []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.SourceKey && len(groups) == 0 {
+		if src, ok := a.Value.Any().(*slog.Source); ok {
+			// The standard log doesn't say where it was called from
+			if src.File == "" {
+				return slog.Attr{}
+			}
+			return slog.String(slog.SourceKey, fmt.Sprintf("%s:%d", filepath.Base(src.File), src.Line))
+		}
+	}
+	return a
+}
+
+// Logger returns the logger of a subsystem. Loggers can be created before
+// Setup, e.g. in package variables, and follow it once it has run.
+func Logger(subsystem string) *slog.Logger {
+	level, ok := levels[subsystem]
+	if !ok {
+		level = levels[Main]
+	}
+	return slog.New(&subsystemHandler{
+		level: level,
+		wrap:  []func(slog.Handler) slog.Handler{withAttrs([]slog.Attr{slog.String("subsystem", subsystem)})},
+	})
+}
+
+// StdLogger returns a standard logger that logs to a subsystem, for code
+// that wants a *log.Logger.
+func StdLogger(subsystem string, level slog.Level) *log.Logger {
+	return slog.NewLogLogger(Logger(subsystem).Handler(), level)
+}
+
+// SetLevel sets the lowest level a subsystem logs.
+func SetLevel(subsystem string, level slog.Level) error {
+	v, ok := levels[subsystem]
+	if !ok {
+		return fmt.Errorf("unknown subsystem: %s", subsystem)
+	}
+	v.Set(level)
+	return nil
+}
+
+// Level is the lowest level a subsystem logs.
+func Level(subsystem string) slog.Level {
+	if v, ok := levels[subsystem]; ok {
+		return v.Level()
+	}
+	return levels[Main].Level()
+}
+
+// Levels returns the level of every subsystem.
+func Levels() map[string]slog.Level {
+	m := make(map[string]slog.Level, len(levels))
+	for s, v := range levels {
+		m[s] = v.Level()
+	}
+	return m
+}
+
+// ParseLevel parses a level name such as debug or WARN.
+func ParseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(name))
+	return level, err
+}
+
+// Configure sets levels from a comma separated list. A bare level sets every
+// subsystem, subsystem=level sets one, e.g. "warn,comms=debug".
+func Configure(spec string) error {
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		subsystem, name, found := strings.Cut(item, "=")
+		if !found {
+			name = subsystem
+		}
+		level, err := ParseLevel(name)
+		if err != nil {
+			return err
+		}
+		if !found {
+			for _, v := range levels {
+				v.Set(level)
+			}
+			continue
+		}
+		if err := SetLevel(subsystem, level); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// subsystemHandler filters by its subsystem's level and hands records on to
+// the root handler, with the attributes and groups it was given applied.
+type subsystemHandler struct {
+	level *slog.LevelVar
+	wrap  []func(slog.Handler) slog.Handler
+}
+
+func withAttrs(attrs []slog.Attr) func(slog.Handler) slog.Handler {
+	return func(h slog.Handler) slog.Handler { return h.WithAttrs(attrs) }
+}
+
+func (h *subsystemHandler) target() slog.Handler {
+	var t slog.Handler
+	if p := root.Load(); p != nil {
+		t = *p
+	} else {
+		t = slog.Default().Handler()
+	}
+	for _, wrap := range h.wrap {
+		t = wrap(t)
+	}
+	return t
+}
+
+func (h *subsystemHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	if level < h.level.Level() {
+		return false
+	}
+	// Before Setup the standard log has its own idea of what to log
+	if root.Load() == nil {
+		return slog.Default().Handler().Enabled(ctx, level)
+	}
+	return true
+}
+
+func (h *subsystemHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.target().Handle(ctx, r)
+}
+
+func (h *subsystemHandler) with(wrap func(slog.Handler) slog.Handler) *subsystemHandler {
+	return &subsystemHandler{level: h.level, wrap: append(h.wrap[:len(h.wrap):len(h.wrap)], wrap)}
+}
+
+func (h *subsystemHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(withAttrs(attrs))
+}
+
+func (h *subsystemHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(t slog.Handler) slog.Handler { return t.WithGroup(name) })
+}
+
+// fanout hands every record to all of its handlers.
+type fanout []slog.Handler
+
+func (f fanout) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range f {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f fanout) Handle(ctx context.Context, r slog.Record) error {
+	var first error
+	for _, h := range f {
+		if !h.Enabled(ctx, r.Level) {
+			continue
+		}
+		if err := h.Handle(ctx, r.Clone()); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (f fanout) WithAttrs(attrs []slog.Attr) slog.Handler {
+	g := make(fanout, len(f))
+	for i, h := range f {
+		g[i] = h.WithAttrs(attrs)
+	}
+	return g
+}
+
+func (f fanout) WithGroup(name string) slog.Handler {
+	g := make(fanout, len(f))
+	for i, h := range f {
+		g[i] = h.WithGroup(name)
+	}
+	return g
+}
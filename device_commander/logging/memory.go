@@ -0,0 +1,151 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is a logged record as kept in memory.
+type Entry struct {
+	Time      time.Time  `json:"time"`
+	Level     slog.Level `json:"level"`
+	Subsystem string     `json:"subsystem"`
+	Message   string     `json:"message"`
+	Attrs     string     `json:"attrs,omitempty"` // As key=value pairs
+}
+
+func (e Entry) String() string {
+	s := fmt.Sprintf("%s %-5s %-6s %s", e.Time.Format("15:04:05.000"), e.Level, e.Subsystem, e.Message)
+	if e.Attrs != "" {
+		s += " " + e.Attrs
+	}
+	return s
+}
+
+// ring keeps the latest entries.
+type ring struct {
+	mu      sync.Mutex
+	entries []Entry
+	next    int
+	full    bool
+}
+
+var retained = &ring{entries: make([]Entry, DefaultOptions.Retained)}
+
+func (r *ring) resize(n int) {
+	if n <= 0 {
+		n = DefaultOptions.Retained
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if n == len(r.entries) {
+		return
+	}
+	old := r.latest(n)
+	r.entries = make([]Entry, n)
+	r.next = copy(r.entries, old) % n
+	r.full = len(old) == n
+}
+
+func (r *ring) add(e Entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries[r.next] = e
+	r.next = (r.next + 1) % len(r.entries)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// latest returns up to n entries, oldest first. r.mu must be held.
+func (r *ring) latest(n int) []Entry {
+	count := r.next
+	if r.full {
+		count = len(r.entries)
+	}
+	n = min(n, count)
+	out := make([]Entry, n)
+	for i := range out {
+		out[i] = r.entries[(r.next-n+i+len(r.entries))%len(r.entries)]
+	}
+	return out
+}
+
+// Recent returns up to the last n entries logged since Setup, oldest first.
+func Recent(n int) []Entry {
+	retained.mu.Lock()
+	defer retained.mu.Unlock()
+	return retained.latest(n)
+}
+
+// memoryHandler keeps records in the ring, with the subsystem taken out of
+// the attributes.
+type memoryHandler struct {
+	subsystem string
+	prefix    string // Group of the attributes that follow
+	attrs     []string
+}
+
+func (h *memoryHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return true
+}
+
+func (h *memoryHandler) Handle(ctx context.Context, r slog.Record) error {
+	attrs := append([]string(nil), h.attrs...)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = appendAttr(attrs, h.prefix, a)
+		return true
+	})
+	subsystem := h.subsystem
+	if subsystem == "" {
+		subsystem = Main
+	}
+	retained.add(Entry{
+		Time:      r.Time,
+		Level:     r.Level,
+		Subsystem: subsystem,
+		Message:   r.Message,
+		Attrs:     strings.Join(attrs, " "),
+	})
+	return nil
+}
+
+func appendAttr(attrs []string, prefix string, a slog.Attr) []string {
+	a.Value = a.Value.Resolve()
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, g := range a.Value.Group() {
+			attrs = appendAttr(attrs, prefix, g)
+		}
+		return attrs
+	}
+	if a.Equal(slog.Attr{}) {
+		return attrs
+	}
+	return append(attrs, fmt.Sprintf("%s%s=%v", prefix, a.Key, a.Value))
+}
+
+func (h *memoryHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	g := *h
+	g.attrs = append([]string(nil), h.attrs...)
+	for _, a := range attrs {
+		if a.Key == "subsystem" && h.prefix == "" {
+			g.subsystem = a.Value.String()
+			continue
+		}
+		g.attrs = appendAttr(g.attrs, h.prefix, a)
+	}
+	return &g
+}
+
+func (h *memoryHandler) WithGroup(name string) slog.Handler {
+	g := *h
+	g.prefix += name + "."
+	return &g
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"device_commander/logging"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+var (
+	httpLogger   = logging.Logger(logging.HTTP)
+	motorsLogger = logging.Logger(logging.Motors)
+)
+
+var (
+	showLogs bool
+	// The subsystem whose level the arrow keys change in the log view
+	logSubsystem int
+)
+
+var logLevelSteps = []slog.Level{slog.LevelDebug, slog.LevelInfo, slog.LevelWarn, slog.LevelError}
+
+var logLevelColors = map[slog.Level]tcell.Color{
+	slog.LevelDebug: tcell.ColorGray,
+	slog.LevelWarn:  tcell.ColorYellow,
+	slog.LevelError: tcell.ColorRed,
+}
+
+// selectLogSubsystem moves the log view's selection to the next or previous
+// subsystem.
+func selectLogSubsystem(next bool) {
+	n := len(logging.Subsystems)
+	if next {
+		logSubsystem = (logSubsystem + 1) % n
+	} else {
+		logSubsystem = (logSubsystem - 1 + n) % n
+	}
+}
+
+// changeLogLevel makes the selected subsystem log more or less.
+func changeLogLevel(more bool) {
+	subsystem := logging.Subsystems[logSubsystem]
+	current := logging.Level(subsystem)
+	step := 0
+	for i, level := range logLevelSteps {
+		if current >= level {
+			step = i
+		}
+	}
+	if more && step > 0 {
+		step--
+	} else if !more && step < len(logLevelSteps)-1 {
+		step++
+	}
+	logging.SetLevel(subsystem, logLevelSteps[step])
+}
+
+// drawLogs draws the subsystems' levels and the latest log entries over the
+// device pane, newest at the bottom.
+func drawLogs(x, y, width, rows int) {
+	if rows <= 0 {
+		return
+	}
+	for row := y; row < y+rows; row++ {
+		for column := x; column < x+width; column++ {
+			screen.SetContent(column, row, ' ', nil, tcell.StyleDefault)
+		}
+	}
+
+	column := x
+	drawText(column, y, width, "Log levels:")
+	column += len("Log levels:") + 1
+	for i, subsystem := range logging.Subsystems {
+		item := fmt.Sprintf("%s=%s", subsystem, logging.Level(subsystem))
+		if i == logSubsystem {
+			highlightText(column, y, x+width-column, item, tcell.ColorBlack, tcell.ColorGreen)
+		} else {
+			drawText(column, y, x+width-column, item)
+		}
+		column += len(item) + 1
+	}
+
+	for i, entry := range logging.Recent(rows - 1) {
+		style := tcell.StyleDefault
+		if color, ok := logLevelColors[entry.Level]; ok {
+			style = style.Foreground(color)
+		}
+		drawStyledText(x, y+1+i, width, entry.String(), style)
+	}
+}
+
+type loggingStatus struct {
+	Levels  map[string]slog.Level `json:"levels"`
+	Entries []logging.Entry       `json:"entries,omitempty"`
+}
+
+// handleLogging returns the subsystems' levels and the latest entries, 100
+// by default or as many as lines asks for. POST sets levels, e.g.
+// {"levels": {"comms": "debug"}}.
+func handleLogging(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		lines := 100
+		if s := r.URL.Query().Get("lines"); s != "" {
+			var err error
+			if lines, err = strconv.Atoi(s); err != nil || lines < 0 {
+				http.Error(w, fmt.Sprintf("Invalid lines: %s", s), http.StatusBadRequest)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(loggingStatus{Levels: logging.Levels(), Entries: logging.Recent(lines)})
+	case http.MethodPost:
+		var req loggingStatus
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+			return
+		}
+		levels := logging.Levels()
+		for subsystem := range req.Levels {
+			if _, ok := levels[subsystem]; !ok {
+				http.Error(w, fmt.Sprintf("Unknown subsystem: %s", subsystem), http.StatusBadRequest)
+				return
+			}
+		}
+		for subsystem, level := range req.Levels {
+			logging.SetLevel(subsystem, level)
+			httpLogger.Info("Set log level", "of", subsystem, "level", level)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(loggingStatus{Levels: logging.Levels()})
+	default:
+		http.Error(w, "Only GET and POST methods are allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -1,13 +1,21 @@
 package main
 
 import (
+	"bytes"
 	"device_commander/comms"
 	"device_commander/lights"
+	"device_commander/logging"
+	"device_commander/metrics"
 	"device_commander/motors"
+	"device_commander/recording"
+	"device_commander/show"
+	"device_commander/telemetry"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"sort"
@@ -32,13 +40,27 @@ var (
 	currentPortIndex int
 	screen           tcell.Screen
 	inputBuffer      string
-	logFile          *os.File
+	logFile          io.Closer
 	sendToAllBuffer  string
 	btBuffer         string
+	showLightPreview bool
 	panel            *lights.HexagonPanel
+	lightEngine      *lights.Engine
+	lightPlayer      *lights.Player
+	lightsConfig     *lights.Config
+	dmxReceiver      *lights.DMXReceiver
+	opcServer        *lights.OPCServer
 	currentPattern   *motors.Pattern
+	currentShow      *show.Player
+	showMutex        sync.Mutex
+	devices          []comms.DeviceInfo
+	devicesMutex     sync.Mutex
+	telemetryMonitor = telemetry.NewMonitor(telemetry.DefaultOptions)
+	serialRecorder   *recording.Recorder
 )
 
+const devicesPath = "devices.json"
+
 type DeviceStatus struct {
 	LastACK       time.Time
 	LastHeartbeat time.Time
@@ -46,107 +68,83 @@ type DeviceStatus struct {
 
 var deviceStatuses map[string]*DeviceStatus
 
-// Initialize LEDs in init() function
+var (
+	ackAge = metrics.NewGaugeFunc("device_commander_device_ack_age_seconds",
+		"Time since the device last acknowledged a handshake.", deviceAges(func(s *DeviceStatus) time.Time { return s.LastACK }), "device")
+	heartbeatAge = metrics.NewGaugeFunc("device_commander_device_heartbeat_age_seconds",
+		"Time since the device's last heartbeat.", deviceAges(func(s *DeviceStatus) time.Time { return s.LastHeartbeat }), "device")
+)
+
+// deviceAges reports how long ago each device last did something, for the
+// devices that have done it at all.
+func deviceAges(last func(*DeviceStatus) time.Time) func(emit func(float64, ...string)) {
+	return func(emit func(float64, ...string)) {
+		connectionsMutex.Lock()
+		defer connectionsMutex.Unlock()
+		for deviceID, status := range deviceStatuses {
+			if t := last(status); !t.IsZero() {
+				emit(time.Since(t).Seconds(), deviceID)
+			}
+		}
+	}
+}
+
 func init() {
+	connections = make([]*comms.SerialConnection, 0, 7)
+	deviceStatuses = make(map[string]*DeviceStatus)
+}
+
+// initLights sets up the LED panel and everything that drives it
+func initLights() {
 	var err error
 	panel, err = lights.NewHexagonPanel()
 	if err != nil {
 		log.Fatalf("Error creating hexagon panel: %v", err)
 	}
 	lights.InitializeLEDs(panel)
+	lightEngine = lights.NewEngine(panel)
+	lightsConfig, err = lights.LoadConfig("playlists.json")
+	if err != nil {
+		log.Printf("Using default light playlist: %v", err)
+		lightsConfig = lights.DefaultConfig()
+	}
+	if lightsConfig.Calibration != nil {
+		panel.Color = lights.NewColorPipeline(*lightsConfig.Calibration)
+	}
+	if lightsConfig.Power != nil {
+		panel.Power = lights.NewPowerLimiter(*lightsConfig.Power)
+	}
+	lightPlayer = lights.NewPlayer(lightEngine, lightsConfig)
+	if lightsConfig.DMX != nil && lightsConfig.DMX.Enabled {
+		dmxReceiver = lights.NewDMXReceiver(lightEngine, *lightsConfig.DMX)
+	}
+	if lightsConfig.OPC != nil && lightsConfig.OPC.Enabled {
+		opcServer = lights.NewOPCServer(lightEngine, *lightsConfig.OPC)
+	}
 	log.Println("Initialized LEDs")
-	connections = make([]*comms.SerialConnection, 0, 7)
-	deviceStatuses = make(map[string]*DeviceStatus)
 }
 
 func main() {
-	var err error
-	logFile, err = os.OpenFile("debug.log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
-	if err != nil {
-		fmt.Printf("Error opening log file: %v\n", err)
-		os.Exit(1)
+	// Subcommands run headless and exit without touching the devices
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
 	}
+
+	setupLogging()
 	defer logFile.Close()
-	log.SetOutput(logFile)
-	log.SetPrefix("main: ")
-	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
 
-	if err != nil {
-		log.Fatalf("Error creating hexagon panel: %v", err)
-	}
+	initLights()
 
 	comms.SetScreenUpdateChan(screenUpdateChan)
-
-	// Device info for all 7 devices
-	devicesJSON := `[
-	       {"device_serial_no": "0671FF383159503043112607", "device_id": "0", "serial_port": "/dev/ttyACM0"},
-	       {"device_serial_no": "066DFF515049657187212124", "device_id": "1", "serial_port": "/dev/ttyACM1"},
-	       {"device_serial_no": "066CFF383159503043112637", "device_id": "2", "serial_port": "/dev/ttyACM2"},
-	       {"device_serial_no": "066BFF515049657187203314", "device_id": "3", "serial_port": "/dev/ttyACM3"},
-	       {"device_serial_no": "066FFF383159503043114308", "device_id": "4", "serial_port": "/dev/ttyACM4"},
-	       {"device_serial_no": "066EFF383159503043112729", "device_id": "5", "serial_port": "/dev/ttyACM5"},
-	       {"device_serial_no": "066CFF383159503043112926", "device_id": "6", "serial_port": "/dev/ttyACM6"}
-	   ]`
-	// devicesJSON := `[
-	//        {"device_serial_no": "0671FF383159503043112607", "device_id": "0", "serial_port": "/dev/ttyACM7"},
-	//        {"device_serial_no": "066DFF515049657187212124", "device_id": "1", "serial_port": "/dev/ttyACM8"},
-	//        {"device_serial_no": "066CFF383159503043112637", "device_id": "2", "serial_port": "/dev/ttyACM9"},
-	//        {"device_serial_no": "066BFF515049657187203314", "device_id": "3", "serial_port": "/dev/ttyACM10"},
-	//        {"device_serial_no": "066FFF383159503043114308", "device_id": "4", "serial_port": "/dev/ttyACM11"},
-	//        {"device_serial_no": "066EFF383159503043112729", "device_id": "5", "serial_port": "/dev/ttyACM12"},
-	//        {"device_serial_no": "066CFF383159503043112926", "device_id": "6", "serial_port": "/dev/ttyACM13"}
-	//    ]`
-	var devices []comms.DeviceInfo
-	err = json.Unmarshal([]byte(devicesJSON), &devices)
-	if err != nil {
+	if err := setupSerialRecording(); err != nil {
 		log.Fatal(err)
 	}
-
-	// Initialize device statuses for all devices
-	for _, device := range devices {
-		deviceStatuses[device.DeviceID] = &DeviceStatus{}
-	}
-
-	var wg sync.WaitGroup
-	connectionChan := make(chan *comms.SerialConnection, len(devices))
-
-	for _, device := range devices {
-		wg.Add(1)
-		go func(dev comms.DeviceInfo) {
-			defer wg.Done()
-			conn, err := comms.OpenSerialPort(dev.SerialPort, connections, &connectionsMutex)
-			if err != nil {
-				log.Printf("Failed to open %s: %v", dev.SerialPort, err)
-				partialConn := &comms.SerialConnection{
-					DeviceID: dev.DeviceID,
-					PortName: dev.SerialPort,
-				}
-				go comms.AttemptReconnection(partialConn, connections, &connectionsMutex)
-				return
-			}
-			conn.DeviceID = dev.DeviceID
-			conn.PortName = dev.SerialPort
-			connectionChan <- conn
-
-			// Start a goroutine to handle updates for this device
-			go handleDeviceUpdates(conn)
-		}(device)
-	}
-
-	go func() {
-		wg.Wait()
-		close(connectionChan)
-	}()
-
-	for conn := range connectionChan {
-		connections = append(connections, conn)
-		go comms.PeriodicHandshake(conn, connections, &connectionsMutex)
-	}
-
-	if len(connections) == 0 {
-		log.Fatal("No serial connections were successfully opened")
+	if serialRecorder != nil {
+		defer serialRecorder.Close()
 	}
+	connectDevices()
 
+	var err error
 	screen, err = tcell.NewScreen()
 	if err != nil {
 		log.Fatal(err)
@@ -160,14 +158,31 @@ func main() {
 	go screenUpdateLoop()
 
 	// go comms.RunBluetooth()
-	// go lights.Run(panel)
+	go lightEngine.Run()
+	if dmxReceiver != nil {
+		go func() {
+			if err := dmxReceiver.Run(); err != nil {
+				log.Printf("DMX receiver stopped: %v", err)
+			}
+		}()
+	}
+	if opcServer != nil {
+		go func() {
+			if err := opcServer.Run(); err != nil {
+				log.Printf("OPC server stopped: %v", err)
+			}
+		}()
+	}
+	go lightPlayer.Run()
 
 	// Start the screen refresh ticker
 	screenRefreshTicker = time.NewTicker(100 * time.Millisecond)
 	defer screenRefreshTicker.Stop()
 
-	// Start the HTTP server
-	// go startHTTPServer()
+	// Start the HTTP server when HTTP_ADDR gives it an address, e.g. :8080
+	if addr := os.Getenv("HTTP_ADDR"); addr != "" {
+		go startHTTPServer(addr)
+	}
 
 	drawScreen()
 	for {
@@ -180,15 +195,51 @@ func main() {
 				screen.Sync()
 				drawScreen()
 			case *tcell.EventKey:
+				if ev.Key() == tcell.KeyF3 {
+					toggleTuningForm()
+					drawScreen()
+					continue
+				}
+				if tuning != nil {
+					tuning.handleKey(ev)
+					drawScreen()
+					continue
+				}
 				switch ev.Key() {
 				case tcell.KeyEscape:
 					return
+				case tcell.KeyF2:
+					showLightPreview = !showLightPreview
+				case tcell.KeyF4:
+					showGraphs = !showGraphs
+				case tcell.KeyF5:
+					showLogs = !showLogs
+				case tcell.KeyLeft, tcell.KeyRight:
+					if showLogs {
+						selectLogSubsystem(ev.Key() == tcell.KeyRight)
+					}
+				case tcell.KeyUp, tcell.KeyDown:
+					if showLogs {
+						changeLogLevel(ev.Key() == tcell.KeyDown)
+					}
+				case tcell.KeyPgUp:
+					changeGraphWindow(true)
+				case tcell.KeyPgDn:
+					changeGraphWindow(false)
 				case tcell.KeyEnter:
 					if currentPortIndex == len(connections) {
-						if sendToAllBuffer == "PAT" {
+						switch sendToAllBuffer {
+						case "PAT":
 							go playCurrentPattern()
+						case "HOME":
+							go homeAll()
+						case "ZERO":
+							go calibrateAll()
+						case "TEL":
+							go toggleTelemetry()
+						default:
+							comms.SendCommandToAll(sendToAllBuffer, &connectionsMutex, connections)
 						}
-						comms.SendCommandToAll(sendToAllBuffer, &connectionsMutex, connections)
 						sendToAllBuffer = ""
 					} else if currentPortIndex == len(connections)+1 {
 						// Send BT buffer to all devices
@@ -238,6 +289,109 @@ func main() {
 	}
 }
 
+// setupLogging sends the log to debug.log, rotated as it grows. LOG_LEVEL
+// sets the subsystems' levels, e.g. "info,comms=debug", and LOG_JSON writes
+// JSON lines. DEBUG_SERIAL and DEBUG_LIGHTS still turn on debug logging of
+// the serial links and the lights.
+func setupLogging() {
+	opts := logging.DefaultOptions
+	opts.JSON = os.Getenv("LOG_JSON") != ""
+	var err error
+	logFile, err = logging.Setup(opts)
+	if err != nil {
+		fmt.Printf("Error opening log file: %v\n", err)
+		os.Exit(1)
+	}
+	if os.Getenv("DEBUG_SERIAL") != "" {
+		logging.SetLevel(logging.Comms, slog.LevelDebug)
+	}
+	if os.Getenv("DEBUG_LIGHTS") != "" {
+		logging.SetLevel(logging.Lights, slog.LevelDebug)
+	}
+	if err := logging.Configure(os.Getenv("LOG_LEVEL")); err != nil {
+		log.Printf("Invalid LOG_LEVEL: %v", err)
+	}
+}
+
+// setupSerialRecording records every device link to the file in
+// RECORD_SERIAL, or replays the recording in REPLAY_SERIAL in place of the
+// boards.
+func setupSerialRecording() error {
+	if path := os.Getenv("REPLAY_SERIAL"); path != "" {
+		rec, err := recording.Load(path)
+		if err != nil {
+			return err
+		}
+		log.Printf("Replaying %s of serial traffic from %s", rec.Length(), path)
+		comms.OpenPort = rec.Opener(recording.ReplayOptions{Speed: 1, Lockstep: true, Hold: true})
+		return nil
+	}
+	if path := os.Getenv("RECORD_SERIAL"); path != "" {
+		var err error
+		if serialRecorder, err = recording.Create(path); err != nil {
+			return err
+		}
+		log.Printf("Recording serial traffic to %s", path)
+		comms.OpenPort = serialRecorder.Opener(comms.OpenPort)
+	}
+	return nil
+}
+
+// connectDevices opens the serial ports of all devices and starts reading
+// from them
+func connectDevices() {
+	var err error
+	devices, err = comms.LoadDevices(devicesPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Initialize device statuses for all devices
+	for _, device := range devices {
+		deviceStatuses[device.DeviceID] = &DeviceStatus{}
+	}
+
+	var wg sync.WaitGroup
+	connectionChan := make(chan *comms.SerialConnection, len(devices))
+
+	for _, device := range devices {
+		wg.Add(1)
+		go func(dev comms.DeviceInfo) {
+			defer wg.Done()
+			conn, err := comms.OpenSerialPort(dev.SerialPort, connections, &connectionsMutex)
+			if err != nil {
+				log.Printf("Failed to open %s: %v", dev.SerialPort, err)
+				partialConn := &comms.SerialConnection{
+					DeviceID: dev.DeviceID,
+					PortName: dev.SerialPort,
+				}
+				go comms.AttemptReconnection(partialConn, connections, &connectionsMutex)
+				return
+			}
+			conn.DeviceID = dev.DeviceID
+			conn.PortName = dev.SerialPort
+			connectionChan <- conn
+
+			// Start a goroutine to handle updates for this device
+			go handleDeviceUpdates(conn)
+		}(device)
+	}
+
+	go func() {
+		wg.Wait()
+		close(connectionChan)
+	}()
+
+	for conn := range connectionChan {
+		connections = append(connections, conn)
+		go comms.PeriodicHandshake(conn, connections, &connectionsMutex)
+	}
+
+	if len(connections) == 0 {
+		log.Fatal("No serial connections were successfully opened")
+	}
+}
+
 func drawScreen() {
 	if screen == nil {
 		log.Println("Screen is nil in drawScreen()")
@@ -287,9 +441,18 @@ func drawScreen() {
 			drawText(0, y+1, width, statusText)
 		}
 
+		outputHeight := deviceHeight - 2 // Reserve two lines for header and status
+		if showGraphs {
+			graphWidth := width
+			if showLightPreview {
+				graphWidth -= lightPreviewWidth
+			}
+			drawDeviceGraphs(0, y+2, graphWidth, min(outputHeight, availableHeight-y-2), conn.DeviceID)
+			continue
+		}
+
 		// Draw device output
 		lines := strings.Split(conn.Output, "\n")
-		outputHeight := deviceHeight - 2 // Reserve two lines for header and status
 		startLine := max(0, len(lines)-outputHeight)
 		for j := 0; j < outputHeight && startLine+j < len(lines); j++ {
 			if y+j+2 >= availableHeight {
@@ -300,6 +463,18 @@ func drawScreen() {
 		}
 	}
 
+	if showLogs {
+		logWidth := width
+		if showLightPreview {
+			logWidth -= lightPreviewWidth
+		}
+		drawLogs(0, 0, logWidth, availableHeight)
+	}
+
+	if showLightPreview {
+		drawLightPreview(width-lightPreviewWidth, 0)
+	}
+
 	// Draw input line
 	inputLine := fmt.Sprintf("%d-> %s", currentPortIndex, inputBuffer)
 	drawText(0, height-4, width, inputLine)
@@ -321,12 +496,69 @@ func drawScreen() {
 	}
 
 	// Draw debug info
-	debugInfo := fmt.Sprintf("Connected Devices: %d", len(sortedConnections))
+	power := panel.Power.Status()
+	debugInfo := fmt.Sprintf("Connected Devices: %d | LEDs: %.2fA/%.2fA brightness %d",
+		len(sortedConnections), power.EstimatedAmps, power.BudgetAmps, power.Brightness)
+	if power.Limited {
+		debugInfo += " (limited)"
+	}
+	debugInfo += " | F2: LED preview | F3: tuning | F4: graphs | F5: log"
+	if showGraphs {
+		debugInfo += fmt.Sprintf(" (%s, PgUp/PgDn)", graphWindow)
+	}
+	if showLogs {
+		debugInfo += " (Left/Right subsystem, Up/Down level)"
+	}
 	drawText(0, height-1, width, debugInfo)
 
+	if tuning != nil {
+		tuning.draw(width, height)
+	}
+
 	screen.Show()
 }
 
+const (
+	lightPreviewWidth  = 2*lights.LUT_W + 4
+	lightPreviewHeight = lights.LUT_H + 2
+)
+
+// drawLightPreview draws the LEDs as coloured hex cells at their positions
+// on the panel, with the top-left corner of the pane at x, y.
+func drawLightPreview(x, y int) {
+	frame := lightEngine.Frame()
+	border := tcell.StyleDefault.Foreground(tcell.ColorGray)
+
+	for row := 0; row < lightPreviewHeight; row++ {
+		for col := 0; col < lightPreviewWidth; col++ {
+			screen.SetContent(x+col, y+row, ' ', nil, tcell.StyleDefault)
+		}
+	}
+	drawStyledText(x, y, lightPreviewWidth, fmt.Sprintf("LEDs: %s", lightEngine.Current()), border)
+
+	// LUT cells are two characters wide; each LED is drawn three wide so
+	// LEDs in offset rows sit halfway between their neighbours
+	for i, pos := range lights.LEDPositions() {
+		r, g, b, _ := frame[i].RGBA()
+		style := tcell.StyleDefault.Foreground(tcell.NewRGBColor(int32(r>>8), int32(g>>8), int32(b>>8)))
+		cx := x + 1 + int(pos.X)*2
+		cy := y + 1 + int(pos.Y)
+		for dx := 0; dx < 3; dx++ {
+			screen.SetContent(cx+dx, cy, '█', nil, style)
+		}
+	}
+	drawStyledText(x, y+lightPreviewHeight-1, lightPreviewWidth, "F2: hide", border)
+}
+
+func drawStyledText(x, y, maxWidth int, text string, style tcell.Style) {
+	for i, r := range []rune(text) {
+		if i >= maxWidth {
+			break
+		}
+		screen.SetContent(x+i, y, r, nil, style)
+	}
+}
+
 func drawText(x, y, maxWidth int, text string) {
 	for i, r := range []rune(text) {
 		if i >= maxWidth {
@@ -409,10 +641,14 @@ func processDeviceUpdate(update ScreenUpdate) {
 		safeUpdateDeviceStatus(update.DeviceID, true, false)
 		return
 	}
-	if trimmedOutput == "HEARTBEAT" {
+	// The serial reader reports heartbeats as HB
+	if trimmedOutput == "HEARTBEAT" || trimmedOutput == "HB" {
 		safeUpdateDeviceStatus(update.DeviceID, false, true)
 		return
 	}
+	if strings.Contains(trimmedOutput, "K_RUNNING") || trimmedOutput == comms.Reconnected {
+		go restoreDevice(update.DeviceID)
+	}
 
 	for _, conn := range connections {
 		if conn.DeviceID == update.DeviceID {
@@ -424,10 +660,28 @@ func processDeviceUpdate(update ScreenUpdate) {
 	}
 }
 
-func startHTTPServer() {
+func startHTTPServer(addr string) {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/pattern", handlePattern)
+	mux.HandleFunc("/pattern.mid", handlePatternMIDI)
+	mux.HandleFunc("/pattern/generate", handlePatternGenerate)
+	mux.HandleFunc("/pattern/simulate", handlePatternSimulate)
+	mux.HandleFunc("/motors/home", handleMotorsHome)
+	mux.HandleFunc("/motors/calibrate", handleMotorsCalibrate)
+	mux.HandleFunc("/motors/params", handleMotorParams)
+	mux.HandleFunc("/motors/tune", handleMotorTune)
+	mux.HandleFunc("/telemetry", handleTelemetry)
+	mux.HandleFunc("/telemetry/stream", handleTelemetryStream)
+	mux.HandleFunc("/logging", handleLogging)
+	mux.Handle("/metrics", metrics.Handler())
 	mux.HandleFunc("/serial-numbers", handleSerialNumbers) // Add this line
+	mux.HandleFunc("/lights/animation", handleLightAnimation)
+	mux.HandleFunc("/lights/playlist", handleLightPlaylist)
+	mux.HandleFunc("/lights/status", handleLightStatus)
+	mux.HandleFunc("/lights/brightness", handleLightBrightness)
+	mux.HandleFunc("/lights/layout.json", handleLightLayout)
+	mux.HandleFunc("/show", handleShow)
+	mux.HandleFunc("/show/control", handleShowControl)
 
 	// Create a new CORS handler
 	c := cors.New(cors.Options{
@@ -439,16 +693,17 @@ func startHTTPServer() {
 	// Wrap your mux with the CORS handler
 	handler := c.Handler(mux)
 
-	log.Println("Starting HTTP server on :8080")
-	if err := http.ListenAndServe(":8080", handler); err != nil {
-		log.Fatalf("Failed to start HTTP server: %v", err)
+	httpLogger.Info("Starting HTTP server", "addr", addr)
+	if err := http.ListenAndServe(addr, handler); err != nil {
+		// Keep the TUI running without the API rather than exit under it
+		httpLogger.Error("HTTP server stopped", "addr", addr, "err", err)
 	}
 }
 
 func handlePattern(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Received pattern request from %s", r.RemoteAddr)
+	httpLogger.Info("Received pattern request", "remote", r.RemoteAddr)
 	if r.Method != http.MethodPost {
-		log.Printf("Invalid method for pattern request: %s", r.Method)
+		httpLogger.Warn("Invalid method for pattern request", "method", r.Method)
 		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
 		return
 	}
@@ -456,39 +711,468 @@ func handlePattern(w http.ResponseWriter, r *http.Request) {
 	// Read the raw body
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("Error reading pattern request body: %v", err)
+		httpLogger.Error("Error reading pattern request body", "err", err)
 		http.Error(w, "Error reading request body", http.StatusInternalServerError)
 		return
 	}
 
 	// Log the received JSON
-	log.Printf("Received pattern JSON: %s", string(body))
+	httpLogger.Debug("Received pattern JSON", "body", string(body))
 
 	var pattern motors.Pattern
 	err = json.Unmarshal(body, &pattern)
 	if err != nil {
-		log.Printf("Error decoding pattern JSON: %v", err)
+		httpLogger.Warn("Error decoding pattern JSON", "err", err)
 		http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
 		return
 	}
+	if err := pattern.Validate(); err != nil {
+		httpLogger.Warn("Invalid pattern", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	currentPattern = &pattern
-	log.Printf("New pattern set: %+v", pattern)
+	httpLogger.Info("New pattern set", "motors", len(pattern.Patterns), "length", pattern.Length())
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Pattern received successfully"))
-	log.Printf("Pattern request processed successfully")
+	httpLogger.Debug("Pattern request processed successfully")
+}
+
+// handlePatternMIDI exports the current pattern as MIDI for editing in a
+// DAW, or sets it from an edited file. The encoding, maxSpeed and cc query
+// parameters override the defaults.
+func handlePatternMIDI(w http.ResponseWriter, r *http.Request) {
+	opts := motors.DefaultMIDIOptions
+	query := r.URL.Query()
+	if encoding := query.Get("encoding"); encoding != "" {
+		opts.Encoding = encoding
+	}
+	if maxSpeed := query.Get("maxSpeed"); maxSpeed != "" {
+		if _, err := fmt.Sscanf(maxSpeed, "%g", &opts.MaxSpeed); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid maxSpeed: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if cc := query.Get("cc"); cc != "" {
+		if _, err := fmt.Sscanf(cc, "%d", &opts.Controller); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid cc: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if currentPattern == nil {
+			http.Error(w, "No pattern set", http.StatusNotFound)
+			return
+		}
+		var buf bytes.Buffer
+		if err := motors.ExportMIDI(&buf, currentPattern, opts); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "audio/midi")
+		w.Header().Set("Content-Disposition", `attachment; filename="pattern.mid"`)
+		w.Write(buf.Bytes())
+	case http.MethodPost:
+		pattern, err := motors.ImportMIDI(r.Body, opts)
+		if err != nil {
+			httpLogger.Warn("Error importing MIDI pattern", "err", err)
+			http.Error(w, fmt.Sprintf("Invalid MIDI: %v", err), http.StatusBadRequest)
+			return
+		}
+		currentPattern = pattern
+		httpLogger.Info("New pattern set from MIDI", "motors", len(pattern.Patterns), "length", pattern.Length())
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Pattern received successfully"))
+	default:
+		http.Error(w, "Only GET and POST methods are allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+type patternGenerateRequest struct {
+	Name   string             `json:"name"`
+	Params map[string]float64 `json:"params"`
+	Play   bool               `json:"play"`
+}
+
+// handlePatternGenerate lists the motion generators on GET and sets the
+// current pattern from one on POST, playing it straight away if asked.
+func handlePatternGenerate(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"generators": motors.GeneratorNames(),
+		})
+	case http.MethodPost:
+		var req patternGenerateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+			return
+		}
+		pattern, err := motors.Generate(req.Name, req.Params)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		currentPattern = pattern
+		httpLogger.Info("New pattern generated", "generator", req.Name, "motors", len(pattern.Patterns), "length", pattern.Length())
+		if req.Play {
+			go playCurrentPattern()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(pattern)
+	default:
+		http.Error(w, "Only GET and POST methods are allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handlePatternSimulate dry-runs the current pattern against the default
+// limits and returns the traces and violations.
+func handlePatternSimulate(w http.ResponseWriter, r *http.Request) {
+	if currentPattern == nil {
+		http.Error(w, "No pattern set", http.StatusNotFound)
+		return
+	}
+	sim, err := motors.Simulate(currentPattern, motors.DefaultLimits, 10*time.Millisecond)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sim)
+}
+
+type lightAnimationRequest struct {
+	Name       string             `json:"name"`
+	File       string             `json:"file"`
+	Params     map[string]float64 `json:"params"`
+	Transition lights.Transition  `json:"transition"`
+}
+
+func handleLightAnimation(w http.ResponseWriter, r *http.Request) {
+	httpLogger.Info("Received light animation request", "remote", r.RemoteAddr)
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"current":    lightEngine.Current(),
+			"animations": lights.AnimationNames(),
+		})
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "Only GET and POST methods are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req lightAnimationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpLogger.Warn("Error decoding light animation JSON", "err", err)
+		http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := req.Transition.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entry := lights.PlaylistEntry{Animation: req.Name, Params: req.Params}
+	if req.Name == lights.MediaAnimation {
+		file, err := lightsConfig.MediaPath(req.File)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		entry.File = file
+	}
+	animation, err := entry.Build()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	lightEngine.Play(animation, req.Transition)
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Animation started successfully"))
+}
+
+func handleLightPlaylist(w http.ResponseWriter, r *http.Request) {
+	httpLogger.Info("Received light playlist request", "remote", r.RemoteAddr)
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"playing":   lightPlayer.Playing(),
+			"playlists": lightPlayer.Playlists(),
+		})
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "Only GET and POST methods are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// An empty name hands control back to the time-of-day schedule
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpLogger.Warn("Error decoding light playlist JSON", "err", err)
+		http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := lightPlayer.Select(req.Name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Playlist selected successfully"))
+}
+
+func handleLightStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	status := map[string]interface{}{
+		"animation": lightEngine.Current(),
+		"playlist":  lightPlayer.Playing(),
+		"power":     panel.Power.Status(),
+	}
+	if dmxReceiver != nil {
+		status["dmx"] = dmxReceiver.Status()
+	}
+	if opcServer != nil {
+		status["opc"] = opcServer.Status()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+func handleLightLayout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(lights.Layout()); err != nil {
+		httpLogger.Error("Error encoding light layout", "err", err)
+	}
+}
+
+func handleLightBrightness(w http.ResponseWriter, r *http.Request) {
+	httpLogger.Info("Received light brightness request", "remote", r.RemoteAddr)
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Brightness int `json:"brightness"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpLogger.Warn("Error decoding light brightness JSON", "err", err)
+		http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.Brightness < 0 || req.Brightness > 255 {
+		http.Error(w, "Brightness must be between 0 and 255", http.StatusBadRequest)
+		return
+	}
+	panel.Power.SetBrightness(uint8(req.Brightness))
+	httpLogger.Info("Set light brightness", "brightness", req.Brightness)
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Brightness set successfully"))
+}
+
+// moveMotor is the MotorFunc shows use to drive the connected motors
+func moveMotor(motorId int, speed float64) error {
+	return motors.MoveMotor(int64(motorId), speed, &connectionsMutex, connections)
+}
+
+func sendMotorTarget(motorId int, target motors.Target) error {
+	return motors.SendTarget(motorId, target, &connectionsMutex, connections)
+}
+
+func handleShow(w http.ResponseWriter, r *http.Request) {
+	httpLogger.Info("Received show request", "remote", r.RemoteAddr)
+	switch r.Method {
+	case http.MethodGet:
+		showMutex.Lock()
+		defer showMutex.Unlock()
+		if currentShow == nil {
+			http.Error(w, "No show loaded", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(currentShow.Status())
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "Only GET and POST methods are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var s show.Show
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		httpLogger.Warn("Error decoding show JSON", "err", err)
+		http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	player, err := show.NewPlayer(&s, lightEngine, sendMotorTarget)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	player.HomeBeforePlay(homeAll)
+
+	showMutex.Lock()
+	if currentShow != nil {
+		currentShow.Close()
+	}
+	currentShow = player
+	showMutex.Unlock()
+	httpLogger.Info("New show loaded", "show", s.Name)
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Show loaded successfully"))
+}
+
+func handleShowControl(w http.ResponseWriter, r *http.Request) {
+	httpLogger.Info("Received show control request", "remote", r.RemoteAddr)
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Action   string `json:"action"`
+		Position int    `json:"position"` // Milliseconds, for seek
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	showMutex.Lock()
+	defer showMutex.Unlock()
+	if currentShow == nil {
+		http.Error(w, "No show loaded", http.StatusNotFound)
+		return
+	}
+
+	switch req.Action {
+	case "play":
+		currentShow.Play()
+	case "pause":
+		currentShow.Pause()
+	case "stop":
+		currentShow.Stop()
+	case "seek":
+		if err := currentShow.Seek(time.Duration(req.Position) * time.Millisecond); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, fmt.Sprintf("Unknown action: %s", req.Action), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentShow.Status())
+}
+
+// homeAll turns every calibrated motor back to its zero.
+func homeAll() error {
+	devicesMutex.Lock()
+	calibrated := make([]comms.DeviceInfo, len(devices))
+	copy(calibrated, devices)
+	devicesMutex.Unlock()
+
+	motorsLogger.Info("Homing all motors")
+	err := motors.HomeAll(calibrated, &connectionsMutex, connections)
+	if err != nil {
+		motorsLogger.Error("Error homing motors", "err", err)
+	}
+	return err
+}
+
+// calibrateAll records where every connected motor is now as its zero and
+// saves the offsets to the devices file.
+func calibrateAll() error {
+	connectionsMutex.Lock()
+	conns := make([]*comms.SerialConnection, len(connections))
+	copy(conns, connections)
+	connectionsMutex.Unlock()
+
+	devicesMutex.Lock()
+	defer devicesMutex.Unlock()
+	for index, conn := range conns {
+		device := comms.FindDevice(devices, conn.DeviceID)
+		if device == nil {
+			continue
+		}
+		offset, err := motors.Calibrate(index, &connectionsMutex, connections)
+		if err != nil {
+			motorsLogger.Error("Error calibrating", "device", conn.DeviceID, "err", err)
+			return err
+		}
+		device.ZeroOffset = &offset
+		motorsLogger.Info("Calibrated zero offset", "device", conn.DeviceID, "offset_rad", offset)
+	}
+	return comms.SaveDevices(devicesPath, devices)
+}
+
+func handleMotorsHome(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := homeAll(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Motors homed"))
+}
+
+// handleMotorsCalibrate takes the current pose of the panels as the neutral
+// one. Turn the panels by hand, or with velocity commands, before calling it.
+func handleMotorsCalibrate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := calibrateAll(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	devicesMutex.Lock()
+	defer devicesMutex.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(devices)
 }
 
 func playCurrentPattern() {
 	if currentPattern == nil {
-		log.Println("No pattern to play")
+		motorsLogger.Warn("No pattern to play")
 		return
 	}
 
+	// Patterns start from the neutral pose
+	homeAll()
 	err := motors.ScheduleMotorMovements(currentPattern, &connectionsMutex, connections)
 	if err != nil {
-		log.Printf("Error playing pattern: %v", err)
+		motorsLogger.Error("Error playing pattern", "err", err)
 	}
 }
 
@@ -529,9 +1213,9 @@ func handleDeviceUpdates(conn *comms.SerialConnection) {
 }
 
 func handleSerialNumbers(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Received serial numbers request from %s", r.RemoteAddr)
+	httpLogger.Info("Received serial numbers request", "remote", r.RemoteAddr)
 	if r.Method != http.MethodGet {
-		log.Printf("Invalid method for serial numbers request: %s", r.Method)
+		httpLogger.Warn("Invalid method for serial numbers request", "method", r.Method)
 		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
 		return
 	}
@@ -549,13 +1233,13 @@ func handleSerialNumbers(w http.ResponseWriter, r *http.Request) {
 	case serialNumbers := <-resultChan:
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewEncoder(w).Encode(serialNumbers); err != nil {
-			log.Printf("Error encoding serial numbers: %v", err)
+			httpLogger.Error("Error encoding serial numbers", "err", err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
-		log.Printf("Serial numbers request processed successfully")
+		httpLogger.Debug("Serial numbers request processed successfully")
 	case <-time.After(15 * time.Second):
-		log.Printf("Timeout while processing serial numbers request")
+		httpLogger.Warn("Timeout while processing serial numbers request")
 		http.Error(w, "Request timed out", http.StatusRequestTimeout)
 	}
 }
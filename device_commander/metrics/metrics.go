@@ -0,0 +1,239 @@
+// Package metrics keeps counters, gauges and histograms of the commander's
+// health and timing, and writes them in the Prometheus text format for
+// scraping from /metrics.
+//
+// Metrics are created once, in package variables, with the names of their
+// labels. Updates pass the label values in the same order.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []metric
+	names      = make(map[string]bool)
+)
+
+func register(name string, m metric) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if names[name] {
+		panic(fmt.Sprintf("metrics: %s registered twice", name))
+	}
+	names[name] = true
+	registry = append(registry, m)
+}
+
+type desc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (d *desc) header(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, d.kind)
+}
+
+// key joins label values into a map key, checking there is one per label.
+func (d *desc) key(labelValues []string) string {
+	if len(labelValues) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s has %d labels, got %d values", d.name, len(d.labels), len(labelValues)))
+	}
+	return strings.Join(labelValues, "\xff")
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// sample writes one line. extra is a last label that isn't one of the
+// metric's, such as a histogram bucket's le.
+func (d *desc) sample(w *bufio.Writer, suffix string, labelValues []string, extra []string, value float64) {
+	w.WriteString(d.name)
+	w.WriteString(suffix)
+	names := d.labels
+	if extra != nil {
+		names = append(names[:len(names):len(names)], extra[0])
+		labelValues = append(labelValues[:len(labelValues):len(labelValues)], extra[1])
+	}
+	if len(names) > 0 {
+		w.WriteByte('{')
+		for i, name := range names {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, name, labelEscaper.Replace(labelValues[i]))
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatValue(value))
+	w.WriteByte('\n')
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// series is one set of label values and its value.
+type series struct {
+	labelValues []string
+	value       float64
+}
+
+// values keeps a value per set of label values, for counters and gauges.
+type values struct {
+	desc
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+func newValues(name, help, kind string, labels []string) *values {
+	v := &values{desc: desc{name: name, help: help, kind: kind, labels: labels}, series: make(map[string]*series)}
+	register(name, v)
+	return v
+}
+
+func (v *values) update(labelValues []string, f func(float64) float64) {
+	key := v.key(labelValues)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		v.series[key] = s
+	}
+	s.value = f(s.value)
+}
+
+func (v *values) write(w *bufio.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.header(w)
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := v.series[key]
+		v.sample(w, "", s.labelValues, nil, s.value)
+	}
+}
+
+// Counter is a value that only goes up, such as commands sent.
+type Counter struct {
+	v *values
+}
+
+// NewCounter creates and registers a counter.
+func NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{newValues(name, help, "counter", labels)}
+}
+
+// Inc adds one.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds a value, which must not be negative.
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("metrics: counter %s decreased", c.v.name))
+	}
+	c.v.update(labelValues, func(v float64) float64 { return v + delta })
+}
+
+// Gauge is a value that goes up and down, such as whether a device is
+// connected.
+type Gauge struct {
+	v *values
+}
+
+// NewGauge creates and registers a gauge.
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{newValues(name, help, "gauge", labels)}
+}
+
+// Set sets the value.
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.v.update(labelValues, func(float64) float64 { return value })
+}
+
+// Add adds to the value, or subtracts a negative delta.
+func (g *Gauge) Add(delta float64, labelValues ...string) {
+	g.v.update(labelValues, func(v float64) float64 { return v + delta })
+}
+
+// GaugeFunc is a gauge whose values are read when the metrics are written,
+// such as how long ago a device sent a heartbeat.
+type GaugeFunc struct {
+	desc
+	collect func(emit func(value float64, labelValues ...string))
+}
+
+// NewGaugeFunc creates and registers a gauge that calls collect on every
+// scrape, which calls emit once per set of label values.
+func NewGaugeFunc(name, help string, collect func(emit func(value float64, labelValues ...string)), labels ...string) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{name: name, help: help, kind: "gauge", labels: labels}, collect: collect}
+	register(name, g)
+	return g
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	var collected []series
+	g.collect(func(value float64, labelValues ...string) {
+		g.key(labelValues)
+		collected = append(collected, series{labelValues: labelValues, value: value})
+	})
+	sort.Slice(collected, func(i, j int) bool {
+		return strings.Join(collected[i].labelValues, "\xff") < strings.Join(collected[j].labelValues, "\xff")
+	})
+	g.header(w)
+	for _, s := range collected {
+		g.sample(w, "", s.labelValues, nil, s.value)
+	}
+}
+
+// WriteText writes every metric in the Prometheus text format.
+func WriteText(w io.Writer) error {
+	registryMu.Lock()
+	metrics := append([]metric(nil), registry...)
+	registryMu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler serves the metrics to Prometheus.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteText(w)
+	})
+}
@@ -0,0 +1,207 @@
+package motors
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// SpeedFunc gives the speed of a motor at a time into the pattern. A
+// generator samples it for each motor in turn, in time order, so it may keep
+// state per motor.
+type SpeedFunc func(motorId int, t time.Duration) float64
+
+// GeneratorFactory builds a speed function from optional named parameters.
+type GeneratorFactory func(params map[string]float64) SpeedFunc
+
+var generatorRegistry = map[string]GeneratorFactory{
+	"sine":          sineWave,
+	"triangle":      triangleWave,
+	"travelingWave": travelingWave,
+	"randomWalk":    randomWalk,
+	"breathing":     breathing,
+	"alternate":     alternate,
+}
+
+// Generate builds a pattern from a registered generator. Besides the
+// generator's own parameters it takes
+//
+//	duration  length of the pattern in ms (60000)
+//	step      length of each segment in ms (250)
+//	motors    number of motors (7)
+func Generate(name string, params map[string]float64) (*Pattern, error) {
+	factory, ok := generatorRegistry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown generator: %s", name)
+	}
+
+	duration := time.Duration(param(params, "duration", 60000)) * time.Millisecond
+	step := time.Duration(param(params, "step", 250)) * time.Millisecond
+	motorCount := int(param(params, "motors", 7))
+	if duration <= 0 || step <= 0 {
+		return nil, fmt.Errorf("duration and step must be positive")
+	}
+	if motorCount <= 0 {
+		return nil, fmt.Errorf("invalid number of motors: %d", motorCount)
+	}
+	// The generators divide by these, alternate by half the period
+	if period, ok := params["period"]; ok && time.Duration(period)*time.Millisecond < 2*time.Millisecond {
+		return nil, fmt.Errorf("period must be at least 2ms, got %v", period)
+	}
+	if rest, ok := params["rest"]; ok && rest < 0 {
+		return nil, fmt.Errorf("rest must not be negative, got %v", rest)
+	}
+	if waves, ok := params["waves"]; ok && waves == 0 {
+		return nil, fmt.Errorf("waves must not be 0")
+	}
+
+	speed := factory(params)
+	pattern := &Pattern{}
+	for motorId := 0; motorId < motorCount; motorId++ {
+		mp := MotorPattern{MotorId: motorId}
+		for t := time.Duration(0); t < duration; t += step {
+			s := roundSpeed(speed(motorId, t))
+			if s == 0 {
+				s = 0 // Rounding keeps the sign, and JSON would show -0
+			}
+			length := int(min(step, duration-t) / time.Millisecond)
+			// Merge steps that keep the speed
+			if last := len(mp.Segments) - 1; last >= 0 && mp.Segments[last].Speed == s {
+				mp.Segments[last].Duration += length
+			} else {
+				mp.Segments = append(mp.Segments, Segment{Duration: length, Speed: s})
+			}
+		}
+		mp.Segments = append(mp.Segments, Segment{})
+		pattern.Patterns = append(pattern.Patterns, mp)
+	}
+	return pattern, nil
+}
+
+// GeneratorNames returns the names of all registered generators.
+func GeneratorNames() []string {
+	names := make([]string, 0, len(generatorRegistry))
+	for name := range generatorRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func param(params map[string]float64, key string, fallback float64) float64 {
+	if v, ok := params[key]; ok {
+		return v
+	}
+	return fallback
+}
+
+// wave holds the parameters shared by the periodic generators.
+type wave struct {
+	amplitude float64
+	offset    float64
+	period    time.Duration
+	phase     float64 // Fraction of a period between neighbouring motors
+}
+
+func newWave(params map[string]float64, phase float64) wave {
+	return wave{
+		amplitude: param(params, "amplitude", 5),
+		offset:    param(params, "offset", 0),
+		period:    time.Duration(param(params, "period", 10000)) * time.Millisecond,
+		phase:     param(params, "phase", phase),
+	}
+}
+
+// position returns how far through its period a motor is, 0-1.
+func (w wave) position(motorId int, t time.Duration) float64 {
+	p := float64(t)/float64(w.period) + w.phase*float64(motorId)
+	return p - math.Floor(p)
+}
+
+func sineWave(params map[string]float64) SpeedFunc {
+	w := newWave(params, 0)
+	return func(motorId int, t time.Duration) float64 {
+		return w.offset + w.amplitude*math.Sin(2*math.Pi*w.position(motorId, t))
+	}
+}
+
+func triangleWave(params map[string]float64) SpeedFunc {
+	w := newWave(params, 0)
+	return func(motorId int, t time.Duration) float64 {
+		p := w.position(motorId, t)
+		// Rises from -1 to 1 over the first half, falls back over the second
+		tri := 4*p - 1
+		if p > 0.5 {
+			tri = 3 - 4*p
+		}
+		return w.offset + w.amplitude*tri
+	}
+}
+
+// travelingWave sends a sine wave around the ring of motors, completing
+// "waves" cycles around the ring; a negative count turns it the other way.
+func travelingWave(params map[string]float64) SpeedFunc {
+	motorCount := param(params, "motors", 7)
+	waves := param(params, "waves", 1)
+	w := newWave(params, -waves/motorCount)
+	return func(motorId int, t time.Duration) float64 {
+		return w.offset + w.amplitude*math.Sin(2*math.Pi*w.position(motorId, t))
+	}
+}
+
+// randomWalk drifts each motor's speed by up to "jitter" every step, pulled
+// back towards the offset so it doesn't stick at the limits.
+func randomWalk(params map[string]float64) SpeedFunc {
+	amplitude := param(params, "amplitude", 5)
+	offset := param(params, "offset", 0)
+	jitter := param(params, "jitter", 1)
+	pull := param(params, "pull", 0.05)
+	seed := int64(param(params, "seed", float64(time.Now().UnixNano())))
+
+	rng := rand.New(rand.NewSource(seed))
+	speeds := make(map[int]float64)
+	return func(motorId int, t time.Duration) float64 {
+		s := speeds[motorId] + (rng.Float64()*2-1)*jitter - speeds[motorId]*pull
+		s = max(-amplitude, min(amplitude, s))
+		speeds[motorId] = s
+		return offset + s
+	}
+}
+
+// breathing moves every motor together, easing in and out of a slow turn
+// and resting for "rest" ms between breaths.
+func breathing(params map[string]float64) SpeedFunc {
+	amplitude := param(params, "amplitude", 3)
+	inhale := time.Duration(param(params, "period", 6000)) * time.Millisecond
+	rest := time.Duration(param(params, "rest", 2000)) * time.Millisecond
+	alternating := param(params, "alternate", 1) != 0
+	return func(motorId int, t time.Duration) float64 {
+		breath := t / (inhale + rest)
+		t %= inhale + rest
+		if t >= inhale {
+			return 0
+		}
+		s := amplitude * (1 - math.Cos(2*math.Pi*float64(t)/float64(inhale))) / 2
+		// Breathe out the other way
+		if alternating && breath%2 == 1 {
+			s = -s
+		}
+		return s
+	}
+}
+
+// alternate turns neighbouring motors in opposite directions, swapping
+// every half period.
+func alternate(params map[string]float64) SpeedFunc {
+	amplitude := param(params, "amplitude", 5)
+	period := time.Duration(param(params, "period", 8000)) * time.Millisecond
+	return func(motorId int, t time.Duration) float64 {
+		s := amplitude
+		if (int(t/(period/2))+motorId)%2 == 1 {
+			s = -s
+		}
+		return s
+	}
+}
@@ -0,0 +1,137 @@
+package motors
+
+import (
+	"device_commander/comms"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HomeSpeed is how fast motors turn back to their zero, rad/s.
+const HomeSpeed = 4
+
+var angleLine = regexp.MustCompile(`(?i)angle[^-\d]*(-?\d+(?:\.\d+)?)`)
+
+// parseAngle finds the angle in a board's reply to the monitor get command,
+// which the commander prints as "Angle: 1.23" in verbose mode or as a bare
+// number otherwise.
+func parseAngle(output string) (float64, bool) {
+	lines := strings.Split(output, "\n")
+	for _, line := range lines {
+		if m := angleLine.FindStringSubmatch(line); m != nil {
+			if v, err := strconv.ParseFloat(m[1], 64); err == nil {
+				return v, true
+			}
+		}
+	}
+	for _, line := range lines {
+		if v, err := strconv.ParseFloat(strings.TrimSpace(line), 64); err == nil {
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+// ReadAngle asks a board for its shaft angle in rad, after its zero offset.
+func ReadAngle(index int, connectionsMutex *sync.Mutex, connections []*comms.SerialConnection) (float64, error) {
+	// Monitored variable 6 is the shaft angle
+	return query(index, "MMG6", parseAngle, connectionsMutex, connections)
+}
+
+// SetZeroOffset tells a board which sensor angle is its zero.
+func SetZeroOffset(index int, offset float64, connectionsMutex *sync.Mutex, connections []*comms.SerialConnection) error {
+	if index < 0 || index >= len(connections) {
+		return fmt.Errorf("invalid motor ID: %d", index)
+	}
+	comms.WriteCommand(fmt.Sprintf("MSM%.4f", offset), connectionsMutex, connections, index)
+	return nil
+}
+
+// Calibrate records where a motor is now as its zero: it clears the board's
+// offset, reads the raw angle and sets that as the new offset, which it
+// returns to be saved with the device.
+func Calibrate(index int, connectionsMutex *sync.Mutex, connections []*comms.SerialConnection) (float64, error) {
+	if err := SetZeroOffset(index, 0, connectionsMutex, connections); err != nil {
+		return 0, err
+	}
+	offset, err := ReadAngle(index, connectionsMutex, connections)
+	if err != nil {
+		return 0, err
+	}
+	return offset, SetZeroOffset(index, offset, connectionsMutex, connections)
+}
+
+// Home turns a motor back to its zero the short way, to the nearest whole
+// turn, waits for it to get there, and hands the board back in velocity mode
+// standing still so patterns can follow.
+func Home(index int, offset float64, connectionsMutex *sync.Mutex, connections []*comms.SerialConnection) error {
+	if err := SetZeroOffset(index, offset, connectionsMutex, connections); err != nil {
+		return err
+	}
+	angle, err := ReadAngle(index, connectionsMutex, connections)
+	if err != nil {
+		return err
+	}
+
+	zero := 2 * math.Pi * math.Round(angle/(2*math.Pi))
+	if err := SendTarget(index, Target{Mode: ModeAngle, Value: zero, SpeedLimit: HomeSpeed, SwitchMode: true}, connectionsMutex, connections); err != nil {
+		return err
+	}
+
+	// Half a turn at home speed, and some to settle
+	deadline := time.Now().Add(time.Duration(math.Ceil(math.Pi/HomeSpeed))*time.Second + 2*time.Second)
+	for time.Now().Before(deadline) {
+		time.Sleep(250 * time.Millisecond)
+		if angle, err = ReadAngle(index, connectionsMutex, connections); err == nil && math.Abs(angle-zero) < 0.05 {
+			break
+		}
+	}
+
+	// Stopping also gives the board its own velocity limit back
+	if err := SendTarget(index, Stop(0), connectionsMutex, connections); err != nil {
+		return err
+	}
+	if math.Abs(angle-zero) >= 0.05 {
+		return fmt.Errorf("device %s stopped at %.2f rad, %.2f from zero", connections[index].DeviceID, angle, angle-zero)
+	}
+	return nil
+}
+
+// HomeAll homes every calibrated motor at once and returns once they are all
+// back or have given up. Motors without an offset are left where they are.
+func HomeAll(devices []comms.DeviceInfo, connectionsMutex *sync.Mutex, connections []*comms.SerialConnection) error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var failed []string
+
+	connectionsMutex.Lock()
+	conns := make([]*comms.SerialConnection, len(connections))
+	copy(conns, connections)
+	connectionsMutex.Unlock()
+
+	for index, conn := range conns {
+		device := comms.FindDevice(devices, conn.DeviceID)
+		if device == nil || device.ZeroOffset == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(index int, offset float64) {
+			defer wg.Done()
+			if err := Home(index, offset, connectionsMutex, connections); err != nil {
+				mu.Lock()
+				failed = append(failed, err.Error())
+				mu.Unlock()
+			}
+		}(index, *device.ZeroOffset)
+	}
+	wg.Wait()
+
+	if len(failed) > 0 {
+		return fmt.Errorf("homing failed: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,337 @@
+package motors
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// How speeds are written to a MIDI track
+const (
+	// EncodeVelocity writes every moving segment as a note, the speed as its
+	// velocity and the direction as its pitch. Lossy: 127 steps up to
+	// MaxSpeed.
+	EncodeVelocity = "velocity"
+	// EncodeCC writes every segment as a 14-bit control change, centred on
+	// 8192 for a stopped motor.
+	EncodeCC = "cc"
+)
+
+const (
+	// 120 BPM at 500 ticks per quarter note makes a tick a millisecond, so
+	// segment durations survive the round trip exactly.
+	exportTempo      = 120
+	exportResolution = 500
+
+	forwardNote = 60 // Middle C
+	reverseNote = 48 // An octave down, easy to tell apart in a piano roll
+
+	lsbOffset = 32 // Controllers 32-63 carry the LSB of controllers 0-31
+)
+
+// MIDIOptions controls how patterns are encoded as MIDI.
+type MIDIOptions struct {
+	Encoding   string
+	MaxSpeed   float64 // Speed at full velocity or controller value
+	Controller uint8   // MSB controller for EncodeCC, 0-31
+}
+
+// Matches the scaling of the MIDI converter, velocity 127 is speed 10
+var DefaultMIDIOptions = MIDIOptions{
+	Encoding:   EncodeVelocity,
+	MaxSpeed:   10,
+	Controller: 20,
+}
+
+func (o MIDIOptions) Validate() error {
+	if o.Encoding != EncodeVelocity && o.Encoding != EncodeCC {
+		return fmt.Errorf("unknown MIDI encoding %q", o.Encoding)
+	}
+	if o.MaxSpeed <= 0 {
+		return fmt.Errorf("invalid max speed: %v", o.MaxSpeed)
+	}
+	if o.Controller > 31 {
+		return fmt.Errorf("controller %d has no LSB partner, use 0-31", o.Controller)
+	}
+	return nil
+}
+
+// motorChannel gives each motor its own channel, skipping the drum channel.
+func motorChannel(motorId int) uint8 {
+	channel := uint8(motorId % 15)
+	if channel >= 9 {
+		channel++
+	}
+	return channel
+}
+
+func trackName(motorId int) string {
+	return fmt.Sprintf("Motor %d", motorId)
+}
+
+// ExportMIDI writes a pattern as a multi-track Standard MIDI File with a
+// tempo track followed by one track per motor.
+func ExportMIDI(w io.Writer, p *Pattern, opts MIDIOptions) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	if p.Positional() {
+		return fmt.Errorf("angle and rotate segments can't be written as MIDI")
+	}
+	// Faster segments would be clamped to MaxSpeed and come back slower
+	for _, mp := range p.Patterns {
+		for i, segment := range mp.Segments {
+			if math.Abs(segment.Speed) > opts.MaxSpeed {
+				return fmt.Errorf("motor %d segment %d: speed %v is faster than the max speed %v", mp.MotorId, i, segment.Speed, opts.MaxSpeed)
+			}
+		}
+	}
+
+	s := smf.NewSMF1()
+	s.TimeFormat = smf.MetricTicks(exportResolution)
+
+	var tempo smf.Track
+	tempo.Add(0, smf.MetaTempo(exportTempo))
+	tempo.Close(0)
+	if err := s.Add(tempo); err != nil {
+		return err
+	}
+
+	for _, mp := range p.Patterns {
+		track := exportTrack(mp, opts)
+		if err := s.Add(track); err != nil {
+			return fmt.Errorf("error adding track for motor %d: %w", mp.MotorId, err)
+		}
+	}
+
+	if _, err := s.WriteTo(w); err != nil {
+		return fmt.Errorf("error writing MIDI: %w", err)
+	}
+	return nil
+}
+
+func exportTrack(mp MotorPattern, opts MIDIOptions) smf.Track {
+	var track smf.Track
+	channel := motorChannel(mp.MotorId)
+	track.Add(0, smf.MetaTrackSequenceName(trackName(mp.MotorId)))
+
+	// Rests have no events of their own, their time carries over to the
+	// next event
+	var delta uint32
+	var playing uint8
+	var sounding bool
+	for _, segment := range mp.Segments {
+		if opts.Encoding == EncodeCC {
+			msb, lsb := encodeControlSpeed(segment.Speed, opts.MaxSpeed)
+			track.Add(delta, midi.ControlChange(channel, opts.Controller, msb))
+			track.Add(0, midi.ControlChange(channel, opts.Controller+lsbOffset, lsb))
+			delta = 0
+		} else {
+			if sounding {
+				track.Add(delta, midi.NoteOff(channel, playing))
+				delta, sounding = 0, false
+			}
+			if segment.Speed != 0 {
+				playing = forwardNote
+				if segment.Speed < 0 {
+					playing = reverseNote
+				}
+				track.Add(delta, midi.NoteOn(channel, playing, encodeVelocitySpeed(segment.Speed, opts.MaxSpeed)))
+				delta, sounding = 0, true
+			}
+		}
+		delta += uint32(segment.Duration)
+	}
+	if sounding {
+		track.Add(delta, midi.NoteOff(channel, playing))
+		delta = 0
+	}
+	track.Close(delta)
+	return track
+}
+
+func encodeVelocitySpeed(speed, maxSpeed float64) uint8 {
+	return uint8(max(1, min(127, math.Round(math.Abs(speed)/maxSpeed*127))))
+}
+
+func decodeVelocitySpeed(key, velocity uint8, maxSpeed float64) float64 {
+	speed := roundSpeed(float64(velocity) / 127 * maxSpeed)
+	if key < forwardNote {
+		return -speed
+	}
+	return speed
+}
+
+func encodeControlSpeed(speed, maxSpeed float64) (msb, lsb uint8) {
+	value := int(max(0, min(16383, math.Round(speed/maxSpeed*8191)+8192)))
+	return uint8(value >> 7), uint8(value & 0x7f)
+}
+
+func decodeControlSpeed(value int, maxSpeed float64) float64 {
+	return roundSpeed(max(-1, min(1, float64(value-8192)/8191)) * maxSpeed)
+}
+
+// roundSpeed rounds to the precision of the motor command.
+func roundSpeed(speed float64) float64 {
+	return math.Round(speed*100) / 100
+}
+
+// speedChange is a speed a motor takes at a time in milliseconds.
+type speedChange struct {
+	at    int64
+	speed float64
+}
+
+type importTrack struct {
+	motorId  int
+	named    bool
+	channel  int
+	changes  []speedChange
+	end      int64
+	held     map[uint8]bool
+	msb, lsb int
+}
+
+// ImportMIDI reads a Standard MIDI File written by ExportMIDI, or edited in
+// a DAW since, back into a pattern. Tracks named "Motor N" drive motor N,
+// other tracks are matched to motors by their channel. Notes and control
+// changes are both understood, whatever opts.Encoding says.
+func ImportMIDI(r io.Reader, opts MIDIOptions) (*Pattern, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	tracks := make(map[int]*importTrack)
+	reader := smf.ReadTracksFrom(r)
+	reader.Do(func(te smf.TrackEvent) {
+		t := tracks[te.TrackNo]
+		if t == nil {
+			t = &importTrack{channel: -1, held: make(map[uint8]bool)}
+			tracks[te.TrackNo] = t
+		}
+		at := te.AbsMicroSeconds / 1000
+		t.end = max(t.end, at)
+
+		var name string
+		var channel, key, velocity, controller, value uint8
+		switch msg := te.Message; {
+		case msg.GetMetaTrackName(&name):
+			if _, err := fmt.Sscanf(name, "Motor %d", &t.motorId); err == nil {
+				t.named = true
+			}
+		case msg.GetNoteStart(&channel, &key, &velocity):
+			t.channel = int(channel)
+			t.held[key] = true
+			t.changes = append(t.changes, speedChange{at, decodeVelocitySpeed(key, velocity, opts.MaxSpeed)})
+		case msg.GetNoteEnd(&channel, &key):
+			// Only the last note sounding stops the motor
+			delete(t.held, key)
+			if len(t.held) == 0 {
+				t.changes = append(t.changes, speedChange{at, 0})
+			}
+		case msg.GetControlChange(&channel, &controller, &value):
+			t.channel = int(channel)
+			switch controller {
+			case opts.Controller:
+				// A new MSB resets the LSB
+				t.msb, t.lsb = int(value), 0
+			case opts.Controller + lsbOffset:
+				t.lsb = int(value)
+				// Replaces the change made by the MSB
+				if last := len(t.changes) - 1; last >= 0 && t.changes[last].at == at {
+					t.changes = t.changes[:last]
+				}
+			default:
+				return
+			}
+			t.changes = append(t.changes, speedChange{at, decodeControlSpeed(t.msb<<7|t.lsb, opts.MaxSpeed)})
+		}
+	})
+	if err := reader.Error(); err != nil {
+		return nil, fmt.Errorf("error reading MIDI: %w", err)
+	}
+
+	trackNos := make([]int, 0, len(tracks))
+	for trackNo := range tracks {
+		trackNos = append(trackNos, trackNo)
+	}
+	sort.Ints(trackNos)
+
+	pattern := &Pattern{}
+	seen := make(map[int]bool)
+	for _, trackNo := range trackNos {
+		t := tracks[trackNo]
+		if len(t.changes) == 0 {
+			continue // Tempo and other meta tracks
+		}
+		if !t.named {
+			t.motorId = channelMotor(t.channel)
+		}
+		if seen[t.motorId] {
+			return nil, fmt.Errorf("more than one track for motor %d", t.motorId)
+		}
+		seen[t.motorId] = true
+		pattern.Patterns = append(pattern.Patterns, MotorPattern{
+			MotorId:  t.motorId,
+			Segments: changesToSegments(t.changes, t.end),
+		})
+	}
+	if len(pattern.Patterns) == 0 {
+		return nil, fmt.Errorf("no motor tracks found")
+	}
+	return pattern, nil
+}
+
+// ImportMIDIFile reads a pattern from a MIDI file.
+func ImportMIDIFile(path string, opts MIDIOptions) (*Pattern, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening MIDI file: %w", err)
+	}
+	defer f.Close()
+	return ImportMIDI(f, opts)
+}
+
+func channelMotor(channel int) int {
+	if channel > 9 {
+		channel--
+	}
+	return channel
+}
+
+// changesToSegments turns speed changes into back to back segments up to
+// end, merging changes that keep the speed.
+func changesToSegments(changes []speedChange, end int64) []Segment {
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].at < changes[j].at })
+
+	var segments []Segment
+	var at int64
+	speed := 0.0
+	hold := func(until int64) {
+		if until <= at {
+			return
+		}
+		if last := len(segments) - 1; last >= 0 && segments[last].Speed == speed {
+			segments[last].Duration += int(until - at)
+		} else {
+			segments = append(segments, Segment{Duration: int(until - at), Speed: speed})
+		}
+		at = until
+	}
+	for _, c := range changes {
+		hold(c.at)
+		speed = c.speed
+	}
+	hold(end)
+
+	// Patterns end with an explicit stop
+	if last := len(segments) - 1; last < 0 || segments[last] != (Segment{}) {
+		segments = append(segments, Segment{})
+	}
+	return segments
+}
@@ -0,0 +1,65 @@
+package motors
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestMIDIRoundTrip(t *testing.T) {
+	pattern := &Pattern{Patterns: []MotorPattern{
+		{MotorId: 0, Segments: []Segment{
+			{Duration: 1000, Speed: 2.5},
+			{Duration: 500, Speed: 0},
+			{Duration: 750, Speed: -4.2},
+			{Duration: 250, Speed: 10},
+			{Duration: 0, Speed: 0},
+		}},
+		{MotorId: 3, Segments: []Segment{
+			{Duration: 333, Speed: -1},
+			{Duration: 667, Speed: 7.77},
+			{Duration: 0, Speed: 0},
+		}},
+	}}
+
+	tests := []struct {
+		encoding string
+		step     float64 // Largest speed change one encoded value makes
+	}{
+		{EncodeVelocity, DefaultMIDIOptions.MaxSpeed / 127},
+		{EncodeCC, DefaultMIDIOptions.MaxSpeed / 8191},
+	}
+	for _, test := range tests {
+		t.Run(test.encoding, func(t *testing.T) {
+			opts := DefaultMIDIOptions
+			opts.Encoding = test.encoding
+			var buf bytes.Buffer
+			if err := ExportMIDI(&buf, pattern, opts); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ImportMIDI(&buf, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got.Patterns) != len(pattern.Patterns) {
+				t.Fatalf("got %d motors, want %d", len(got.Patterns), len(pattern.Patterns))
+			}
+			for i, want := range pattern.Patterns {
+				mp := got.Patterns[i]
+				if mp.MotorId != want.MotorId || len(mp.Segments) != len(want.Segments) {
+					t.Fatalf("motor %d: got %+v, want %+v", want.MotorId, mp, want)
+				}
+				for j, segment := range mp.Segments {
+					if segment.Duration != want.Segments[j].Duration {
+						t.Errorf("motor %d segment %d lasts %d ms, want %d", want.MotorId, j, segment.Duration, want.Segments[j].Duration)
+					}
+					// Within half a step, and the rounding to the motor command
+					if math.Abs(segment.Speed-want.Segments[j].Speed) > test.step/2+0.005 {
+						t.Errorf("motor %d segment %d speed %v, want %v", want.MotorId, j, segment.Speed, want.Segments[j].Speed)
+					}
+				}
+			}
+		})
+	}
+}
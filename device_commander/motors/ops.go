@@ -0,0 +1,230 @@
+package motors
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Operations that build new patterns from existing ones. None of them
+// modify their inputs. Motors are assumed to sit on a ring, so rotating and
+// mirroring work modulo the number of motors.
+
+// tracks returns a pattern's segments by motor, copied, with zero length
+// segments dropped. The stop that ends a pattern is added back by build.
+func (p *Pattern) tracks() map[int][]Segment {
+	tracks := make(map[int][]Segment)
+	for _, mp := range p.Patterns {
+		segments := tracks[mp.MotorId]
+		for _, s := range mp.Segments {
+			if s.Duration > 0 {
+				segments = append(segments, s)
+			}
+		}
+		tracks[mp.MotorId] = segments
+	}
+	return tracks
+}
+
+// build turns tracks back into a pattern ordered by motor, merging
+// neighbouring segments of the same motion and ending every track with a
+// stop.
+func build(tracks map[int][]Segment) *Pattern {
+	motorIds := make([]int, 0, len(tracks))
+	for motorId := range tracks {
+		motorIds = append(motorIds, motorId)
+	}
+	sort.Ints(motorIds)
+
+	p := &Pattern{}
+	for _, motorId := range motorIds {
+		var merged []Segment
+		for _, s := range tracks[motorId] {
+			if s.Duration <= 0 {
+				continue
+			}
+			// Two rotations in a row turn twice as far, so they stay apart
+			if last := len(merged) - 1; last >= 0 && s.Mode != ModeRotate && sameMotion(merged[last], s) {
+				merged[last].Duration += s.Duration
+			} else {
+				merged = append(merged, s)
+			}
+		}
+		p.Patterns = append(p.Patterns, MotorPattern{MotorId: motorId, Segments: append(merged, Segment{})})
+	}
+	return p
+}
+
+func sameMotion(a, b Segment) bool {
+	return a.Speed == b.Speed && a.Angle == b.Angle && a.mode() == b.mode()
+}
+
+func trackLength(segments []Segment) int {
+	length := 0
+	for _, s := range segments {
+		length += s.Duration
+	}
+	return length
+}
+
+func (p *Pattern) lengthMillis() int {
+	return int(p.Length() / time.Millisecond)
+}
+
+// Concat plays patterns one after another. Each pattern starts when the
+// longest track of the one before has finished, motors that finish early
+// rest until then.
+func Concat(patterns ...*Pattern) *Pattern {
+	result := make(map[int][]Segment)
+	offset := 0
+	for _, p := range patterns {
+		for motorId, segments := range p.tracks() {
+			track := result[motorId]
+			if gap := offset - trackLength(track); gap > 0 {
+				track = append(track, Segment{Duration: gap})
+			}
+			result[motorId] = append(track, segments...)
+		}
+		offset += p.lengthMillis()
+	}
+	return build(result)
+}
+
+// Overlay layers the motor tracks of several patterns. A motor that appears
+// in more than one takes its track from the last.
+func Overlay(patterns ...*Pattern) *Pattern {
+	result := make(map[int][]Segment)
+	for _, p := range patterns {
+		for motorId, segments := range p.tracks() {
+			result[motorId] = segments
+		}
+	}
+	return build(result)
+}
+
+// Stretch scales every duration by factor, keeping the speeds. A factor of
+// 2 makes the pattern twice as long, each motor turning twice as far.
+func Stretch(p *Pattern, factor float64) (*Pattern, error) {
+	if factor <= 0 {
+		return nil, fmt.Errorf("invalid stretch factor: %v", factor)
+	}
+	tracks := p.tracks()
+	for _, segments := range tracks {
+		for i := range segments {
+			segments[i].Duration = int(float64(segments[i].Duration)*factor + 0.5)
+		}
+	}
+	return build(tracks), nil
+}
+
+// ScaleTempo plays a pattern factor times as fast: durations shrink and
+// speeds grow by the same factor, so every motor still turns as far. Angle
+// segments keep their targets with their speed limits scaled.
+func ScaleTempo(p *Pattern, factor float64) (*Pattern, error) {
+	if factor <= 0 {
+		return nil, fmt.Errorf("invalid tempo factor: %v", factor)
+	}
+	tracks := p.tracks()
+	for _, segments := range tracks {
+		for i := range segments {
+			segments[i].Duration = int(float64(segments[i].Duration)/factor + 0.5)
+			segments[i].Speed = roundSpeed(segments[i].Speed * factor)
+		}
+	}
+	return build(tracks), nil
+}
+
+// Invert turns every motor the other way, angle targets included.
+func Invert(p *Pattern) *Pattern {
+	tracks := p.tracks()
+	for _, segments := range tracks {
+		for i := range segments {
+			if segments[i].positional() {
+				segments[i].Angle = -segments[i].Angle
+			} else {
+				segments[i].Speed = -segments[i].Speed
+			}
+		}
+	}
+	return build(tracks)
+}
+
+// Reverse plays the pattern backwards in time. Tracks shorter than the
+// pattern start late so they still end together. Combine with Invert to
+// retrace velocity segments back to where they started; angle targets are
+// poses and are visited in reverse order.
+func Reverse(p *Pattern) *Pattern {
+	length := p.lengthMillis()
+	tracks := p.tracks()
+	for motorId, segments := range tracks {
+		reversed := make([]Segment, 0, len(segments)+1)
+		if gap := length - trackLength(segments); gap > 0 {
+			reversed = append(reversed, Segment{Duration: gap})
+		}
+		for i := len(segments) - 1; i >= 0; i-- {
+			reversed = append(reversed, segments[i])
+		}
+		tracks[motorId] = reversed
+	}
+	return build(tracks)
+}
+
+// Rotate moves every track steps motors around the ring.
+func Rotate(p *Pattern, steps, motorCount int) (*Pattern, error) {
+	if motorCount <= 0 {
+		return nil, fmt.Errorf("invalid number of motors: %d", motorCount)
+	}
+	return remap(p, func(motorId int) int { return motorId + steps }, motorCount)
+}
+
+// Mirror reflects the tracks across the axis through motor 0, so motor 1
+// swaps with the last motor, motor 2 with the one before it and so on.
+func Mirror(p *Pattern, motorCount int) (*Pattern, error) {
+	if motorCount <= 0 {
+		return nil, fmt.Errorf("invalid number of motors: %d", motorCount)
+	}
+	return remap(p, func(motorId int) int { return -motorId }, motorCount)
+}
+
+// remap moves every track to another motor of the ring. Motors outside the
+// ring would wrap onto another track, so they are an error.
+func remap(p *Pattern, to func(int) int, motorCount int) (*Pattern, error) {
+	result := make(map[int][]Segment)
+	for motorId, segments := range p.tracks() {
+		if motorId < 0 || motorId >= motorCount {
+			return nil, fmt.Errorf("motor %d is outside the ring of %d motors", motorId, motorCount)
+		}
+		target := ((to(motorId) % motorCount) + motorCount) % motorCount
+		result[target] = segments
+	}
+	return build(result), nil
+}
+
+// Crop keeps the part of a pattern between from and to. A to of 0 keeps
+// everything after from.
+func Crop(p *Pattern, from, to time.Duration) (*Pattern, error) {
+	start, end := int(from/time.Millisecond), int(to/time.Millisecond)
+	if end == 0 {
+		end = p.lengthMillis()
+	}
+	if start < 0 || end <= start {
+		return nil, fmt.Errorf("invalid crop range %s to %s", from, to)
+	}
+
+	tracks := p.tracks()
+	for motorId, segments := range tracks {
+		var cropped []Segment
+		at := 0
+		for _, s := range segments {
+			d := s.Duration
+			segStart, segEnd := max(at, start), min(at+d, end)
+			if segEnd > segStart {
+				s.Duration = segEnd - segStart
+				cropped = append(cropped, s)
+			}
+			at += d
+		}
+		tracks[motorId] = cropped
+	}
+	return build(tracks), nil
+}
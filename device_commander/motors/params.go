@@ -0,0 +1,201 @@
+package motors
+
+import (
+	"device_commander/comms"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Param is a motor parameter the SimpleFOC commander can get and set. The
+// board replies to the bare command with the value and sets it when the
+// command is followed by one.
+type Param struct {
+	Name        string  `json:"name"`
+	Command     string  `json:"command"`
+	Description string  `json:"description"`
+	Unit        string  `json:"unit,omitempty"`
+	Min         float64 `json:"min"`
+	Max         float64 `json:"max"`
+}
+
+// Params are the tunable parameters in the order they are shown, grouped by
+// controller.
+var Params = []Param{
+	{"velocity.p", "MVP", "Velocity PID proportional gain", "", 0, 100},
+	{"velocity.i", "MVI", "Velocity PID integral gain", "", 0, 1000},
+	{"velocity.d", "MVD", "Velocity PID derivative gain", "", 0, 10},
+	{"velocity.ramp", "MVR", "Velocity PID output ramp", "V/s", 0, 1e6},
+	{"velocity.limit", "MVL", "Velocity PID output limit", "V", 0, 100},
+	{"velocity.lpf", "MVF", "Velocity low pass filter time constant", "s", 0, 1},
+	{"angle.p", "MAP", "Angle P gain", "", 0, 100},
+	{"angle.limit", "MAL", "Angle controller output limit", "rad/s", 0, 100},
+	{"angle.lpf", "MAF", "Angle low pass filter time constant", "s", 0, 1},
+	{"current_q.p", "MQP", "Current q PID proportional gain", "", 0, 100},
+	{"current_q.i", "MQI", "Current q PID integral gain", "", 0, 10000},
+	{"current_q.d", "MQD", "Current q PID derivative gain", "", 0, 10},
+	{"current_q.ramp", "MQR", "Current q PID output ramp", "V/s", 0, 1e6},
+	{"current_q.lpf", "MQF", "Current q low pass filter time constant", "s", 0, 1},
+	{"limit.voltage", "MLU", "Voltage limit", "V", 0, 24},
+	{"limit.current", "MLC", "Current limit", "A", 0, 5},
+	{"limit.velocity", "MLV", "Velocity limit", "rad/s", 0, 100},
+}
+
+// LookupParam finds a parameter by name.
+func LookupParam(name string) (Param, error) {
+	for _, p := range Params {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return Param{}, fmt.Errorf("unknown motor parameter: %s", name)
+}
+
+// Validate checks that a value is in the parameter's range.
+func (p Param) Validate(value float64) error {
+	if value < p.Min || value > p.Max {
+		return fmt.Errorf("%s must be between %v and %v, got %v", p.Name, p.Min, p.Max, value)
+	}
+	return nil
+}
+
+var lastNumber = regexp.MustCompile(`-?\d+(?:\.\d+)?(?:[eE][-+]?\d+)?`)
+
+// parseParamReply finds the value in a board's reply, which is "PID vel| P:
+// 0.75" in verbose mode or a bare number otherwise. The firmware's echo of
+// the command and telemetry are skipped.
+func parseParamReply(output string) (float64, bool) {
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "K_MOT") {
+			continue
+		}
+		if _, ok := comms.ParseMonitorLine(line); ok {
+			continue
+		}
+		numbers := lastNumber.FindAllString(line, -1)
+		if len(numbers) == 0 {
+			continue
+		}
+		if v, err := strconv.ParseFloat(numbers[len(numbers)-1], 64); err == nil {
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+// replyTo returns the lines a board printed in reply to a command, which
+// come just before the firmware's "K_MOT: " echo of it. Replies to earlier
+// commands can still arrive after the output is cleared and come before
+// their own echo, so they are left out.
+func replyTo(output, command string) (string, bool) {
+	lines := strings.Split(output, "\n")
+	start := 0
+	for i, line := range lines {
+		echo, ok := strings.CutPrefix(strings.TrimSpace(line), "K_MOT:")
+		if !ok {
+			continue
+		}
+		if strings.TrimSpace(echo) == command {
+			return strings.Join(lines[start:i], "\n"), true
+		}
+		start = i + 1
+	}
+	return "", false
+}
+
+// query sends a command to a board and waits for a reply parse understands.
+// The reply is left to the reader goroutine, SendCommand's own read would
+// race it for the board's output.
+func query(index int, command string, parse func(string) (float64, bool), connectionsMutex *sync.Mutex, connections []*comms.SerialConnection) (float64, error) {
+	if index < 0 || index >= len(connections) {
+		return 0, fmt.Errorf("invalid motor ID: %d", index)
+	}
+	conn := connections[index]
+
+	connectionsMutex.Lock()
+	conn.Output = ""
+	connectionsMutex.Unlock()
+	comms.WriteCommand(command, connectionsMutex, connections, index)
+	for attempt := 0; attempt < 5; attempt++ {
+		connectionsMutex.Lock()
+		output := conn.Output
+		connectionsMutex.Unlock()
+
+		if reply, ok := replyTo(output, command); ok {
+			if v, ok := parse(reply); ok {
+				return v, nil
+			}
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	return 0, fmt.Errorf("no reply to %s from device %s", command, conn.DeviceID)
+}
+
+// GetParam reads a parameter from a board.
+func GetParam(index int, name string, connectionsMutex *sync.Mutex, connections []*comms.SerialConnection) (float64, error) {
+	p, err := LookupParam(name)
+	if err != nil {
+		return 0, err
+	}
+	return query(index, p.Command, parseParamReply, connectionsMutex, connections)
+}
+
+// SetParam sets a parameter on a board.
+func SetParam(index int, name string, value float64, connectionsMutex *sync.Mutex, connections []*comms.SerialConnection) error {
+	p, err := LookupParam(name)
+	if err != nil {
+		return err
+	}
+	if err := p.Validate(value); err != nil {
+		return err
+	}
+	if index < 0 || index >= len(connections) {
+		return fmt.Errorf("invalid motor ID: %d", index)
+	}
+	comms.WriteCommand(p.Command+strconv.FormatFloat(value, 'g', -1, 64), connectionsMutex, connections, index)
+	if p.Name == "limit.velocity" {
+		setVelocityLimit(deviceID(index, connectionsMutex, connections), value)
+	}
+	return nil
+}
+
+// ReadParams reads every parameter from a board.
+func ReadParams(index int, connectionsMutex *sync.Mutex, connections []*comms.SerialConnection) (map[string]float64, error) {
+	values := make(map[string]float64, len(Params))
+	for _, p := range Params {
+		v, err := GetParam(index, p.Name, connectionsMutex, connections)
+		if err != nil {
+			return values, err
+		}
+		values[p.Name] = v
+	}
+	return values, nil
+}
+
+// ApplyParams sets several parameters on a board, checking them all before
+// sending any.
+func ApplyParams(index int, values map[string]float64, connectionsMutex *sync.Mutex, connections []*comms.SerialConnection) error {
+	names := make([]string, 0, len(values))
+	for name, value := range values {
+		p, err := LookupParam(name)
+		if err != nil {
+			return err
+		}
+		if err := p.Validate(value); err != nil {
+			return err
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := SetParam(index, name, values[name], connectionsMutex, connections); err != nil {
+			return err
+		}
+	}
+	return nil
+}
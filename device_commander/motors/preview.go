@@ -0,0 +1,98 @@
+package motors
+
+import (
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"io"
+	"math"
+
+	"github.com/fogleman/gg"
+)
+
+// PreviewOptions controls the animated preview of a simulation.
+type PreviewOptions struct {
+	FPS  float64
+	Size int // Width and height in pixels
+}
+
+var DefaultPreviewOptions = PreviewOptions{
+	FPS:  20,
+	Size: 320,
+}
+
+// drawDials draws every motor as a dial on a ring, its hand at the motor's
+// angle and its rim coloured by speed, green when still through to red at
+// the speed limit. A motor with a violation in the last second gets a red
+// halo.
+func drawDials(s *Simulation, sample int, size float64, alerts map[int]bool) image.Image {
+	dc := gg.NewContext(int(size), int(size))
+	dc.SetRGB(0, 0, 0)
+	dc.Clear()
+
+	ring := size * 0.33
+	radius := size * 0.11
+	for i, trace := range s.Motors {
+		if sample >= len(trace.Samples) {
+			continue
+		}
+		state := trace.Samples[sample]
+		a := 2*math.Pi*float64(i)/float64(len(s.Motors)) - math.Pi/2
+		x, y := size/2+ring*math.Cos(a), size/2+ring*math.Sin(a)
+
+		if alerts[trace.MotorId] {
+			dc.SetRGB(0.8, 0, 0)
+			dc.DrawCircle(x, y, radius*1.25)
+			dc.Fill()
+		}
+		load := math.Min(1, math.Abs(state.Velocity)/s.Limits.MaxSpeed)
+		dc.SetRGB(load, 1-load, 0.2)
+		dc.DrawCircle(x, y, radius)
+		dc.Fill()
+		dc.SetRGB(0.1, 0.1, 0.1)
+		dc.DrawCircle(x, y, radius*0.85)
+		dc.Fill()
+
+		dc.SetRGB(1, 1, 1)
+		dc.SetLineWidth(2)
+		dc.DrawLine(x, y, x+radius*0.8*math.Cos(state.Angle-math.Pi/2), y+radius*0.8*math.Sin(state.Angle-math.Pi/2))
+		dc.Stroke()
+		dc.DrawStringAnchored(fmt.Sprint(trace.MotorId), x, y+radius*1.5, 0.5, 0.5)
+	}
+	return dc.Image()
+}
+
+// ExportPreviewGIF renders a simulation to an animated GIF of the motors
+// turning.
+func ExportPreviewGIF(w io.Writer, s *Simulation, opts PreviewOptions) error {
+	if opts.FPS <= 0 || opts.Size <= 0 {
+		return fmt.Errorf("invalid preview options: %v fps, %d pixels", opts.FPS, opts.Size)
+	}
+	if len(s.Motors) == 0 {
+		return fmt.Errorf("nothing to preview")
+	}
+
+	// Take every n-th sample to get close to the frame rate
+	every := max(1, int(1000/opts.FPS)/max(1, s.Step))
+	delay := max(1, every*s.Step/10)
+
+	out := &gif.GIF{}
+	for sample := 0; sample < len(s.Motors[0].Samples); sample += every {
+		now := s.Motors[0].Samples[sample].Time
+		alerts := make(map[int]bool)
+		for _, v := range s.Violations {
+			if v.At <= now && now-v.At < 1000 {
+				alerts[v.MotorId] = true
+			}
+		}
+
+		img := drawDials(s, sample, float64(opts.Size), alerts)
+		paletted := image.NewPaletted(img.Bounds(), palette.Plan9)
+		draw.Draw(paletted, img.Bounds(), img, image.Point{}, draw.Src)
+		out.Image = append(out.Image, paletted)
+		out.Delay = append(out.Delay, delay)
+	}
+	return gif.EncodeAll(w, out)
+}
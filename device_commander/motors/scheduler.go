@@ -2,49 +2,203 @@ package motors
 
 import (
 	"device_commander/comms"
+	"device_commander/logging"
+	"device_commander/metrics"
 	"fmt"
+	"math"
+	"strconv"
 	"sync"
 	"time"
 )
 
+var logger = logging.Logger(logging.Motors)
+
+// Segment control modes
+const (
+	ModeVelocity = "velocity" // Turn at Speed, the default
+	ModeAngle    = "angle"    // Go to Angle rad, at up to Speed if set
+	ModeRotate   = "rotate"   // Turn by Angle rad from where the motor is
+)
+
 type Segment struct {
 	Duration int     `json:"duration"`
 	Speed    float64 `json:"speed"`
+	Mode     string  `json:"mode,omitempty"`
+	Angle    float64 `json:"angle,omitempty"`
+}
+
+func (s Segment) mode() string {
+	if s.Mode == "" {
+		return ModeVelocity
+	}
+	return s.Mode
+}
+
+// positional reports whether the segment uses angle control.
+func (s Segment) positional() bool {
+	return s.Mode == ModeAngle || s.Mode == ModeRotate
+}
+
+// Target is what a segment asks of a motor once relative rotations are
+// resolved, at a time into the pattern.
+type Target struct {
+	At         time.Duration
+	Mode       string  // ModeVelocity or ModeAngle
+	Value      float64 // rad/s, or rad for ModeAngle
+	SpeedLimit float64 // Fastest way to an angle, 0 keeps the board's limit
+	SwitchMode bool    // The board has to change control mode first
+}
+
+// Stop is the target that stops a motor whatever mode it was in.
+func Stop(at time.Duration) Target {
+	return Target{At: at, Mode: ModeVelocity, SwitchMode: true}
+}
+
+type MotorPattern struct {
+	MotorId  int       `json:"motorId"`
+	Segments []Segment `json:"segments"`
 }
 
 type Pattern struct {
-	Patterns struct {
-		MotorId  int       `json:"motorId"`
-		Segments []Segment `json:"segments"`
-	} `json:"patterns"`
+	Patterns []MotorPattern `json:"patterns"`
 }
 
-func ScheduleMotorMovements(pattern *Pattern, connectionsMutex *sync.Mutex, connections []*comms.SerialConnection) error {
-	if pattern == nil {
-		return fmt.Errorf("pattern is nil")
+// Validate checks every segment has a known mode.
+func (p *Pattern) Validate() error {
+	for _, mp := range p.Patterns {
+		for i, segment := range mp.Segments {
+			switch segment.Mode {
+			case "", ModeVelocity, ModeAngle, ModeRotate:
+			default:
+				return fmt.Errorf("motor %d segment %d: unknown mode %q", mp.MotorId, i, segment.Mode)
+			}
+			if segment.Duration < 0 {
+				return fmt.Errorf("motor %d segment %d: negative duration", mp.MotorId, i)
+			}
+		}
 	}
+	return nil
+}
 
-	startTime := time.Now()
+// Positional reports whether any segment uses angle control.
+func (p *Pattern) Positional() bool {
+	for _, mp := range p.Patterns {
+		for _, segment := range mp.Segments {
+			if segment.positional() {
+				return true
+			}
+		}
+	}
+	return false
+}
 
-	go func() {
-		var elapsedTime time.Duration
-		for _, segment := range pattern.Patterns.Segments {
-			// Calculate when this segment should start
-			segmentStartTime := startTime.Add(elapsedTime)
+// Targets resolves a motor's segments into targets. Relative rotations are
+// turned into angles from the last angle target, moved on by the speed and
+// length of any velocity segments since; the board keeps no position
+// between control modes, so this is an estimate after velocity segments.
+// The board starts in velocity mode and is only told to switch when a
+// segment needs the other mode.
+func (mp MotorPattern) Targets() []Target {
+	targets := make([]Target, 0, len(mp.Segments))
+	var at time.Duration
+	var angle float64
+	mode := ModeVelocity
+	for _, segment := range mp.Segments {
+		t := Target{At: at, Mode: ModeVelocity, Value: segment.Speed}
+		switch segment.Mode {
+		case ModeAngle:
+			angle = segment.Angle
+			t = Target{At: at, Mode: ModeAngle, Value: angle, SpeedLimit: math.Abs(segment.Speed)}
+		case ModeRotate:
+			angle += segment.Angle
+			t = Target{At: at, Mode: ModeAngle, Value: angle, SpeedLimit: math.Abs(segment.Speed)}
+		default:
+			angle += segment.Speed * float64(segment.Duration) / 1000
+		}
+		t.SwitchMode = t.Mode != mode
+		mode = t.Mode
+		targets = append(targets, t)
+		at += time.Duration(segment.Duration) * time.Millisecond
+	}
+	return targets
+}
 
-			// Wait until it's time to execute this segment
-			time.Sleep(time.Until(segmentStartTime))
+// Length returns the time it takes to play the longest motor track.
+func (p *Pattern) Length() time.Duration {
+	var longest time.Duration
+	for _, mp := range p.Patterns {
+		var length time.Duration
+		for _, segment := range mp.Segments {
+			length += time.Duration(segment.Duration) * time.Millisecond
+		}
+		longest = max(longest, length)
+	}
+	return longest
+}
 
-			// Send command to move motor
-			err := MoveMotor(int64(pattern.Patterns.MotorId), float64(segment.Speed), connectionsMutex, connections)
-			if err != nil {
-				fmt.Printf("Error moving motor %d: %v\n", pattern.Patterns.MotorId, err)
+// Clock waits for segments to start, at times measured from the start of
+// the pattern.
+type Clock interface {
+	WaitUntil(at time.Duration)
+}
+
+type realClock struct {
+	start time.Time
+}
+
+// NewRealClock returns a clock that sleeps in real time from now.
+func NewRealClock() Clock {
+	return realClock{start: time.Now()}
+}
+
+func (c realClock) WaitUntil(at time.Duration) {
+	time.Sleep(time.Until(c.start.Add(at)))
+	ObserveLateness("pattern", time.Since(c.start.Add(at)))
+}
+
+var schedulerLateness = metrics.NewHistogram("device_commander_scheduler_lateness_seconds",
+	"How long after they were due scheduled moves and cues fired.", metrics.LatencyBuckets, "scheduler")
+
+// ObserveLateness records how late a scheduler fired something, for the
+// metrics.
+func ObserveLateness(scheduler string, late time.Duration) {
+	schedulerLateness.ObserveDuration(max(0, late), scheduler)
+}
+
+// MoveFunc sends a motor to a target.
+type MoveFunc func(motorId int, target Target) error
+
+// Play runs a pattern, one goroutine per motor, waiting on the clock before
+// each segment, and returns once every motor has finished. Real playback and
+// the simulator both go through here, they only differ in clock and move.
+func Play(pattern *Pattern, clock Clock, move MoveFunc) {
+	var wg sync.WaitGroup
+	for _, motorPattern := range pattern.Patterns {
+		wg.Add(1)
+		go func(mp MotorPattern) {
+			defer wg.Done()
+			for _, target := range mp.Targets() {
+				// Wait until it's time to execute this segment
+				clock.WaitUntil(target.At)
+
+				err := move(mp.MotorId, target)
+				if err != nil {
+					logger.Error("Error moving motor", "motor", mp.MotorId, "err", err)
+				}
 			}
+		}(motorPattern)
+	}
+	wg.Wait()
+}
 
-			// Update elapsed time
-			elapsedTime += time.Duration(segment.Duration) * time.Millisecond
-		}
-	}()
+func ScheduleMotorMovements(pattern *Pattern, connectionsMutex *sync.Mutex, connections []*comms.SerialConnection) error {
+	if pattern == nil {
+		return fmt.Errorf("pattern is nil")
+	}
+
+	go Play(pattern, NewRealClock(), func(motorId int, target Target) error {
+		return SendTarget(motorId, target, connectionsMutex, connections)
+	})
 
 	return nil
 }
@@ -61,6 +215,91 @@ func MoveMotor(motorId int64, velocity float64, connectionsMutex *sync.Mutex, co
 	return nil
 }
 
+// SendTarget sends a motor to a target, switching the board's control mode
+// with the SimpleFOC commander first if needed. It only writes the commands,
+// so playback isn't held up waiting for replies, except to read a board's
+// velocity limit the first time a target sets its own.
+func SendTarget(motorId int, target Target, connectionsMutex *sync.Mutex, connections []*comms.SerialConnection) error {
+	if motorId < 0 || motorId >= len(connections) {
+		return fmt.Errorf("invalid motor ID: %d", motorId)
+	}
+
+	if target.SwitchMode {
+		// Motion control types: 1 velocity, 2 angle
+		controller := 1
+		if target.Mode == ModeAngle {
+			controller = 2
+		}
+		comms.WriteCommand(fmt.Sprintf("MC%d", controller), connectionsMutex, connections, motorId)
+	}
+	if target.Mode == ModeAngle && target.SpeedLimit > 0 {
+		if err := saveVelocityLimit(motorId, connectionsMutex, connections); err != nil {
+			return err
+		}
+		comms.WriteCommand(fmt.Sprintf("MLV%.2f", target.SpeedLimit), connectionsMutex, connections, motorId)
+	} else if limit, ok := takeVelocityLimit(deviceID(motorId, connectionsMutex, connections)); ok {
+		comms.WriteCommand("MLV"+strconv.FormatFloat(limit, 'g', -1, 64), connectionsMutex, connections, motorId)
+	}
+	comms.WriteCommand(fmt.Sprintf("M%.2f", target.Value), connectionsMutex, connections, motorId)
+	return nil
+}
+
+// velocityLimits keeps each board's own velocity limit while angle targets
+// run it at theirs, so the next target without a limit gets it back.
+var velocityLimits = struct {
+	sync.Mutex
+	saved   map[string]float64 // The board's limit, by device
+	changed map[string]bool    // A target's limit is in place of it
+}{saved: make(map[string]float64), changed: make(map[string]bool)}
+
+func deviceID(index int, connectionsMutex *sync.Mutex, connections []*comms.SerialConnection) string {
+	connectionsMutex.Lock()
+	defer connectionsMutex.Unlock()
+	return connections[index].DeviceID
+}
+
+// saveVelocityLimit reads a board's limit before a target changes it, once
+// per device.
+func saveVelocityLimit(index int, connectionsMutex *sync.Mutex, connections []*comms.SerialConnection) error {
+	id := deviceID(index, connectionsMutex, connections)
+	velocityLimits.Lock()
+	_, saved := velocityLimits.saved[id]
+	velocityLimits.Unlock()
+	if !saved {
+		limit, err := GetParam(index, "limit.velocity", connectionsMutex, connections)
+		if err != nil {
+			return fmt.Errorf("error reading the velocity limit to restore: %w", err)
+		}
+		velocityLimits.Lock()
+		velocityLimits.saved[id] = limit
+		velocityLimits.Unlock()
+	}
+
+	velocityLimits.Lock()
+	velocityLimits.changed[id] = true
+	velocityLimits.Unlock()
+	return nil
+}
+
+// takeVelocityLimit returns the board's own limit if a target changed it.
+func takeVelocityLimit(id string) (float64, bool) {
+	velocityLimits.Lock()
+	defer velocityLimits.Unlock()
+	if !velocityLimits.changed[id] {
+		return 0, false
+	}
+	velocityLimits.changed[id] = false
+	return velocityLimits.saved[id], true
+}
+
+// setVelocityLimit records a limit set as a parameter as the board's own.
+func setVelocityLimit(id string, limit float64) {
+	velocityLimits.Lock()
+	defer velocityLimits.Unlock()
+	velocityLimits.saved[id] = limit
+	velocityLimits.changed[id] = false
+}
+
 // func main() {
 // 	filename := "pink_panther.json" // Assuming the JSON file is in the same directory
 // 	err := ScheduleMotorMovements(filename)
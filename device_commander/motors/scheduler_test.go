@@ -0,0 +1,54 @@
+package motors
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestSendTargetRestoresVelocityLimit(t *testing.T) {
+	var connectionsMutex sync.Mutex
+	connections := openEmulatedBoard(t, "limit", &connectionsMutex)
+	limit := func() float64 {
+		t.Helper()
+		v, err := GetParam(0, "limit.velocity", &connectionsMutex, connections)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	send := func(target Target) {
+		t.Helper()
+		if err := SendTarget(0, target, &connectionsMutex, connections); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	own := limit()
+	steps := []struct {
+		name   string
+		target Target
+		want   float64
+	}{
+		{"angle with a limit", Target{Mode: ModeAngle, Value: 1, SpeedLimit: 4, SwitchMode: true}, 4},
+		{"angle without a limit", Target{Mode: ModeAngle, Value: 2}, own},
+		{"angle with another limit", Target{Mode: ModeAngle, Value: 1, SpeedLimit: 2}, 2},
+		{"velocity", Target{Mode: ModeVelocity, Value: 3, SwitchMode: true}, own},
+		{"stop", Stop(0), own},
+	}
+	for _, step := range steps {
+		send(step.target)
+		if got := limit(); got != step.want {
+			t.Errorf("after %s the limit is %v, want %v", step.name, got, step.want)
+		}
+	}
+
+	// A limit set as a parameter becomes the board's own
+	if err := SetParam(0, "limit.velocity", 12, &connectionsMutex, connections); err != nil {
+		t.Fatal(err)
+	}
+	send(Target{Mode: ModeAngle, Value: 1, SpeedLimit: 4, SwitchMode: true})
+	send(Stop(0))
+	if got := limit(); got != 12 {
+		t.Errorf("after a limited angle the limit is %v, want the 12 set before", got)
+	}
+}
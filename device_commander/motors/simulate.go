@@ -0,0 +1,228 @@
+package motors
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Limits are what a motor can safely do.
+type Limits struct {
+	MaxSpeed        float64 `json:"maxSpeed"`        // rad/s, faster commands are clipped by the firmware
+	MaxAcceleration float64 `json:"maxAcceleration"` // rad/s²
+	MaxAngle        float64 `json:"maxAngle"`        // rad either way from the start, 0 for no limit
+}
+
+// SimpleFOC's default velocity limit, the firmware doesn't set its own
+var DefaultLimits = Limits{
+	MaxSpeed:        20,
+	MaxAcceleration: 100,
+}
+
+func (l Limits) Validate() error {
+	if l.MaxSpeed <= 0 || l.MaxAcceleration <= 0 {
+		return fmt.Errorf("invalid limits: speed %v, acceleration %v", l.MaxSpeed, l.MaxAcceleration)
+	}
+	if l.MaxAngle < 0 {
+		return fmt.Errorf("invalid angle limit: %v", l.MaxAngle)
+	}
+	return nil
+}
+
+// Kinds of violation
+const (
+	ViolationSpeed   = "speed"   // Commanded faster than MaxSpeed
+	ViolationLag     = "lag"     // Next segment started before the motor reached its speed
+	ViolationAngle   = "angle"   // Turned past MaxAngle
+	ViolationRunning = "running" // Still turning when the pattern ends
+)
+
+type Violation struct {
+	MotorId int    `json:"motorId"`
+	At      int    `json:"at"` // Milliseconds
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+type Sample struct {
+	Time     int     `json:"t"` // Milliseconds
+	Mode     string  `json:"mode"`
+	Target   float64 `json:"target"` // rad/s, or rad in angle mode
+	Velocity float64 `json:"velocity"`
+	Angle    float64 `json:"angle"`
+}
+
+type MotorTrace struct {
+	MotorId  int      `json:"motorId"`
+	Samples  []Sample `json:"samples"`
+	Travel   float64  `json:"travel"` // Total distance turned either way, rad
+	MaxAngle float64  `json:"maxAngle"`
+}
+
+// Simulation is the result of playing a pattern against the kinematic
+// model.
+type Simulation struct {
+	Step       int          `json:"step"` // Milliseconds between samples
+	Limits     Limits       `json:"limits"`
+	Motors     []MotorTrace `json:"motors"`
+	Violations []Violation  `json:"violations"`
+}
+
+// instantClock lets the simulator run a pattern as fast as it can.
+type instantClock struct{}
+
+func (instantClock) WaitUntil(time.Duration) {}
+
+// Simulate plays a pattern through the scheduler without motors, then
+// integrates each motor's velocity into an angle, ramping between speeds at
+// the acceleration limit. Angle targets are approached as fast as the speed
+// and acceleration limits allow, braking in time to stop on the target.
+func Simulate(pattern *Pattern, limits Limits, step time.Duration) (*Simulation, error) {
+	if err := limits.Validate(); err != nil {
+		return nil, err
+	}
+	if step <= 0 {
+		return nil, fmt.Errorf("invalid simulation step: %s", step)
+	}
+
+	var mu sync.Mutex
+	commands := make(map[int][]Target)
+	Play(pattern, instantClock{}, func(motorId int, target Target) error {
+		mu.Lock()
+		commands[motorId] = append(commands[motorId], target)
+		mu.Unlock()
+		return nil
+	})
+
+	motorIds := make([]int, 0, len(commands))
+	for motorId := range commands {
+		motorIds = append(motorIds, motorId)
+	}
+	sort.Ints(motorIds)
+
+	sim := &Simulation{Step: int(step / time.Millisecond), Limits: limits}
+	for _, motorId := range motorIds {
+		trace, violations := simulateMotor(motorId, commands[motorId], pattern.Length(), limits, step)
+		sim.Motors = append(sim.Motors, trace)
+		sim.Violations = append(sim.Violations, violations...)
+	}
+	sort.SliceStable(sim.Violations, func(i, j int) bool { return sim.Violations[i].At < sim.Violations[j].At })
+	return sim, nil
+}
+
+func simulateMotor(motorId int, commands []Target, length time.Duration, limits Limits, step time.Duration) (MotorTrace, []Violation) {
+	trace := MotorTrace{MotorId: motorId}
+	var violations []Violation
+	flag := func(at time.Duration, kind, format string, args ...interface{}) {
+		violations = append(violations, Violation{
+			MotorId: motorId,
+			At:      int(at / time.Millisecond),
+			Kind:    kind,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+	clip := func(speed, limit float64) float64 {
+		return max(-limit, min(limit, speed))
+	}
+
+	dt := step.Seconds()
+	var velocity, angle float64
+	target := Target{Mode: ModeVelocity}
+	var lastAt time.Duration
+	overAngle := false
+	next := 0
+	for t := time.Duration(0); ; t += step {
+		// Take every command due by now
+		for next < len(commands) && commands[next].At <= t {
+			c := commands[next]
+			next++
+			// Segments of no length don't give the motor time to get anywhere
+			if c.At > lastAt {
+				if target.Mode == ModeAngle && math.Abs(angle-target.Value) > 0.01 {
+					flag(c.At, ViolationLag, "reached %.2f of %.2f rad before the next segment", angle, target.Value)
+				}
+				if clipped := clip(target.Value, limits.MaxSpeed); target.Mode == ModeVelocity && math.Abs(velocity-clipped) > 0.01 {
+					flag(c.At, ViolationLag, "reached %.2f of %.2f rad/s before the next segment", velocity, clipped)
+				}
+			}
+			target, lastAt = c, c.At
+			if target.Mode == ModeVelocity && math.Abs(target.Value) > limits.MaxSpeed {
+				flag(c.At, ViolationSpeed, "commanded %.2f rad/s, limit is %.2f", target.Value, limits.MaxSpeed)
+			}
+			if target.Mode == ModeAngle && target.SpeedLimit > limits.MaxSpeed {
+				flag(c.At, ViolationSpeed, "commanded up to %.2f rad/s, limit is %.2f", target.SpeedLimit, limits.MaxSpeed)
+			}
+			if target.Mode == ModeAngle && limits.MaxAngle > 0 && math.Abs(target.Value) > limits.MaxAngle {
+				flag(c.At, ViolationAngle, "commanded to %.2f rad, limit is %.2f", target.Value, limits.MaxAngle)
+			}
+		}
+
+		trace.Samples = append(trace.Samples, Sample{
+			Time:     int(t / time.Millisecond),
+			Mode:     target.Mode,
+			Target:   target.Value,
+			Velocity: velocity,
+			Angle:    angle,
+		})
+		if t >= length {
+			break
+		}
+
+		// Clipped to the speed limit like the firmware would
+		want := clip(target.Value, limits.MaxSpeed)
+		if target.Mode == ModeAngle {
+			speedLimit := limits.MaxSpeed
+			if target.SpeedLimit > 0 {
+				speedLimit = min(speedLimit, target.SpeedLimit)
+			}
+			// Fastest speed that can still brake in time
+			remaining := target.Value - angle
+			braking := math.Sqrt(2 * limits.MaxAcceleration * math.Abs(remaining))
+			want = math.Copysign(min(speedLimit, braking, math.Abs(remaining)/dt), remaining)
+		}
+		maxChange := limits.MaxAcceleration * dt
+		newVelocity := velocity + clip(want-velocity, maxChange)
+		moved := (velocity + newVelocity) / 2 * dt
+		velocity = newVelocity
+		angle += moved
+		trace.Travel += math.Abs(moved)
+		trace.MaxAngle = max(trace.MaxAngle, math.Abs(angle))
+
+		if limits.MaxAngle > 0 {
+			if !overAngle && math.Abs(angle) > limits.MaxAngle {
+				flag(t+step, ViolationAngle, "turned to %.2f rad, limit is %.2f", angle, limits.MaxAngle)
+			}
+			overAngle = math.Abs(angle) > limits.MaxAngle
+		}
+	}
+	if target.Mode == ModeVelocity && target.Value != 0 {
+		flag(length, ViolationRunning, "still commanded to %.2f rad/s when the pattern ends", target.Value)
+	}
+	return trace, violations
+}
+
+// WriteCSV writes the traces as one row per motor per sample.
+func (s *Simulation) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"t", "motor", "mode", "target", "velocity", "angle"})
+	format := func(v float64) string { return strconv.FormatFloat(v, 'f', 4, 64) }
+	for _, trace := range s.Motors {
+		for _, sample := range trace.Samples {
+			cw.Write([]string{
+				strconv.Itoa(sample.Time),
+				strconv.Itoa(trace.MotorId),
+				sample.Mode,
+				format(sample.Target),
+				format(sample.Velocity),
+				format(sample.Angle),
+			})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
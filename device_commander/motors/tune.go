@@ -0,0 +1,259 @@
+package motors
+
+import (
+	"device_commander/comms"
+	"device_commander/telemetry"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// StepSample is one line of monitored target and velocity.
+type StepSample struct {
+	At       time.Duration `json:"-"`
+	Time     int           `json:"t"` // Milliseconds from the start of the capture
+	Target   float64       `json:"target"`
+	Velocity float64       `json:"velocity"`
+}
+
+// StepMetrics describe how a motor followed a velocity step.
+type StepMetrics struct {
+	From         float64 `json:"from"`
+	To           float64 `json:"to"`
+	RiseTime     int     `json:"riseTime"`     // Milliseconds from 10% to 90% of the step
+	Overshoot    float64 `json:"overshoot"`    // Past the target, as a fraction of the step
+	SettlingTime int     `json:"settlingTime"` // Milliseconds until it stays within 5% of the step
+	SteadyError  float64 `json:"steadyError"`  // Mean error over the last fifth of the capture, rad/s
+	Settled      bool    `json:"settled"`      // Whether it settled before the capture ended
+}
+
+// Score ranks step responses, lower is better: slow settling, overshoot and
+// error that remains all count against it.
+func (m StepMetrics) Score() float64 {
+	step := math.Max(math.Abs(m.To-m.From), 1e-9)
+	score := float64(m.SettlingTime) + 2000*m.Overshoot + 1000*m.SteadyError/step
+	if !m.Settled {
+		score += 1000
+	}
+	return score
+}
+
+const settleBand = 0.05
+
+// AnalyzeStep measures a step response from samples that start before the
+// step. The step is where the monitored target first reaches to.
+func AnalyzeStep(samples []StepSample, from, to float64) (StepMetrics, error) {
+	m := StepMetrics{From: from, To: to}
+	step := to - from
+	if step == 0 {
+		return m, fmt.Errorf("step from %v to %v goes nowhere", from, to)
+	}
+
+	start := -1
+	for i, s := range samples {
+		if math.Abs(s.Target-to) < 1e-3 {
+			start = i
+			break
+		}
+	}
+	if start <= 0 {
+		return m, fmt.Errorf("no step in %d samples", len(samples))
+	}
+	after := samples[start:]
+	t0 := after[0].At
+	end := after[len(after)-1].At - t0
+
+	progress := func(s StepSample) float64 { return (s.Velocity - from) / step }
+	var t10, t90 time.Duration = -1, -1
+	peak := 0.0
+	lastOutside := -1
+	for i, s := range after {
+		p := progress(s)
+		if t10 < 0 && p >= 0.1 {
+			t10 = s.At - t0
+		}
+		if t90 < 0 && p >= 0.9 {
+			t90 = s.At - t0
+		}
+		peak = math.Max(peak, p)
+		if math.Abs(p-1) > settleBand {
+			lastOutside = i
+		}
+	}
+
+	if t10 >= 0 && t90 >= 0 {
+		m.RiseTime = int((t90 - t10) / time.Millisecond)
+	} else {
+		m.RiseTime = int(end / time.Millisecond)
+	}
+	m.Overshoot = math.Max(0, peak-1)
+	switch {
+	case lastOutside < 0:
+		m.Settled = true
+	case lastOutside < len(after)-1:
+		m.Settled = true
+		m.SettlingTime = int((after[lastOutside+1].At - t0) / time.Millisecond)
+	default:
+		m.SettlingTime = int(end / time.Millisecond)
+	}
+
+	tail := after[len(after)*4/5:]
+	for _, s := range tail {
+		m.SteadyError += math.Abs(s.Velocity-to) / float64(len(tail))
+	}
+	return m, nil
+}
+
+// Step responses are captured from the target and velocity, faster than
+// telemetry usually runs
+const (
+	stepVariables  = telemetry.Target | telemetry.Velocity
+	stepDownsample = 10
+)
+
+// MeasureStep runs a board at from rad/s, steps it to to and captures the
+// monitored target and velocity for duration after the step. It leaves the
+// motor stopped and monitoring off.
+func MeasureStep(index int, from, to float64, duration time.Duration, connectionsMutex *sync.Mutex, connections []*comms.SerialConnection) ([]StepSample, StepMetrics, error) {
+	if index < 0 || index >= len(connections) {
+		return nil, StepMetrics{}, fmt.Errorf("invalid motor ID: %d", index)
+	}
+	conn := connections[index]
+	send := func(command string) {
+		comms.WriteCommand(command, connectionsMutex, connections, index)
+	}
+
+	var mu sync.Mutex
+	var arrivals []time.Time
+	var samples []StepSample
+	stop := comms.Listen(conn, func(line string, at time.Time) {
+		s, ok := telemetry.ParseLine(line, stepVariables, at)
+		if !ok {
+			return
+		}
+		mu.Lock()
+		arrivals = append(arrivals, at)
+		samples = append(samples, StepSample{Target: s.Target, Velocity: s.Velocity})
+		mu.Unlock()
+	})
+
+	send("MC1")
+	send(fmt.Sprintf("M%.2f", from))
+	time.Sleep(time.Second)
+	telemetry.Enable(index, stepVariables, stepDownsample, connectionsMutex, connections)
+	time.Sleep(200 * time.Millisecond)
+	send(fmt.Sprintf("M%.2f", to))
+	time.Sleep(duration)
+	telemetry.Disable(index, connectionsMutex, connections)
+	time.Sleep(50 * time.Millisecond)
+	stop()
+	send("M0")
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(samples) < 10 {
+		return samples, StepMetrics{}, fmt.Errorf("only %d telemetry samples from device %s", len(samples), conn.DeviceID)
+	}
+	// Lines arrive in bursts, but the board sends them at a steady rate
+	period := arrivals[len(arrivals)-1].Sub(arrivals[0]) / time.Duration(len(samples)-1)
+	for i := range samples {
+		samples[i].At = time.Duration(i) * period
+		samples[i].Time = int(samples[i].At / time.Millisecond)
+	}
+	metrics, err := AnalyzeStep(samples, from, to)
+	return samples, metrics, err
+}
+
+// TuneOptions control the velocity loop tuning.
+type TuneOptions struct {
+	From         float64       `json:"from"` // rad/s
+	To           float64       `json:"to"`
+	Duration     time.Duration `json:"-"`
+	Iterations   int           `json:"iterations"`
+	MaxOvershoot float64       `json:"maxOvershoot"` // Fraction of the step
+}
+
+var DefaultTuneOptions = TuneOptions{
+	From:         0,
+	To:           10,
+	Duration:     1500 * time.Millisecond,
+	Iterations:   8,
+	MaxOvershoot: 0.1,
+}
+
+// TuneTrial is one step response with the gains it was measured with.
+type TuneTrial struct {
+	P       float64     `json:"p"`
+	I       float64     `json:"i"`
+	Metrics StepMetrics `json:"metrics"`
+	Score   float64     `json:"score"`
+}
+
+type TuneResult struct {
+	Trials []TuneTrial `json:"trials"`
+	Best   TuneTrial   `json:"best"`
+}
+
+// Gains returns the best gains as motor parameters, ready to be saved to
+// the device's profile.
+func (r *TuneResult) Gains() map[string]float64 {
+	return map[string]float64{"velocity.p": r.Best.P, "velocity.i": r.Best.I}
+}
+
+// nextGains adjusts the velocity PI gains after a step: back off both when
+// it overshoots, add integral when it creeps up to the target, and push the
+// proportional gain when it is clean but could be quicker.
+func nextGains(p, i float64, m StepMetrics, maxOvershoot float64) (float64, float64) {
+	step := math.Abs(m.To - m.From)
+	switch {
+	case m.Overshoot > maxOvershoot:
+		return p * 0.8, i * 0.6
+	case m.SteadyError > settleBand*step || !m.Settled || m.SettlingTime > 3*m.RiseTime:
+		return p, i * 1.5
+	default:
+		return p * 1.3, i
+	}
+}
+
+// TuneVelocity tunes a board's velocity PI gains by step responses,
+// starting from the gains it has. It leaves the board with the best gains it
+// found, which the caller can save.
+func TuneVelocity(index int, opts TuneOptions, connectionsMutex *sync.Mutex, connections []*comms.SerialConnection) (*TuneResult, error) {
+	if opts.To == opts.From || opts.Iterations <= 0 || opts.Duration <= 0 {
+		return nil, fmt.Errorf("invalid tuning options: %+v", opts)
+	}
+	pParam, _ := LookupParam("velocity.p")
+	iParam, _ := LookupParam("velocity.i")
+
+	p, err := GetParam(index, pParam.Name, connectionsMutex, connections)
+	if err != nil {
+		return nil, err
+	}
+	i, err := GetParam(index, iParam.Name, connectionsMutex, connections)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &TuneResult{}
+	for n := 0; n < opts.Iterations; n++ {
+		p = math.Max(pParam.Min, math.Min(pParam.Max, p))
+		i = math.Max(iParam.Min, math.Min(iParam.Max, i))
+		if err := ApplyParams(index, map[string]float64{pParam.Name: p, iParam.Name: i}, connectionsMutex, connections); err != nil {
+			return result, err
+		}
+
+		_, metrics, err := MeasureStep(index, opts.From, opts.To, opts.Duration, connectionsMutex, connections)
+		if err != nil {
+			return result, err
+		}
+		trial := TuneTrial{P: p, I: i, Metrics: metrics, Score: metrics.Score()}
+		result.Trials = append(result.Trials, trial)
+		if n == 0 || trial.Score < result.Best.Score {
+			result.Best = trial
+		}
+		p, i = nextGains(p, i, metrics, opts.MaxOvershoot)
+	}
+
+	return result, ApplyParams(index, result.Gains(), connectionsMutex, connections)
+}
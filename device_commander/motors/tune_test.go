@@ -0,0 +1,137 @@
+package motors
+
+import (
+	"device_commander/comms"
+	"device_commander/emulator"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stepSamples builds a capture every 10 ms: three samples before a step
+// from 0 to 10, then the given velocities.
+func stepSamples(velocities ...float64) []StepSample {
+	var samples []StepSample
+	for i := 0; i < 3; i++ {
+		samples = append(samples, StepSample{})
+	}
+	for _, v := range velocities {
+		samples = append(samples, StepSample{Target: 10, Velocity: v})
+	}
+	for i := range samples {
+		samples[i].At = time.Duration(i) * 10 * time.Millisecond
+	}
+	return samples
+}
+
+func TestAnalyzeStep(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []StepSample
+		want    StepMetrics
+	}{
+		{
+			name:    "overshoot",
+			samples: stepSamples(0, 2, 4, 6, 8, 10, 11, 10.3, 10, 10, 10, 10, 10, 10, 10),
+			want:    StepMetrics{From: 0, To: 10, RiseTime: 40, Overshoot: 0.1, SettlingTime: 70, Settled: true},
+		},
+		{
+			name:    "never settles",
+			samples: stepSamples(0, 1, 2, 3, 4, 5, 6, 7, 8, 8.5),
+			want:    StepMetrics{From: 0, To: 10, RiseTime: 90, SettlingTime: 90, SteadyError: 1.75},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := AnalyzeStep(test.samples, 0, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(got.Overshoot-test.want.Overshoot) > 1e-9 || math.Abs(got.SteadyError-test.want.SteadyError) > 1e-9 {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+			got.Overshoot, got.SteadyError = test.want.Overshoot, test.want.SteadyError
+			if got != test.want {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAnalyzeStepErrors(t *testing.T) {
+	if _, err := AnalyzeStep(stepSamples(0, 5, 10), 3, 3); err == nil {
+		t.Error("no error for a step that goes nowhere")
+	}
+	if _, err := AnalyzeStep(stepSamples(0, 5, 10), 0, 20); err == nil {
+		t.Error("no error for a capture without the step")
+	}
+}
+
+// openEmulatedBoard connects to an emulated board and initializes its motor,
+// the way the tune command does.
+func openEmulatedBoard(t *testing.T, deviceID string, connectionsMutex *sync.Mutex) []*comms.SerialConnection {
+	t.Helper()
+	conn := &comms.SerialConnection{Port: emulator.NewBoard("TEST" + deviceID), PortName: "emulated", DeviceID: deviceID}
+	t.Cleanup(func() { conn.Port.Close() })
+	connections := []*comms.SerialConnection{conn}
+
+	updates := make(chan comms.ScreenUpdate, 100)
+	go func() {
+		for range updates {
+		}
+	}()
+	go comms.ReadSerialOutput(conn, updates, connections, connectionsMutex)
+
+	comms.WriteCommand("I", connectionsMutex, connections, 0)
+	for attempt := 0; attempt < 50; attempt++ {
+		connectionsMutex.Lock()
+		output := conn.Output
+		connectionsMutex.Unlock()
+		if strings.Contains(output, "K_RUNNING") {
+			return connections
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatal("emulated board did not initialize")
+	return nil
+}
+
+func TestTuneVelocityEmulated(t *testing.T) {
+	if testing.Short() {
+		t.Skip("steps an emulated motor in real time")
+	}
+	var connectionsMutex sync.Mutex
+	connections := openEmulatedBoard(t, "tune", &connectionsMutex)
+
+	opts := DefaultTuneOptions
+	opts.Duration = 500 * time.Millisecond
+	opts.Iterations = 2
+	result, err := TuneVelocity(0, opts, &connectionsMutex, connections)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Trials) != opts.Iterations {
+		t.Fatalf("got %d trials, want %d", len(result.Trials), opts.Iterations)
+	}
+	for _, trial := range result.Trials {
+		if trial.Score < result.Best.Score {
+			t.Errorf("trial %+v scores better than the best %+v", trial, result.Best)
+		}
+		if trial.Metrics.RiseTime <= 0 {
+			t.Errorf("trial %+v has no rise time", trial)
+		}
+	}
+
+	for name, want := range result.Gains() {
+		got, err := GetParam(0, name, &connectionsMutex, connections)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(got-want) > 1e-3 {
+			t.Errorf("board has %s %v, want the best %v", name, got, want)
+		}
+	}
+}
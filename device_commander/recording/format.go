@@ -0,0 +1,130 @@
+// Package recording records the traffic of the device links to a compact
+// file and plays it back, through the same reader and parsers or in place of
+// the boards, so field bugs can be reproduced.
+//
+// A recording starts with a magic line, followed by records of a kind byte,
+// the microseconds since the previous record, the port's index and a length
+// prefixed payload, all numbers as uvarints. A port record declares the
+// port's name the first time it is used, in and out records carry the bytes
+// read from and written to it.
+package recording
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+const magic = "DCSER1\n"
+
+type Direction byte
+
+const (
+	kindPort Direction = iota
+	In                 // From the board
+	Out                // To the board
+)
+
+func (d Direction) String() string {
+	switch d {
+	case In:
+		return "in"
+	case Out:
+		return "out"
+	}
+	return "port"
+}
+
+// Event is a chunk of bytes that went one way over a link.
+type Event struct {
+	At        time.Duration // Since the recording started
+	Port      string
+	Direction Direction
+	Data      []byte
+}
+
+// Recording is a whole session, its events in the order they happened.
+type Recording struct {
+	Ports  []string
+	Events []Event
+}
+
+// Read reads a recording. A recording cut short, e.g. by a crash, is read
+// up to its last complete record.
+func Read(r io.Reader) (*Recording, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(magic))
+	if _, err := io.ReadFull(br, header); err != nil || string(header) != magic {
+		return nil, fmt.Errorf("not a serial recording")
+	}
+
+	rec := &Recording{}
+	var at time.Duration
+	for {
+		kind, err := br.ReadByte()
+		if err == io.EOF {
+			return rec, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		dt, err1 := binary.ReadUvarint(br)
+		index, err2 := binary.ReadUvarint(br)
+		length, err3 := binary.ReadUvarint(br)
+		if err := errors.Join(err1, err2, err3); err != nil {
+			return rec, nil
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return rec, nil
+		}
+		at += time.Duration(dt) * time.Microsecond
+
+		switch Direction(kind) {
+		case kindPort:
+			if int(index) != len(rec.Ports) {
+				return nil, fmt.Errorf("port %d declared out of order", index)
+			}
+			rec.Ports = append(rec.Ports, string(data))
+		case In, Out:
+			if int(index) >= len(rec.Ports) {
+				return nil, fmt.Errorf("undeclared port %d", index)
+			}
+			rec.Events = append(rec.Events, Event{At: at, Port: rec.Ports[index], Direction: Direction(kind), Data: data})
+		default:
+			return nil, fmt.Errorf("unknown record kind %d", kind)
+		}
+	}
+}
+
+// Load reads a recording from a file.
+func Load(path string) (*Recording, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// Length is when the last event happened.
+func (r *Recording) Length() time.Duration {
+	if len(r.Events) == 0 {
+		return 0
+	}
+	return r.Events[len(r.Events)-1].At
+}
+
+// Dump writes the events in a readable form, one per line.
+func (r *Recording) Dump(w io.Writer) error {
+	for _, e := range r.Events {
+		if _, err := fmt.Fprintf(w, "%12s %-14s %-3s %q\n", e.At.Round(time.Microsecond), e.Port, e.Direction, e.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
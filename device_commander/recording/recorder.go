@@ -0,0 +1,140 @@
+package recording
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// Recorder writes the traffic of every port it wraps to one recording.
+type Recorder struct {
+	mu     sync.Mutex
+	w      *bufio.Writer
+	closer io.Closer
+	start  time.Time
+	last   time.Time
+	ports  map[string]uint64
+	err    error
+}
+
+// NewRecorder starts a recording.
+func NewRecorder(w io.Writer) (*Recorder, error) {
+	r := &Recorder{w: bufio.NewWriter(w), start: time.Now(), ports: make(map[string]uint64)}
+	r.last = r.start
+	if _, err := r.w.WriteString(magic); err != nil {
+		return nil, err
+	}
+	return r, r.w.Flush()
+}
+
+// Create starts a recording to a file.
+func Create(path string) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewRecorder(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r.closer = f
+	return r, nil
+}
+
+// Close finishes the recording. Wrapped ports keep working unrecorded.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	err := r.w.Flush()
+	if r.closer != nil {
+		if cerr := r.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if r.err == nil {
+		r.err = os.ErrClosed
+	}
+	return err
+}
+
+// record writes one record and flushes it, so a crash loses at most the
+// record being written.
+func (r *Recorder) record(kind Direction, port string, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+
+	index, ok := r.ports[port]
+	if !ok {
+		index = uint64(len(r.ports))
+		r.ports[port] = index
+		r.write(kindPort, index, []byte(port))
+	}
+	r.write(kind, index, data)
+	if err := r.w.Flush(); err != nil {
+		r.err = err
+	}
+}
+
+func (r *Recorder) write(kind Direction, index uint64, data []byte) {
+	now := time.Now()
+	dt := now.Sub(r.last)
+	r.last = now
+
+	var header [1 + 3*binary.MaxVarintLen64]byte
+	header[0] = byte(kind)
+	n := 1
+	n += binary.PutUvarint(header[n:], uint64(dt/time.Microsecond))
+	n += binary.PutUvarint(header[n:], index)
+	n += binary.PutUvarint(header[n:], uint64(len(data)))
+	r.w.Write(header[:n])
+	r.w.Write(data)
+}
+
+// Wrap returns a port that records everything read from and written to
+// port under name.
+func (r *Recorder) Wrap(name string, port serial.Port) serial.Port {
+	return &recordedPort{Port: port, name: name, recorder: r}
+}
+
+// Opener wraps a function that opens ports so every port it opens is
+// recorded, e.g. comms.OpenPort.
+func (r *Recorder) Opener(open func(string, *serial.Mode) (serial.Port, error)) func(string, *serial.Mode) (serial.Port, error) {
+	return func(name string, mode *serial.Mode) (serial.Port, error) {
+		port, err := open(name, mode)
+		if err != nil {
+			return nil, err
+		}
+		return r.Wrap(name, port), nil
+	}
+}
+
+type recordedPort struct {
+	serial.Port
+	name     string
+	recorder *Recorder
+}
+
+func (p *recordedPort) Read(b []byte) (int, error) {
+	n, err := p.Port.Read(b)
+	if n > 0 {
+		p.recorder.record(In, p.name, b[:n])
+	}
+	return n, err
+}
+
+func (p *recordedPort) Write(b []byte) (int, error) {
+	n, err := p.Port.Write(b)
+	if n > 0 {
+		p.recorder.record(Out, p.name, b[:n])
+	}
+	return n, err
+}
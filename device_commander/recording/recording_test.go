@@ -0,0 +1,164 @@
+package recording
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// scriptedPort is a board that answers each write with the next reply.
+type scriptedPort struct {
+	serial.Port
+	replies [][]byte
+	pending []byte
+}
+
+func (p *scriptedPort) Write(b []byte) (int, error) {
+	if len(p.replies) > 0 {
+		p.pending = append(p.pending, p.replies[0]...)
+		p.replies = p.replies[1:]
+	}
+	return len(b), nil
+}
+
+func (p *scriptedPort) Read(b []byte) (int, error) {
+	n := copy(b, p.pending)
+	p.pending = p.pending[n:]
+	return n, nil
+}
+
+// exchange writes a command to a port and reads its reply.
+func exchange(t *testing.T, port serial.Port, command string) string {
+	t.Helper()
+	if _, err := port.Write([]byte(command)); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 64)
+	n, err := port.Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b[:n])
+}
+
+// record makes a recording of two boards answering a command each.
+func record(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	r, err := NewRecorder(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := r.Wrap("ttyA", &scriptedPort{replies: [][]byte{[]byte("K\r\n"), []byte("SERIAL_NO:\r\nA1\r\n")}})
+	b := r.Wrap("ttyB", &scriptedPort{replies: [][]byte{[]byte("K\r\n")}})
+	exchange(t, a, "H\n")
+	exchange(t, b, "H\n")
+	exchange(t, a, "S\n")
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRecordRead(t *testing.T) {
+	rec, err := Read(bytes.NewReader(record(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rec.Ports) != 2 || rec.Ports[0] != "ttyA" || rec.Ports[1] != "ttyB" {
+		t.Errorf("ports = %q, want ttyA and ttyB", rec.Ports)
+	}
+	want := []Event{
+		{Port: "ttyA", Direction: Out, Data: []byte("H\n")},
+		{Port: "ttyA", Direction: In, Data: []byte("K\r\n")},
+		{Port: "ttyB", Direction: Out, Data: []byte("H\n")},
+		{Port: "ttyB", Direction: In, Data: []byte("K\r\n")},
+		{Port: "ttyA", Direction: Out, Data: []byte("S\n")},
+		{Port: "ttyA", Direction: In, Data: []byte("SERIAL_NO:\r\nA1\r\n")},
+	}
+	if len(rec.Events) != len(want) {
+		t.Fatalf("got %d events, want %d", len(rec.Events), len(want))
+	}
+	var last time.Duration
+	for i, e := range rec.Events {
+		if e.Port != want[i].Port || e.Direction != want[i].Direction || !bytes.Equal(e.Data, want[i].Data) {
+			t.Errorf("event %d = %s %s %q, want %s %s %q", i, e.Port, e.Direction, e.Data, want[i].Port, want[i].Direction, want[i].Data)
+		}
+		if e.At < last {
+			t.Errorf("event %d at %s is before the one before it at %s", i, e.At, last)
+		}
+		last = e.At
+	}
+}
+
+func TestReadCutShort(t *testing.T) {
+	data := record(t)
+	rec, err := Read(bytes.NewReader(data[:len(data)-3]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.Events) != 5 {
+		t.Errorf("got %d events, want the 5 complete ones", len(rec.Events))
+	}
+
+	if _, err := Read(bytes.NewReader([]byte("not a recording"))); err == nil {
+		t.Error("no error reading something that isn't a recording")
+	}
+}
+
+func TestReplayPort(t *testing.T) {
+	rec, err := Read(bytes.NewReader(record(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rec.Port("ttyC", ReplayOptions{}); err == nil {
+		t.Error("no error replaying a port that isn't in the recording")
+	}
+
+	port, err := rec.Port("ttyA", ReplayOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+	got, err := io.ReadAll(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "K\r\nSERIAL_NO:\r\nA1\r\n" {
+		t.Errorf("replayed %q", got)
+	}
+	if !port.Finished() {
+		t.Error("port not finished after reading everything")
+	}
+}
+
+func TestReplayPortLockstep(t *testing.T) {
+	rec, err := Read(bytes.NewReader(record(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := rec.Port("ttyA", ReplayOptions{Lockstep: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+	port.SetReadTimeout(50 * time.Millisecond)
+
+	b := make([]byte, 64)
+	if n, _ := port.Read(b); n != 0 {
+		t.Fatalf("read %q before sending the command", b[:n])
+	}
+	if got := exchange(t, port, "H\n"); got != "K\r\n" {
+		t.Errorf("reply to H = %q, want K", got)
+	}
+	if got := exchange(t, port, "X\n"); got != "SERIAL_NO:\r\nA1\r\n" {
+		t.Errorf("reply to X = %q, want the serial number", got)
+	}
+	if port.Mismatches() != 1 {
+		t.Errorf("%d mismatches, want 1 for X in place of S", port.Mismatches())
+	}
+}
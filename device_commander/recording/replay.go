@@ -0,0 +1,263 @@
+package recording
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// ReplayOptions control how a recorded port plays back.
+type ReplayOptions struct {
+	// Speed scales the recorded timing, 0 replays as fast as it can
+	Speed float64
+	// Lockstep holds back what the board sent after each command until the
+	// host sends that command, so the replay stands in for the board
+	Lockstep bool
+	// Hold keeps the port open once the recording ends instead of reading
+	// EOF, so the reader doesn't go looking for the board
+	Hold bool
+}
+
+var errReplayClosed = errors.New("replayed port closed")
+
+// ReplayPort is a serial.Port that plays back what one port received.
+type ReplayPort struct {
+	name   string
+	events []Event
+	opts   ReplayOptions
+
+	mu          sync.Mutex
+	out         bytes.Buffer // Recorded input not yet read
+	written     []byte       // From the host, not yet matched in lockstep
+	ended       bool
+	closed      bool
+	readTimeout time.Duration
+	mismatches  int
+
+	fed   chan struct{} // For readers, recorded input arrived
+	wrote chan struct{} // For lockstep, the host sent something
+	done  chan struct{}
+}
+
+// Port plays back the named port of the recording.
+func (r *Recording) Port(name string, opts ReplayOptions) (*ReplayPort, error) {
+	if opts.Speed < 0 {
+		return nil, fmt.Errorf("invalid replay speed: %v", opts.Speed)
+	}
+	p := &ReplayPort{
+		name:        name,
+		opts:        opts,
+		readTimeout: serial.NoTimeout,
+		fed:         make(chan struct{}, 1),
+		wrote:       make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+	for _, e := range r.Events {
+		if e.Port == name {
+			p.events = append(p.events, e)
+		}
+	}
+	if len(p.events) == 0 {
+		return nil, fmt.Errorf("no traffic for port %s in the recording", name)
+	}
+	go p.feed()
+	return p, nil
+}
+
+// Opener returns a function that opens the recording's ports by name in
+// place of serial.Open, e.g. for comms.OpenPort.
+func (r *Recording) Opener(opts ReplayOptions) func(string, *serial.Mode) (serial.Port, error) {
+	return func(name string, mode *serial.Mode) (serial.Port, error) {
+		return r.Port(name, opts)
+	}
+}
+
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// wait sleeps until the event is due on the replay clock, or returns false
+// when the port is closed.
+func (p *ReplayPort) wait(start time.Time, at time.Duration) bool {
+	if p.opts.Speed == 0 {
+		return true
+	}
+	delay := time.Until(start.Add(time.Duration(float64(at) / p.opts.Speed)))
+	if delay <= 0 {
+		return true
+	}
+	select {
+	case <-time.After(delay):
+		return true
+	case <-p.done:
+		return false
+	}
+}
+
+// matchCommand waits in lockstep until the host has written data, or
+// returns false when the port is closed.
+func (p *ReplayPort) matchCommand(data []byte) bool {
+	for {
+		p.mu.Lock()
+		if len(p.written) >= len(data) {
+			if !bytes.Equal(p.written[:len(data)], data) {
+				p.mismatches++
+				log.Printf("Replay of %s: expected %q, host sent %q", p.name, data, p.written[:len(data)])
+			}
+			p.written = p.written[len(data):]
+			p.mu.Unlock()
+			return true
+		}
+		p.mu.Unlock()
+
+		select {
+		case <-p.wrote:
+		case <-p.done:
+			return false
+		}
+	}
+}
+
+func (p *ReplayPort) feed() {
+	start := time.Now()
+	for _, e := range p.events {
+		if e.Direction == Out {
+			if !p.opts.Lockstep {
+				continue
+			}
+			if !p.matchCommand(e.Data) {
+				return
+			}
+			// What the board sent next keeps its timing from here
+			if p.opts.Speed > 0 {
+				start = time.Now().Add(-time.Duration(float64(e.At) / p.opts.Speed))
+			}
+			continue
+		}
+
+		if !p.wait(start, e.At) {
+			return
+		}
+		p.mu.Lock()
+		p.out.Write(e.Data)
+		p.mu.Unlock()
+		signal(p.fed)
+	}
+
+	p.mu.Lock()
+	p.ended = true
+	p.mu.Unlock()
+	signal(p.fed)
+}
+
+// Finished tells whether everything recorded has been read.
+func (p *ReplayPort) Finished() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ended && p.out.Len() == 0
+}
+
+// Mismatches is how many commands differed from the recording in lockstep.
+func (p *ReplayPort) Mismatches() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.mismatches
+}
+
+func (p *ReplayPort) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	timeout := p.readTimeout
+	p.mu.Unlock()
+
+	var deadline <-chan time.Time
+	if timeout != serial.NoTimeout {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return 0, errReplayClosed
+		}
+		if p.out.Len() > 0 {
+			n, _ := p.out.Read(b)
+			p.mu.Unlock()
+			// Others may be waiting too
+			signal(p.fed)
+			return n, nil
+		}
+		if p.ended && !p.opts.Hold {
+			p.mu.Unlock()
+			return 0, io.EOF
+		}
+		p.mu.Unlock()
+
+		select {
+		case <-p.fed:
+		case <-p.done:
+		case <-deadline:
+			return 0, nil
+		}
+	}
+}
+
+// Write takes what the host sends, to be matched against the recording in
+// lockstep and dropped otherwise.
+func (p *ReplayPort) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return 0, errReplayClosed
+	}
+	if p.opts.Lockstep {
+		p.written = append(p.written, b...)
+	}
+	p.mu.Unlock()
+	signal(p.wrote)
+	return len(b), nil
+}
+
+func (p *ReplayPort) ResetInputBuffer() error {
+	return nil
+}
+
+func (p *ReplayPort) ResetOutputBuffer() error {
+	return nil
+}
+
+func (p *ReplayPort) SetReadTimeout(t time.Duration) error {
+	p.mu.Lock()
+	p.readTimeout = t
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *ReplayPort) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.closed {
+		p.closed = true
+		close(p.done)
+	}
+	return nil
+}
+
+func (p *ReplayPort) SetMode(mode *serial.Mode) error { return nil }
+func (p *ReplayPort) Drain() error                    { return nil }
+func (p *ReplayPort) SetDTR(dtr bool) error           { return nil }
+func (p *ReplayPort) SetRTS(rts bool) error           { return nil }
+func (p *ReplayPort) Break(t time.Duration) error     { return nil }
+func (p *ReplayPort) GetModemStatusBits() (*serial.ModemStatusBits, error) {
+	return &serial.ModemStatusBits{}, nil
+}
@@ -0,0 +1,351 @@
+package show
+
+import (
+	"device_commander/lights"
+	"device_commander/logging"
+	"device_commander/motors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Shows are logged as motors, which they mostly drive.
+var logger = logging.Logger(logging.Motors)
+
+// MotorFunc sets the speed of a motor.
+type MotorFunc func(motorId int, speed float64) error
+
+type State string
+
+const (
+	Stopped State = "stopped"
+	Playing State = "playing"
+	Paused  State = "paused"
+)
+
+type Status struct {
+	Name     string `json:"name"`
+	State    State  `json:"state"`
+	Position int    `json:"position"` // Milliseconds
+	Length   int    `json:"length"`   // Milliseconds
+}
+
+// Player plays a show against one clock. Motor speeds and light cues are
+// both driven from the show position, so pausing freezes the lights along
+// with the motors and seeking moves both.
+type Player struct {
+	show   *Show
+	events []event
+	motors []int
+	length time.Duration
+	engine *lights.Engine
+	move   motors.MoveFunc
+	home   func() error // Called before playing from the start
+	// Angle segments leave boards in angle mode, so stops and seeks have to
+	// set the mode every time
+	positional bool
+
+	mu        sync.Mutex
+	state     State
+	offset    time.Duration // Show position when the clock last started or stopped
+	startedAt time.Time
+	next      int // Index of the next event to fire
+	wake      chan struct{}
+	done      chan struct{}
+}
+
+func NewPlayer(s *Show, engine *lights.Engine, move motors.MoveFunc) (*Player, error) {
+	events, err := s.timeline()
+	if err != nil {
+		return nil, err
+	}
+
+	// Build the light animations up front so media is loaded before playing
+	for i := range events {
+		if events[i].kind != lightEvent {
+			continue
+		}
+		animation, err := events[i].light.Build()
+		if err != nil {
+			return nil, fmt.Errorf("show %s: %w", s.Name, err)
+		}
+		events[i].animation = animation
+		events[i].transition = events[i].light.Transition
+	}
+
+	var motorIds []int
+	for _, mp := range s.Motors.Patterns {
+		motorIds = append(motorIds, mp.MotorId)
+	}
+	sort.Ints(motorIds)
+
+	p := &Player{
+		show:       s,
+		events:     events,
+		motors:     motorIds,
+		length:     s.Length(),
+		engine:     engine,
+		move:       move,
+		positional: s.Motors.Positional(),
+		state:      Stopped,
+		wake:       make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	go p.run()
+	return p, nil
+}
+
+// HomeBeforePlay sets a function that brings the motors to their neutral
+// pose whenever the show plays from the start.
+func (p *Player) HomeBeforePlay(home func() error) {
+	p.mu.Lock()
+	p.home = home
+	p.mu.Unlock()
+}
+
+// Close stops the player and its motors for good.
+func (p *Player) Close() {
+	p.Stop()
+	close(p.done)
+}
+
+// Play starts or resumes the show. A finished show starts from the top.
+func (p *Player) Play() {
+	p.mu.Lock()
+	if p.state == Playing {
+		p.mu.Unlock()
+		return
+	}
+	if p.position() >= p.length {
+		p.offset = 0
+		p.next = 0
+	}
+	home := p.home
+	fromStart := p.offset == 0
+	p.mu.Unlock()
+
+	// Homing takes a while, the clock starts once the panels are in place
+	if home != nil && fromStart {
+		if err := home(); err != nil {
+			logger.Warn("Playing show without all motors homed", "show", p.show.Name, "err", err)
+		}
+	}
+
+	p.mu.Lock()
+	p.state = Playing
+	p.startedAt = time.Now()
+	pos := p.offset
+	p.mu.Unlock()
+
+	logger.Info("Playing show", "show", p.show.Name, "from", pos)
+	p.applyState(pos, true)
+	p.signal()
+}
+
+// Pause freezes the show and stops the motors.
+func (p *Player) Pause() {
+	p.mu.Lock()
+	if p.state != Playing {
+		p.mu.Unlock()
+		return
+	}
+	p.offset = p.position()
+	p.state = Paused
+	p.mu.Unlock()
+
+	logger.Info("Paused show", "show", p.show.Name, "at", p.offset)
+	p.stopMotors()
+	p.signal()
+}
+
+// Stop stops the show and the motors and rewinds to the start.
+func (p *Player) Stop() {
+	p.mu.Lock()
+	p.state = Stopped
+	p.offset = 0
+	p.next = 0
+	p.mu.Unlock()
+
+	p.stopMotors()
+	p.signal()
+}
+
+// Seek moves the show to a position. Motors and lights are set to what they
+// would be at that point, motors only if the show is playing.
+func (p *Player) Seek(pos time.Duration) error {
+	if pos < 0 || pos > p.length {
+		return fmt.Errorf("position %s outside show of length %s", pos, p.length)
+	}
+
+	p.mu.Lock()
+	p.offset = pos
+	p.startedAt = time.Now()
+	p.next = sort.Search(len(p.events), func(i int) bool {
+		return p.events[i].at >= pos
+	})
+	playing := p.state == Playing
+	p.mu.Unlock()
+
+	logger.Info("Seeked show", "show", p.show.Name, "to", pos)
+	p.applyState(pos, playing)
+	p.signal()
+	return nil
+}
+
+// Position returns the current show position.
+func (p *Player) Position() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.position()
+}
+
+func (p *Player) position() time.Duration {
+	if p.state != Playing {
+		return p.offset
+	}
+	return p.offset + time.Since(p.startedAt)
+}
+
+func (p *Player) Status() Status {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return Status{
+		Name:     p.show.Name,
+		State:    p.state,
+		Position: int(p.position() / time.Millisecond),
+		Length:   int(p.length / time.Millisecond),
+	}
+}
+
+func (p *Player) signal() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (p *Player) run() {
+	for {
+		p.mu.Lock()
+		if p.state != Playing {
+			p.mu.Unlock()
+			select {
+			case <-p.wake:
+				continue
+			case <-p.done:
+				return
+			}
+		}
+
+		pos := p.position()
+		var due []event
+		for p.next < len(p.events) && p.events[p.next].at <= pos {
+			due = append(due, p.events[p.next])
+			p.next++
+		}
+
+		finished := false
+		var wait time.Duration
+		if p.next < len(p.events) {
+			wait = p.events[p.next].at - pos
+		} else if pos >= p.length {
+			finished = true
+			p.state = Stopped
+			p.offset = p.length
+		} else {
+			wait = p.length - pos
+		}
+		p.mu.Unlock()
+
+		// Fire outside the lock: moving motors is slow and rendering a
+		// light cue reads the position
+		for _, e := range due {
+			motors.ObserveLateness("show", p.Position()-e.at)
+			p.fire(e)
+		}
+		if finished {
+			logger.Info("Show finished", "show", p.show.Name)
+			p.stopMotors()
+			continue
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-p.wake:
+		case <-p.done:
+			timer.Stop()
+			return
+		}
+		timer.Stop()
+	}
+}
+
+func (p *Player) fire(e event) {
+	switch e.kind {
+	case motorEvent:
+		if err := p.move(e.motorId, e.target); err != nil {
+			logger.Error("Error moving motor", "motor", e.motorId, "err", err)
+		}
+	case lightEvent:
+		p.engine.Play(p.cueAnimation(e), e.transition)
+	}
+}
+
+// cueAnimation ties a light cue to the show clock instead of the engine's,
+// so the animation freezes with the show and follows seeks.
+func (p *Player) cueAnimation(e event) lights.Animation {
+	animation := e.animation
+	render := animation.Render
+	animation.Render = func(_ time.Duration, leds []lights.RGBW) {
+		render(max(0, p.Position()-e.at), leds)
+	}
+	return animation
+}
+
+// applyState sets the motors and lights to what they would be at pos: the
+// last motor target and light cue before it.
+func (p *Player) applyState(pos time.Duration, withMotors bool) {
+	targets := make(map[int]motors.Target)
+	var light *event
+	for i := range p.events {
+		e := &p.events[i]
+		if e.at >= pos {
+			break
+		}
+		switch e.kind {
+		case motorEvent:
+			targets[e.motorId] = e.target
+		case lightEvent:
+			light = e
+		}
+	}
+
+	if light != nil {
+		p.engine.Play(p.cueAnimation(*light), lights.Transition{})
+	}
+	if !withMotors {
+		return
+	}
+	for _, motorId := range p.motors {
+		target, ok := targets[motorId]
+		if !ok {
+			target = motors.Stop(pos)
+		}
+		target.SwitchMode = p.positional
+		if err := p.move(motorId, target); err != nil {
+			logger.Error("Error moving motor", "motor", motorId, "err", err)
+		}
+	}
+}
+
+func (p *Player) stopMotors() {
+	for _, motorId := range p.motors {
+		stop := motors.Stop(0)
+		stop.SwitchMode = p.positional
+		if err := p.move(motorId, stop); err != nil {
+			logger.Error("Error stopping motor", "motor", motorId, "err", err)
+		}
+	}
+}
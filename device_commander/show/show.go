@@ -0,0 +1,118 @@
+package show
+
+import (
+	"device_commander/lights"
+	"device_commander/motors"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// LightCue starts a light animation at a point on the show timeline.
+type LightCue struct {
+	At int `json:"at"` // Milliseconds from the start of the show
+	lights.PlaylistEntry
+}
+
+// SegmentCue fires a light animation every time a segment that moves the
+// motor starts, e.g. to flash the panel on each MIDI note.
+type SegmentCue struct {
+	MotorId int `json:"motorId"`
+	lights.PlaylistEntry
+}
+
+// Show puts motor tracks and light cues on one timeline.
+type Show struct {
+	Name          string         `json:"name"`
+	Motors        motors.Pattern `json:"motors"`
+	Lights        []LightCue     `json:"lights"`
+	SegmentLights []SegmentCue   `json:"segment_lights"`
+}
+
+func Load(path string) (*Show, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading show: %w", err)
+	}
+
+	var s Show
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("error decoding show: %w", err)
+	}
+	return &s, nil
+}
+
+type eventKind int
+
+const (
+	motorEvent eventKind = iota
+	lightEvent
+)
+
+// event is one thing that happens at a point on the timeline.
+type event struct {
+	at      time.Duration
+	kind    eventKind
+	motorId int
+	target  motors.Target
+	light   lights.PlaylistEntry
+
+	animation  lights.Animation // Built from light when the show is loaded
+	transition lights.Transition
+}
+
+// timeline flattens the show into events sorted by time. Motor events come
+// before light events at the same time so segment cues follow the motors.
+func (s *Show) timeline() ([]event, error) {
+	var events []event
+
+	if err := s.Motors.Validate(); err != nil {
+		return nil, err
+	}
+	for _, mp := range s.Motors.Patterns {
+		for _, target := range mp.Targets() {
+			events = append(events, event{at: target.At, kind: motorEvent, motorId: mp.MotorId, target: target})
+			for _, cue := range s.SegmentLights {
+				if cue.MotorId == mp.MotorId && (target.Value != 0 || target.Mode == motors.ModeAngle) {
+					events = append(events, event{at: target.At, kind: lightEvent, light: cue.PlaylistEntry})
+				}
+			}
+		}
+	}
+
+	for _, cue := range s.Lights {
+		if cue.At < 0 {
+			return nil, fmt.Errorf("light cue %s at negative time %d", cue.Animation, cue.At)
+		}
+		events = append(events, event{at: time.Duration(cue.At) * time.Millisecond, kind: lightEvent, light: cue.PlaylistEntry})
+	}
+
+	for _, e := range events {
+		if e.kind != lightEvent {
+			continue
+		}
+		if err := e.light.Transition.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].at != events[j].at {
+			return events[i].at < events[j].at
+		}
+		return events[i].kind < events[j].kind
+	})
+	return events, nil
+}
+
+// Length returns the time of the last event or the end of the longest motor
+// track, whichever is later.
+func (s *Show) Length() time.Duration {
+	length := s.Motors.Length()
+	for _, cue := range s.Lights {
+		length = max(length, time.Duration(cue.At)*time.Millisecond)
+	}
+	return length
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"device_commander/telemetry"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// toggleTelemetry turns the boards' monitoring on or off.
+func toggleTelemetry() {
+	if telemetryMonitor.Running() {
+		log.Println("Stopping telemetry")
+		telemetryMonitor.Stop(&connectionsMutex, connections)
+	} else {
+		log.Println("Starting telemetry")
+		telemetryMonitor.Start(&connectionsMutex, connections)
+	}
+}
+
+type telemetryStatus struct {
+	Enabled bool     `json:"enabled"`
+	Devices []string `json:"devices"`
+}
+
+// handleTelemetry turns telemetry on or off on POST with {"enabled": true}.
+// GET returns a device's samples of the last seconds, 10 by default, or the
+// status without a device.
+func handleTelemetry(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		deviceID := r.URL.Query().Get("device")
+		w.Header().Set("Content-Type", "application/json")
+		if deviceID == "" {
+			json.NewEncoder(w).Encode(telemetryStatus{Enabled: telemetryMonitor.Running(), Devices: telemetryMonitor.Devices()})
+			return
+		}
+
+		seconds := 10.0
+		if s := r.URL.Query().Get("seconds"); s != "" {
+			var err error
+			if seconds, err = strconv.ParseFloat(s, 64); err != nil || seconds <= 0 {
+				http.Error(w, fmt.Sprintf("Invalid seconds: %s", s), http.StatusBadRequest)
+				return
+			}
+		}
+		since := time.Now().Add(-time.Duration(seconds * float64(time.Second)))
+		samples := telemetryMonitor.Samples(deviceID, since)
+		if samples == nil {
+			samples = []telemetry.Sample{}
+		}
+		json.NewEncoder(w).Encode(samples)
+	case http.MethodPost:
+		var req struct {
+			Enabled bool `json:"enabled"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Enabled != telemetryMonitor.Running() {
+			toggleTelemetry()
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(fmt.Sprintf("Telemetry enabled: %v", req.Enabled)))
+	default:
+		http.Error(w, "Only GET and POST methods are allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleTelemetryStream sends samples as server-sent events as they arrive,
+// of one device or of all of them without one.
+func handleTelemetryStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	samples, cancel := telemetryMonitor.Subscribe(r.URL.Query().Get("device"))
+	defer cancel()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case s := <-samples:
+			data, err := json.Marshal(s)
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package telemetry
+
+import (
+	"device_commander/comms"
+	"sort"
+	"sync"
+	"time"
+)
+
+type Options struct {
+	Variables  Variables
+	Downsample int // Board loops per sample
+	BufferSize int // Samples kept per device
+}
+
+var DefaultOptions = Options{
+	Variables:  Target | VoltageQ | CurrentQ | Velocity | Angle,
+	Downsample: 50,
+	BufferSize: 2000,
+}
+
+type stream struct {
+	buffer *buffer
+	stop   func() // Stops listening to the connection, nil when not listening
+}
+
+// Monitor collects telemetry from every board while it runs.
+type Monitor struct {
+	mu          sync.Mutex
+	opts        Options
+	running     bool
+	streams     map[string]*stream
+	subscribers map[int]subscriber
+	nextID      int
+}
+
+type subscriber struct {
+	deviceID string // Empty for every device
+	ch       chan Sample
+}
+
+func NewMonitor(opts Options) *Monitor {
+	return &Monitor{
+		opts:        opts,
+		streams:     make(map[string]*stream),
+		subscribers: make(map[int]subscriber),
+	}
+}
+
+func (m *Monitor) Running() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.running
+}
+
+// Start turns monitoring on on every board and collects what they send.
+func (m *Monitor) Start(connectionsMutex *sync.Mutex, connections []*comms.SerialConnection) {
+	m.mu.Lock()
+	m.running = true
+	m.mu.Unlock()
+
+	connectionsMutex.Lock()
+	count := len(connections)
+	connectionsMutex.Unlock()
+	for index := 0; index < count; index++ {
+		m.Enable(index, connectionsMutex, connections)
+	}
+}
+
+// Enable turns monitoring on on one board, e.g. again after it reset or
+// was tuned.
+func (m *Monitor) Enable(index int, connectionsMutex *sync.Mutex, connections []*comms.SerialConnection) error {
+	connectionsMutex.Lock()
+	conn := connections[index]
+	connectionsMutex.Unlock()
+
+	// Listeners are called with the listener lock held and take the monitor
+	// lock, so the monitor lock isn't held while listening
+	deviceID := conn.DeviceID
+	stop := comms.Listen(conn, func(line string, at time.Time) {
+		m.add(deviceID, line, at)
+	})
+
+	m.mu.Lock()
+	s, ok := m.streams[deviceID]
+	if !ok {
+		s = &stream{buffer: newBuffer(m.opts.BufferSize)}
+		m.streams[deviceID] = s
+	}
+	if s.stop != nil {
+		// Already listening
+		defer stop()
+	} else {
+		s.stop = stop
+	}
+	m.mu.Unlock()
+
+	return Enable(index, m.opts.Variables, m.opts.Downsample, connectionsMutex, connections)
+}
+
+// Stop turns monitoring off on every board. The samples stay available.
+func (m *Monitor) Stop(connectionsMutex *sync.Mutex, connections []*comms.SerialConnection) {
+	m.mu.Lock()
+	m.running = false
+	var stops []func()
+	for _, s := range m.streams {
+		if s.stop != nil {
+			stops = append(stops, s.stop)
+			s.stop = nil
+		}
+	}
+	m.mu.Unlock()
+	for _, stop := range stops {
+		stop()
+	}
+
+	connectionsMutex.Lock()
+	count := len(connections)
+	connectionsMutex.Unlock()
+	for index := 0; index < count; index++ {
+		Disable(index, connectionsMutex, connections)
+	}
+}
+
+func (m *Monitor) add(deviceID, line string, at time.Time) {
+	s, ok := ParseLine(line, m.opts.Variables, at)
+	if !ok {
+		return
+	}
+	s.DeviceID = deviceID
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if st, ok := m.streams[deviceID]; ok {
+		st.buffer.add(s)
+	}
+	for _, sub := range m.subscribers {
+		if sub.deviceID != "" && sub.deviceID != deviceID {
+			continue
+		}
+		// Slow subscribers miss samples rather than hold up the reader
+		select {
+		case sub.ch <- s:
+		default:
+		}
+	}
+}
+
+// Subscribe returns the samples of a device as they arrive, or of every
+// device for an empty ID, until cancel is called.
+func (m *Monitor) Subscribe(deviceID string) (samples <-chan Sample, cancel func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := m.nextID
+	m.nextID++
+	ch := make(chan Sample, 100)
+	m.subscribers[id] = subscriber{deviceID: deviceID, ch: ch}
+
+	return ch, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if _, ok := m.subscribers[id]; ok {
+			delete(m.subscribers, id)
+			close(ch)
+		}
+	}
+}
+
+// Samples returns a device's buffered samples taken after since.
+func (m *Monitor) Samples(deviceID string, since time.Time) []Sample {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.streams[deviceID]
+	if !ok {
+		return nil
+	}
+	return s.buffer.since(since)
+}
+
+// Devices returns the IDs of the devices with samples.
+func (m *Monitor) Devices() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ids := make([]string, 0, len(m.streams))
+	for id := range m.streams {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
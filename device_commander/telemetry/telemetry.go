@@ -0,0 +1,174 @@
+// Package telemetry turns the SimpleFOC monitor stream of the boards into
+// timestamped samples, keeps the latest of them per device and hands them
+// to subscribers.
+package telemetry
+
+import (
+	"device_commander/comms"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Variables selects what the boards monitor, one bit per variable as
+// SimpleFOC numbers them.
+type Variables uint8
+
+const (
+	Angle Variables = 1 << iota
+	Velocity
+	CurrentD
+	CurrentQ
+	VoltageD
+	VoltageQ
+	Target
+)
+
+// In the order the boards print them
+var order = []Variables{Target, VoltageQ, VoltageD, CurrentQ, CurrentD, Velocity, Angle}
+
+// Command is the commander's monitor set command for the variables, e.g.
+// MMS1000010 for target and velocity.
+func (v Variables) Command() string {
+	bits := make([]byte, len(order))
+	for i, bit := range order {
+		bits[i] = '0'
+		if v&bit != 0 {
+			bits[i] = '1'
+		}
+	}
+	return "MMS" + string(bits)
+}
+
+// Count is how many values a monitor line has.
+func (v Variables) Count() int {
+	n := 0
+	for _, bit := range order {
+		if v&bit != 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// Sample is one monitor line. Variables that aren't monitored are zero.
+type Sample struct {
+	DeviceID string    `json:"device"`
+	At       time.Time `json:"-"`
+	Time     int64     `json:"t"` // Unix milliseconds
+	Target   float64   `json:"target"`
+	VoltageQ float64   `json:"voltageQ"` // V
+	VoltageD float64   `json:"voltageD"`
+	CurrentQ float64   `json:"currentQ"` // A
+	CurrentD float64   `json:"currentD"`
+	Velocity float64   `json:"velocity"` // rad/s
+	Angle    float64   `json:"angle"`    // rad
+}
+
+// ParseLine reads a monitor line of the given variables.
+func ParseLine(line string, vars Variables, at time.Time) (Sample, bool) {
+	values, ok := comms.ParseMonitorLine(line)
+	if !ok || len(values) != vars.Count() {
+		return Sample{}, false
+	}
+	s := Sample{At: at, Time: at.UnixMilli()}
+	fields := map[Variables]*float64{
+		Target:   &s.Target,
+		VoltageQ: &s.VoltageQ,
+		VoltageD: &s.VoltageD,
+		CurrentQ: &s.CurrentQ,
+		CurrentD: &s.CurrentD,
+		Velocity: &s.Velocity,
+		Angle:    &s.Angle,
+	}
+	i := 0
+	for _, bit := range order {
+		if vars&bit != 0 {
+			*fields[bit] = values[i]
+			i++
+		}
+	}
+	return s, true
+}
+
+// WriteCSV writes samples as one row each.
+func WriteCSV(w io.Writer, samples []Sample) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"t", "device", "target", "voltage_q", "voltage_d", "current_q", "current_d", "velocity", "angle"})
+	format := func(v float64) string { return strconv.FormatFloat(v, 'f', 4, 64) }
+	for _, s := range samples {
+		cw.Write([]string{
+			strconv.FormatInt(s.Time, 10),
+			s.DeviceID,
+			format(s.Target),
+			format(s.VoltageQ),
+			format(s.VoltageD),
+			format(s.CurrentQ),
+			format(s.CurrentD),
+			format(s.Velocity),
+			format(s.Angle),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Enable starts a board monitoring vars every downsample loops.
+func Enable(index int, vars Variables, downsample int, connectionsMutex *sync.Mutex, connections []*comms.SerialConnection) error {
+	if index < 0 || index >= len(connections) {
+		return fmt.Errorf("invalid motor ID: %d", index)
+	}
+	if downsample < 1 {
+		return fmt.Errorf("invalid monitor downsample: %d", downsample)
+	}
+	comms.WriteCommand(fmt.Sprintf("MMD%d", downsample), connectionsMutex, connections, index)
+	comms.WriteCommand(vars.Command(), connectionsMutex, connections, index)
+	return nil
+}
+
+// Disable stops a board monitoring.
+func Disable(index int, connectionsMutex *sync.Mutex, connections []*comms.SerialConnection) error {
+	if index < 0 || index >= len(connections) {
+		return fmt.Errorf("invalid motor ID: %d", index)
+	}
+	comms.WriteCommand(Variables(0).Command(), connectionsMutex, connections, index)
+	return nil
+}
+
+// buffer is a ring of the latest samples.
+type buffer struct {
+	samples []Sample
+	next    int
+	full    bool
+}
+
+func newBuffer(size int) *buffer {
+	return &buffer{samples: make([]Sample, size)}
+}
+
+func (b *buffer) add(s Sample) {
+	b.samples[b.next] = s
+	b.next = (b.next + 1) % len(b.samples)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// since returns the samples taken after t, oldest first.
+func (b *buffer) since(t time.Time) []Sample {
+	var ordered []Sample
+	if b.full {
+		ordered = append(ordered, b.samples[b.next:]...)
+	}
+	ordered = append(ordered, b.samples[:b.next]...)
+
+	for i, s := range ordered {
+		if s.At.After(t) {
+			return ordered[i:]
+		}
+	}
+	return nil
+}
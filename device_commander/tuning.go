@@ -0,0 +1,380 @@
+package main
+
+import (
+	"device_commander/comms"
+	"device_commander/motors"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// tuningForm is the F3 overlay for reading and setting one board's motor
+// parameters.
+type tuningForm struct {
+	mu       sync.Mutex
+	index    int // Connection index
+	deviceID string
+	values   map[string]float64
+	row      int
+	input    string
+	status   string
+	busy     bool
+}
+
+var tuning *tuningForm
+
+// toggleTuningForm opens the form for the selected device, or closes it.
+func toggleTuningForm() {
+	if tuning != nil {
+		tuning = nil
+		return
+	}
+	connectionsMutex.Lock()
+	if currentPortIndex >= len(connections) {
+		connectionsMutex.Unlock()
+		return
+	}
+	deviceID := connections[currentPortIndex].DeviceID
+	connectionsMutex.Unlock()
+
+	tuning = &tuningForm{index: currentPortIndex, deviceID: deviceID, values: make(map[string]float64)}
+	tuning.read()
+}
+
+// read fetches every parameter from the board in the background.
+func (f *tuningForm) read() {
+	f.mu.Lock()
+	if f.busy {
+		f.mu.Unlock()
+		return
+	}
+	f.busy = true
+	f.status = "Reading parameters..."
+	f.mu.Unlock()
+
+	go func() {
+		values, err := motors.ReadParams(f.index, &connectionsMutex, connections)
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.busy = false
+		for name, v := range values {
+			f.values[name] = v
+		}
+		if err != nil {
+			f.status = err.Error()
+		} else {
+			f.status = "Read all parameters"
+		}
+	}()
+}
+
+// set sends the typed value for the selected parameter. The form isn't
+// locked while sending, drawing holds the connections lock.
+func (f *tuningForm) set() {
+	f.mu.Lock()
+	p := motors.Params[f.row]
+	input := f.input
+	f.mu.Unlock()
+
+	value, err := strconv.ParseFloat(input, 64)
+	if err == nil {
+		err = motors.SetParam(f.index, p.Name, value, &connectionsMutex, connections)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err != nil {
+		f.status = err.Error()
+		return
+	}
+	f.values[p.Name] = value
+	f.input = ""
+	f.status = fmt.Sprintf("Set %s to %v", p.Name, value)
+}
+
+// save stores the values read or set as the device's profile.
+func (f *tuningForm) save() {
+	f.mu.Lock()
+	values := make(map[string]float64, len(f.values))
+	for name, v := range f.values {
+		values[name] = v
+	}
+	f.mu.Unlock()
+
+	err := saveDeviceParams(f.deviceID, values)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err != nil {
+		f.status = err.Error()
+	} else {
+		f.status = fmt.Sprintf("Saved profile for device %s", f.deviceID)
+	}
+}
+
+// handleKey handles a key press while the form is open.
+func (f *tuningForm) handleKey(ev *tcell.EventKey) {
+	switch ev.Key() {
+	case tcell.KeyEscape:
+		tuning = nil
+	case tcell.KeyUp:
+		f.mu.Lock()
+		f.row = (f.row - 1 + len(motors.Params)) % len(motors.Params)
+		f.input = ""
+		f.mu.Unlock()
+	case tcell.KeyDown:
+		f.mu.Lock()
+		f.row = (f.row + 1) % len(motors.Params)
+		f.input = ""
+		f.mu.Unlock()
+	case tcell.KeyEnter:
+		go f.set()
+	case tcell.KeyCtrlS:
+		go f.save()
+	case tcell.KeyCtrlR:
+		f.read()
+	case tcell.KeyBackspace, tcell.KeyBackspace2:
+		f.mu.Lock()
+		if len(f.input) > 0 {
+			f.input = f.input[:len(f.input)-1]
+		}
+		f.mu.Unlock()
+	case tcell.KeyRune:
+		f.mu.Lock()
+		f.input += string(ev.Rune())
+		f.mu.Unlock()
+	}
+}
+
+// draw draws the form over the device outputs.
+func (f *tuningForm) draw(width, height int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	formWidth := min(width, 72)
+	blank := tcell.StyleDefault.Background(tcell.ColorNavy).Foreground(tcell.ColorWhite)
+	for y := 0; y < len(motors.Params)+4 && y < height; y++ {
+		for x := 0; x < formWidth; x++ {
+			screen.SetContent(x, y, ' ', nil, blank)
+		}
+	}
+
+	drawStyledText(0, 0, formWidth, fmt.Sprintf(" Device %s motor parameters", f.deviceID), blank.Bold(true))
+	for i, p := range motors.Params {
+		value := "?"
+		if v, ok := f.values[p.Name]; ok {
+			value = strconv.FormatFloat(v, 'g', 6, 64)
+		}
+		style := blank
+		if i == f.row {
+			style = blank.Reverse(true)
+			if f.input != "" {
+				value += " -> " + f.input
+			}
+		}
+		line := fmt.Sprintf(" %-15s %-22s %-6s %s", p.Name, value, p.Unit, p.Description)
+		drawStyledText(0, i+1, formWidth, line, style)
+	}
+	drawStyledText(0, len(motors.Params)+1, formWidth, " "+f.status, blank)
+	drawStyledText(0, len(motors.Params)+2, formWidth, " Enter: set | Ctrl+S: save profile | Ctrl+R: reread | Esc/F3: close", blank)
+}
+
+// saveDeviceParams merges values into a device's parameter profile and
+// saves the devices file.
+func saveDeviceParams(deviceID string, values map[string]float64) error {
+	devicesMutex.Lock()
+	defer devicesMutex.Unlock()
+	device := comms.FindDevice(devices, deviceID)
+	if device == nil {
+		return fmt.Errorf("unknown device: %s", deviceID)
+	}
+	params := make(map[string]float64, len(device.Params)+len(values))
+	for name, v := range device.Params {
+		params[name] = v
+	}
+	for name, v := range values {
+		params[name] = v
+	}
+	device.Params = params
+	return comms.SaveDevices(devicesPath, devices)
+}
+
+// restoreDevice sends a board its zero offset and parameter profile. Boards
+// forget both when they reset, so it runs whenever one reports running or
+// its link is reopened.
+// Telemetry is turned back on too if it is running.
+func restoreDevice(deviceID string) {
+	connectionsMutex.Lock()
+	index := getDeviceIndex(deviceID)
+	connectionsMutex.Unlock()
+	if index < 0 {
+		return
+	}
+
+	devicesMutex.Lock()
+	device := comms.FindDevice(devices, deviceID)
+	if device == nil {
+		devicesMutex.Unlock()
+		return
+	}
+	offset := device.ZeroOffset
+	params := device.Params
+	devicesMutex.Unlock()
+
+	if offset != nil {
+		motors.SetZeroOffset(index, *offset, &connectionsMutex, connections)
+	}
+	if telemetryMonitor.Running() {
+		telemetryMonitor.Enable(index, &connectionsMutex, connections)
+	}
+	if len(params) > 0 {
+		motorsLogger.Info("Restoring motor parameters", "device", deviceID, "count", len(params))
+		if err := motors.ApplyParams(index, params, &connectionsMutex, connections); err != nil {
+			motorsLogger.Error("Error restoring parameters", "device", deviceID, "err", err)
+		}
+	}
+}
+
+type paramsRequest struct {
+	Values map[string]float64 `json:"values"`
+	Save   bool               `json:"save"` // Also store the values in the device's profile
+}
+
+type paramsResponse struct {
+	DeviceID string             `json:"device"`
+	Values   map[string]float64 `json:"values"` // Read from the board
+	Saved    map[string]float64 `json:"saved"`  // The device's profile
+}
+
+// handleMotorParams lists the parameters without a device, reads them from
+// the device's board on GET and sets them on POST.
+func handleMotorParams(w http.ResponseWriter, r *http.Request) {
+	deviceID := r.URL.Query().Get("device")
+	if deviceID == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Missing device", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(motors.Params)
+		return
+	}
+
+	connectionsMutex.Lock()
+	index := getDeviceIndex(deviceID)
+	connectionsMutex.Unlock()
+	if index < 0 {
+		http.Error(w, fmt.Sprintf("Device %s is not connected", deviceID), http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		values, err := motors.ReadParams(index, &connectionsMutex, connections)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusGatewayTimeout)
+			return
+		}
+
+		devicesMutex.Lock()
+		var saved map[string]float64
+		if device := comms.FindDevice(devices, deviceID); device != nil {
+			saved = device.Params
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(paramsResponse{DeviceID: deviceID, Values: values, Saved: saved})
+		devicesMutex.Unlock()
+	case http.MethodPost:
+		var req paramsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := motors.ApplyParams(index, req.Values, &connectionsMutex, connections); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Save {
+			if err := saveDeviceParams(deviceID, req.Values); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Parameters set"))
+	default:
+		http.Error(w, "Only GET and POST methods are allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+type tuneRequest struct {
+	From         *float64 `json:"from"`
+	To           *float64 `json:"to"`
+	Duration     int      `json:"duration"` // Milliseconds captured after each step
+	Iterations   int      `json:"iterations"`
+	MaxOvershoot float64  `json:"maxOvershoot"`
+	Save         bool     `json:"save"` // Store the best gains in the device's profile
+}
+
+// handleMotorTune tunes a device's velocity loop by step responses and
+// returns the trials. Unset options keep their defaults.
+func handleMotorTune(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	deviceID := r.URL.Query().Get("device")
+	connectionsMutex.Lock()
+	index := getDeviceIndex(deviceID)
+	connectionsMutex.Unlock()
+	if index < 0 {
+		http.Error(w, fmt.Sprintf("Device %s is not connected", deviceID), http.StatusNotFound)
+		return
+	}
+
+	var req tuneRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts := motors.DefaultTuneOptions
+	if req.From != nil {
+		opts.From = *req.From
+	}
+	if req.To != nil {
+		opts.To = *req.To
+	}
+	if req.Duration > 0 {
+		opts.Duration = time.Duration(req.Duration) * time.Millisecond
+	}
+	if req.Iterations > 0 {
+		opts.Iterations = req.Iterations
+	}
+	if req.MaxOvershoot > 0 {
+		opts.MaxOvershoot = req.MaxOvershoot
+	}
+
+	motorsLogger.Info("Tuning velocity loop", "device", deviceID)
+	result, err := motors.TuneVelocity(index, opts, &connectionsMutex, connections)
+	// Tuning turns the board's monitoring off
+	if telemetryMonitor.Running() {
+		telemetryMonitor.Enable(index, &connectionsMutex, connections)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if req.Save {
+		if err := saveDeviceParams(deviceID, result.Gains()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
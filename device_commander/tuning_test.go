@@ -0,0 +1,71 @@
+package main
+
+import (
+	"device_commander/comms"
+	"device_commander/emulator"
+	"device_commander/motors"
+	"testing"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// waitForParam waits until a board reports a parameter's value.
+func waitForParam(t *testing.T, name string, want float64) {
+	t.Helper()
+	var got float64
+	var err error
+	for attempt := 0; attempt < 20; attempt++ {
+		if got, err = motors.GetParam(0, name, &connectionsMutex, connections); err == nil && got == want {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("%s is %v (%v), want %v", name, got, err, want)
+}
+
+func TestResetBoardGetsProfileBack(t *testing.T) {
+	open := comms.OpenPort
+	t.Cleanup(func() { comms.OpenPort = open })
+	comms.OpenPort = func(string, *serial.Mode) (serial.Port, error) {
+		return emulator.NewBoard("RESET"), nil
+	}
+
+	devicesMutex.Lock()
+	devices = []comms.DeviceInfo{{DeviceID: "0", SerialPort: "emulated", Params: map[string]float64{"velocity.p": 0.9}}}
+	devicesMutex.Unlock()
+	conn := &comms.SerialConnection{Port: emulator.NewBoard("FIRST"), DeviceID: "0", PortName: "emulated"}
+	connectionsMutex.Lock()
+	connections = []*comms.SerialConnection{conn}
+	connectionsMutex.Unlock()
+	t.Cleanup(func() {
+		connectionsMutex.Lock()
+		connections = nil
+		connectionsMutex.Unlock()
+	})
+	go handleDeviceUpdates(conn)
+
+	comms.WriteCommand("I", &connectionsMutex, connections, 0)
+	waitForParam(t, "velocity.p", 0.9)
+
+	// Pulling the cable resets the board, which comes back with the
+	// firmware's defaults
+	connectionsMutex.Lock()
+	old := conn.Port
+	old.Close()
+	connectionsMutex.Unlock()
+	for attempt := 0; ; attempt++ {
+		connectionsMutex.Lock()
+		reopened := conn.Port != old
+		connectionsMutex.Unlock()
+		if reopened {
+			break
+		}
+		if attempt == 50 {
+			t.Fatal("the board was not reconnected")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	comms.WriteCommand("I", &connectionsMutex, connections, 0)
+	waitForParam(t, "velocity.p", 0.9)
+}